@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+// albumDupes lists albums recorded more than once in different folders, then pairs of albums that share most, but not
+// all, of their tracks.
+func albumDupes(stdout io.Writer, stderr io.Writer, args albumDupesArgs) {
+	albums := fetchAlbumsOrDie(stderr, args.dbPath)
+
+	dupes := library.DuplicateAlbums(albums)
+	for _, group := range dupes {
+		_, _ = fmt.Fprintf(stdout, "%s (%d tracks, %d copies)\n", describeAlbum(group[0]), len(group[0].Tracks),
+			len(group))
+		for _, album := range group {
+			_, _ = fmt.Fprintf(stdout, "  %s\n", album.Folder)
+		}
+	}
+
+	overlaps := library.Overlaps(albums, args.minOverlap)
+	for _, o := range overlaps {
+		_, _ = fmt.Fprintf(stdout, "%d tracks shared:\n", o.Shared)
+		for _, album := range []library.Album{o.A, o.B} {
+			_, _ = fmt.Fprintf(stdout, "  %s (%d tracks)  %s\n", describeAlbum(album), len(album.Tracks), album.Folder)
+		}
+	}
+
+	_, _ = fmt.Fprintf(stdout, "(%d duplicated albums, %d partial overlaps)\n", len(dupes), len(overlaps))
+}
+
+// albumCompletes looks for albums in an incoming folder with tracks missing from albums we only partly own.
+func albumCompletes(stdout io.Writer, stderr io.Writer, args albumCompletesArgs) {
+	dieUnlessDirectoryExists(stderr, args.directory)
+	owned := fetchAlbumsOrDie(stderr, args.dbPath)
+
+	files, err := mp3fileutil.FindMP3Files(args.directory)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	_, _ = fmt.Fprintf(stderr, "Reading %d files\n", len(files))
+	var songs []mp3util.Song
+	for _, file := range files {
+		song, err := mp3util.ParseMP3(file)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "skipping %q:  %s\n", file, err)
+			continue
+		}
+		songs = append(songs, song)
+	}
+
+	completions := library.Completions(owned, library.GroupAlbums(songs))
+	for _, c := range completions {
+		_, _ = fmt.Fprintf(stdout, "%s  %s (have %s)\n", describeAlbum(c.Owned), c.Owned.Folder, haveOf(c.Owned))
+		for _, track := range c.Adds {
+			_, _ = fmt.Fprintf(stdout, "  + %s\n", track.Path)
+		}
+		switch c.StillMissing {
+		case -1:
+			_, _ = fmt.Fprintln(stdout, "  (total tracks unknown)")
+		case 0:
+			_, _ = fmt.Fprintln(stdout, "  (complete)")
+		default:
+			_, _ = fmt.Fprintf(stdout, "  (still missing %d)\n", c.StillMissing)
+		}
+	}
+
+	_, _ = fmt.Fprintf(stdout, "(%d owned albums can be added to)\n", len(completions))
+}
+
+func fetchAlbumsOrDie(stderr io.Writer, dbPath string) []library.Album {
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	// albums are derived from Songs, so bring them up to date with whatever was recorded by older versions
+	err = db.RebuildAlbums()
+	if err != nil {
+		diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+	}
+	albums, err := db.FetchAlbums()
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	return albums
+}
+
+func describeAlbum(album library.Album) string {
+	if album.AlbumArtist == "" {
+		return album.Album
+	}
+	return album.AlbumArtist + " - " + album.Album
+}
+
+func haveOf(album library.Album) string {
+	if total := album.TotalTracks(); total > 0 {
+		return fmt.Sprintf("%d of %d", len(album.Tracks), total)
+	}
+	return fmt.Sprintf("%d", len(album.Tracks))
+}
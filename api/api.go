@@ -0,0 +1,259 @@
+// Package api serves the records database over HTTP as JSON, for tools that want to ask about the library without
+// running the command line.
+//
+//	GET  /songs?q=QUERY&sort=FIELDS&limit=N  songs matching a query (see query.Compile)
+//	GET  /search?q=WORDS&limit=N             ranked full-text search
+//	GET  /songs/HASH                         every song with an audio hash
+//	GET  /duplicates                         groups of songs sharing an audio hash
+//	POST /lookup                             hash an uploaded file and list the songs it matches
+//	POST /scans                              start recording a directory; GET /scans and /scans/ID report on it
+//
+// Errors are returned as {"error": "..."} with a 4xx or 5xx status.
+package api
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/query"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultLimit = 50
+
+// DefaultMaxUpload is the largest file /lookup accepts unless Server.MaxUpload says otherwise.
+const DefaultMaxUpload = 512 << 20
+
+// Server answers API requests from a RecordKeeper.  Scans are only allowed under Roots, so with no roots every scan is
+// refused.
+type Server struct {
+	Roots     []string
+	MaxUpload int64
+
+	db  *records.RecordKeeper
+	mux *http.ServeMux
+
+	jobsLock sync.Mutex
+	jobs     []*Job
+	// held while a scan runs, so that scans happen one at a time
+	scanLock sync.Mutex
+	running  sync.WaitGroup
+}
+
+// NewServer makes a server over db, which it doesn't close.  It needs SQLite, so it's only any use in builds
+// with cgo.
+func NewServer(db *records.RecordKeeper, roots []string) *Server {
+	s := &Server{Roots: roots, MaxUpload: DefaultMaxUpload, db: db, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/songs", s.handleSongs)
+	s.mux.HandleFunc("/songs/", s.handleSongsByHash)
+	s.mux.HandleFunc("/search", s.handleSearch)
+	s.mux.HandleFunc("/duplicates", s.handleDuplicates)
+	s.mux.HandleFunc("/lookup", s.handleLookup)
+	s.mux.HandleFunc("/scans", s.handleScans)
+	s.mux.HandleFunc("/scans/", s.handleScan)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Wait blocks until every scan that has been started finishes.
+func (s *Server) Wait() {
+	s.running.Wait()
+}
+
+func (s *Server) handleSongs(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	compiled, err := query.Compile(r.URL.Query().Get("q"), r.URL.Query().Get("sort"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad query:  %s", err))
+		return
+	}
+
+	songs, err := s.db.QuerySongs(compiled, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nonNil(songs))
+}
+
+func (s *Server) handleSongsByHash(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	hash := strings.TrimPrefix(r.URL.Path, "/songs/")
+	if _, err := hex.DecodeString(hash); err != nil || hash == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("%q isn't a hash", hash))
+		return
+	}
+
+	songs, err := s.db.FetchSongsByHash(strings.ToLower(hash))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(songs) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no songs with hash %s", hash))
+		return
+	}
+	writeJSON(w, http.StatusOK, songs)
+}
+
+// SearchResult is a song found by /search, with its relevance (higher is better).
+type SearchResult struct {
+	Song  mp3util.Song `json:"song"`
+	Score float64      `json:"score"`
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	text := r.URL.Query().Get("q")
+	if strings.TrimSpace(text) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("nothing to search for"))
+		return
+	}
+
+	results, err := s.db.Search(text, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	response := []SearchResult{}
+	for _, result := range results {
+		response = append(response, SearchResult{result.Song, result.Score})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	groups, err := s.db.FetchDuplicates()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if groups == nil {
+		groups = [][]mp3util.Song{}
+	}
+	writeJSON(w, http.StatusOK, groups)
+}
+
+// Lookup is the answer to /lookup:  the uploaded file's hashes and every recorded song with the same audio.
+type Lookup struct {
+	Hash     string  `json:"hash"`
+	FileHash string  `json:"fileHash"`
+	Found    bool    `json:"found"`
+	Matches  []Match `json:"matches"`
+}
+
+// Match is a recorded song with the same audio as an uploaded file, and whether the file is an exact copy of it.
+type Match struct {
+	Song  mp3util.Song `json:"song"`
+	Match string       `json:"match"`
+}
+
+// handleLookup accepts the file either as the raw request body or as the "file" field of a multipart form.
+func (s *Server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.MaxUpload)
+
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("expected a file in the \"file\" field:  %s", err))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed to read the upload:  %s", err))
+		return
+	}
+	hash, err := mp3util.Hash(b)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("failed to read the MP3:  %s", err))
+		return
+	}
+	fileHash := mp3util.FileHash(b)
+	lookup := Lookup{Hash: hex.EncodeToString(hash[:]), FileHash: hex.EncodeToString(fileHash[:]), Matches: []Match{}}
+
+	songs, err := s.db.FetchSongsByHash(lookup.Hash)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, song := range songs {
+		lookup.Matches = append(lookup.Matches, Match{song, mp3util.Song{FileHash: lookup.FileHash}.MatchKind(song)})
+	}
+	lookup.Found = len(lookup.Matches) > 0
+	writeJSON(w, http.StatusOK, lookup)
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s isn't allowed here", r.Method))
+	return false
+}
+
+func limitParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad limit %q", value))
+		return 0, false
+	}
+	return limit, true
+}
+
+func nonNil(songs []mp3util.Song) []mp3util.Song {
+	if songs == nil {
+		return []mp3util.Song{}
+	}
+	return songs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,200 @@
+//go:build cgo
+// +build cgo
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer records the fixtures into an in-memory database and serves it, allowing scans under scanRoot.
+func newTestServer(t *testing.T, name string, scanRoot string) (*httptest.Server, *Server) {
+	db, err := records.Open(fmt.Sprintf("file:%s.db?cache=shared&mode=memory", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := mp3fileutil.FindMP3Files(testHelpers.GetFixturePath(""))
+	for _, file := range files {
+		song, err := mp3util.ParseMP3(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = db.RecordSong(song)
+	}
+
+	server := NewServer(db, []string{scanRoot})
+	ts := httptest.NewServer(server)
+	t.Cleanup(func() {
+		ts.Close()
+		server.Wait()
+		_ = db.Close()
+	})
+	return ts, server
+}
+
+func getJSON(t *testing.T, url string, expectedStatus int, v interface{}) {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != expectedStatus {
+		b, _ := ioutil.ReadAll(res.Body)
+		t.Fatalf("Wrong status for %s  \r\nExpected:  %v  \r\nActual:  %v (%s)", url, expectedStatus, res.StatusCode, b)
+	}
+	err = json.NewDecoder(res.Body).Decode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSongsAndSearch(t *testing.T) {
+	ts, _ := newTestServer(t, "apisongs", os.TempDir())
+
+	var songs []mp3util.Song
+	getJSON(t, ts.URL+"/songs?q=title:chicken", http.StatusOK, &songs)
+	if len(songs) != 1 || filepath.Base(songs[0].Path) != "spring-chicken.mp3" {
+		t.Errorf("Unexpected songs:  %+v", songs)
+	}
+
+	getJSON(t, ts.URL+"/songs?sort=-size&limit=2", http.StatusOK, &songs)
+	if len(songs) != 2 || songs[0].Size < songs[1].Size {
+		t.Errorf("Expected the two largest songs:  %+v", songs)
+	}
+
+	var failure map[string]string
+	getJSON(t, ts.URL+"/songs?q=nosuchfield:x", http.StatusBadRequest, &failure)
+	if failure["error"] == "" {
+		t.Error("Expected an error message for a bad query")
+	}
+
+	var results []SearchResult
+	getJSON(t, ts.URL+"/search?q=SPRING", http.StatusOK, &results)
+	if len(results) != 1 || results[0].Song.Title != "Spring Chicken" {
+		t.Errorf("Unexpected search results:  %+v", results)
+	}
+}
+
+func TestSongsByHashAndDuplicates(t *testing.T) {
+	ts, _ := newTestServer(t, "apihash", os.TempDir())
+
+	var groups [][]mp3util.Song
+	getJSON(t, ts.URL+"/duplicates", http.StatusOK, &groups)
+	if len(groups) != 1 || len(groups[0]) != 4 {
+		t.Fatalf("Expected the four wakka wakka files to be duplicates:  %+v", groups)
+	}
+
+	var songs []mp3util.Song
+	getJSON(t, ts.URL+"/songs/"+groups[0][0].Hash, http.StatusOK, &songs)
+	if len(songs) != 4 {
+		t.Errorf("Expected four songs with the hash:  %+v", songs)
+	}
+
+	var failure map[string]string
+	getJSON(t, ts.URL+"/songs/00", http.StatusNotFound, &failure)
+	getJSON(t, ts.URL+"/songs/xyz", http.StatusBadRequest, &failure)
+}
+
+func TestLookup(t *testing.T) {
+	ts, _ := newTestServer(t, "apilookup", os.TempDir())
+	b, err := ioutil.ReadFile(testHelpers.GetFixturePath("spring-chicken.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(ts.URL+"/lookup", "audio/mpeg", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lookup Lookup
+	_ = json.NewDecoder(res.Body).Decode(&lookup)
+	_ = res.Body.Close()
+	if !lookup.Found || len(lookup.Matches) != 1 || lookup.Matches[0].Match != mp3util.IdenticalFile {
+		t.Errorf("Expected an identical match:  %+v", lookup)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", "upload.mp3")
+	_, _ = part.Write(b)
+	_ = mw.Close()
+	res, err = http.Post(ts.URL+"/lookup", mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup = Lookup{}
+	_ = json.NewDecoder(res.Body).Decode(&lookup)
+	_ = res.Body.Close()
+	if !lookup.Found {
+		t.Errorf("Expected a match for a multipart upload:  %+v", lookup)
+	}
+
+	res, err = http.Get(ts.URL + "/lookup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status for GET /lookup  \r\nExpected:  %v  \r\nActual:  %v", http.StatusMethodNotAllowed,
+			res.StatusCode)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_ = mp3fileutil.CopyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(dir, "new.mp3"))
+	_ = ioutil.WriteFile(filepath.Join(dir, "broken.mp3"), []byte("not an mp3"), 0644)
+
+	ts, server := newTestServer(t, "apiscan", dir)
+
+	res, err := http.Post(ts.URL+"/scans", "application/json", bytes.NewBufferString(`{"directory": "/"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Wrong status scanning outside the roots  \r\nExpected:  %v  \r\nActual:  %v", http.StatusForbidden,
+			res.StatusCode)
+	}
+
+	body, _ := json.Marshal(scanRequest{dir})
+	res, err = http.Post(ts.URL+"/scans", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	_ = json.NewDecoder(res.Body).Decode(&job)
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || job.ID != 1 {
+		t.Fatalf("Unexpected response starting a scan:  %v %+v", res.StatusCode, job)
+	}
+
+	server.Wait()
+	getJSON(t, fmt.Sprintf("%s/scans/%d", ts.URL, job.ID), http.StatusOK, &job)
+	if job.Status != JobDone || job.Recorded != 1 || job.Unreadable != 1 {
+		t.Errorf("Unexpected job after scanning:  %+v", job)
+	}
+
+	var songs []mp3util.Song
+	getJSON(t, ts.URL+"/songs?q=path:new.mp3", http.StatusOK, &songs)
+	if len(songs) != 1 {
+		t.Errorf("Expected the scanned song to be recorded:  %+v", songs)
+	}
+}
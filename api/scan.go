@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a scan started through /scans.  Recorded counts the files parsed and saved, Unreadable those that couldn't
+// be; files already recorded with the same size are skipped.
+type Job struct {
+	ID         int    `json:"id"`
+	Directory  string `json:"directory"`
+	Status     string `json:"status"`
+	Recorded   int    `json:"recorded"`
+	Unreadable int    `json:"unreadable"`
+	Error      string `json:"error,omitempty"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+}
+
+type scanRequest struct {
+	Directory string `json:"directory"`
+}
+
+func (s *Server) handleScans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.jobsLock.Lock()
+		jobs := []Job{}
+		for _, job := range s.jobs {
+			jobs = append(jobs, *job)
+		}
+		s.jobsLock.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var request scanRequest
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("expected {\"directory\": ...}:  %s", err))
+			return
+		}
+		directory, err := s.scannable(request.Directory)
+		if err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, s.startScan(directory))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s isn't allowed here", r.Method))
+	}
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/scans/"))
+
+	s.jobsLock.Lock()
+	defer s.jobsLock.Unlock()
+	if err != nil || id < 1 || id > len(s.jobs) {
+		writeError(w, http.StatusNotFound, errors.New("no such scan"))
+		return
+	}
+	writeJSON(w, http.StatusOK, *s.jobs[id-1])
+}
+
+// scannable makes directory absolute and checks that it's one of the roots or under one.
+func (s *Server) scannable(directory string) (string, error) {
+	if directory == "" {
+		return "", errors.New("a directory is required")
+	}
+	directory, err := filepath.Abs(directory)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range s.Roots {
+		rel, err := filepath.Rel(root, directory)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return directory, nil
+		}
+	}
+	return "", fmt.Errorf("%q isn't under a directory this server may scan", directory)
+}
+
+// startScan queues a scan of directory, returning a copy of its job as it stands.
+func (s *Server) startScan(directory string) Job {
+	s.jobsLock.Lock()
+	job := &Job{ID: len(s.jobs) + 1, Directory: directory, Status: JobQueued}
+	s.jobs = append(s.jobs, job)
+	queued := *job
+	s.jobsLock.Unlock()
+
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.scanLock.Lock()
+		defer s.scanLock.Unlock()
+		s.runScan(job)
+	}()
+
+	return queued
+}
+
+func (s *Server) runScan(job *Job) {
+	s.updateJob(job, func(j *Job) {
+		j.Status = JobRunning
+		j.StartedAt = time.Now().Format(time.RFC3339)
+	})
+
+	err := s.scan(job)
+
+	s.updateJob(job, func(j *Job) {
+		j.Status = JobDone
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+		}
+		j.FinishedAt = time.Now().Format(time.RFC3339)
+	})
+}
+
+func (s *Server) scan(job *Job) error {
+	songs, err := s.db.FetchSongs()
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]mp3util.Song)
+	for _, song := range songs {
+		existing[song.Path] = song
+	}
+
+	files, err := mp3fileutil.FindMP3Files(job.Directory)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if song, ok := existing[file]; ok && song.FileHash != "" && song.Size == info.Size() {
+			continue
+		}
+		song, err := mp3util.ParseMP3(file)
+		if err != nil {
+			s.updateJob(job, func(j *Job) { j.Unreadable++ })
+			continue
+		}
+		song.Collection = existing[file].Collection
+		err = s.db.RecordSong(song)
+		if err != nil {
+			return fmt.Errorf("error saving %q:  %s", file, err)
+		}
+		s.updateJob(job, func(j *Job) { j.Recorded++ })
+	}
+
+	return s.db.RebuildAlbums()
+}
+
+func (s *Server) updateJob(job *Job, update func(*Job)) {
+	s.jobsLock.Lock()
+	defer s.jobsLock.Unlock()
+	update(job)
+}
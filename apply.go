@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/plan"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+func apply(stdout io.Writer, stderr io.Writer, args applyArgs) {
+	p, err := plan.Load(args.planPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	if args.dryRun {
+		p.Print(stdout)
+		return
+	}
+
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	runID, err := plan.Apply(stdout, db, p, args.trashDir)
+	if err != nil {
+		if runID != "" {
+			_, _ = fmt.Fprintf(stderr, "run %s stopped part of the way through; undo it with \"smartmp3mgr undo %s\"\n",
+				runID, runID)
+		}
+		diePrintln(stderr, err)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Applied %d operations as run %s\n", len(p.Operations), runID)
+}
+
+func undo(stdout io.Writer, stderr io.Writer, args undoArgs) {
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	if args.list {
+		runs, err := db.FetchRuns()
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		for _, run := range runs {
+			status := ""
+			if run.UndoneAt != "" {
+				status = " (undone " + run.UndoneAt + ")"
+			}
+			_, _ = fmt.Fprintf(stdout, "%s\t%s\t%s%s\n", run.RunID, run.StartedAt, run.Description, status)
+		}
+		return
+	}
+
+	err = plan.Undo(stdout, db, args.runID)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	_, _ = fmt.Fprintf(stdout, "Undid run %s\n", args.runID)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/catalogue"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"time"
+)
+
+// exportLibrary writes every Songs and Caches row to args.path ("-" for stdout).
+func exportLibrary(stdout io.Writer, stderr io.Writer, args exportArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database:  %s\n", err)
+	}
+	hashes, err := db.GetHashes()
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	var caches []catalogue.Cache
+	for path, cached := range hashes {
+		caches = append(caches, catalogue.Cache{Path: path, Hash: cached.Hash, FileHash: cached.FileHash})
+	}
+	c := catalogue.New(songs, caches, time.Now().Format(time.RFC3339))
+
+	out := stdout
+	if args.path != "-" {
+		f, err := os.Create(args.path)
+		if err != nil {
+			diePrintf(stderr, "failed to create %q:  %s\n", args.path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = catalogue.Write(out, args.format, c)
+	if err != nil {
+		diePrintf(stderr, "failed to write the catalogue:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stderr, "(exported %d songs and %d cached hashes)\n", len(c.Songs), len(c.Caches))
+}
+
+// importLibrary loads a catalogue written by exportLibrary into the database.
+func importLibrary(stdout io.Writer, stderr io.Writer, args importArgs) {
+	in, err := os.Open(args.path)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer in.Close()
+	c, err := catalogue.Read(in, args.format)
+	if err != nil {
+		diePrintf(stderr, "failed to read %q:  %s\n", args.path, err)
+	}
+
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	caches := make(map[string]records.CachedHash)
+	for _, cache := range c.Caches {
+		caches[cache.Path] = records.CachedHash{Hash: cache.Hash, FileHash: cache.FileHash}
+	}
+	result, err := db.Import(c.Songs, caches, args.onConflict)
+	if err != nil {
+		diePrintf(stderr, "import failed, so nothing was imported:  %s\n", err)
+	}
+	err = db.RebuildAlbums()
+	if err != nil {
+		diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "%d songs added, %d updated, %d skipped, %d unchanged\n", result.Added, result.Updated,
+		result.Skipped, result.Unchanged)
+}
@@ -0,0 +1,230 @@
+// Package catalogue reads and writes the contents of the records database (every Songs and Caches row) as JSON Lines
+// or CSV, for backups, diffs and moving between machines.  Rows are written in path order, so that exports of similar
+// databases diff cleanly.  CSV catalogues leave out the files' modification times, which mean nothing on another
+// machine, and the genre frames as written, which only lint uses.
+package catalogue
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the format written; Read refuses anything newer.  Version 2 added the Collection column to
+// CSV catalogues.
+const Version = 2
+
+const formatName = "smartmp3mgr-catalogue"
+
+var Formats = []string{"jsonl", "csv"}
+
+// Meta describes an export.
+type Meta struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	ExportedAt string `json:"exportedAt,omitempty"`
+	Songs      int    `json:"songs"`
+	Caches     int    `json:"caches"`
+}
+
+// Cache is a Caches row:  hashes find-new remembers for a file that may not be recorded as a song.
+type Cache struct {
+	Path     string `json:"path"`
+	Hash     string `json:"hash"`
+	FileHash string `json:"fileHash"`
+}
+
+type Catalogue struct {
+	Meta   Meta
+	Songs  []mp3util.Song
+	Caches []Cache
+}
+
+// New makes a catalogue of the songs and caches, sorted by path, with Meta filled in.
+func New(songs []mp3util.Song, caches []Cache, exportedAt string) Catalogue {
+	c := Catalogue{Songs: append([]mp3util.Song(nil), songs...), Caches: append([]Cache(nil), caches...)}
+	sort.Slice(c.Songs, func(i, j int) bool { return c.Songs[i].Path < c.Songs[j].Path })
+	sort.Slice(c.Caches, func(i, j int) bool { return c.Caches[i].Path < c.Caches[j].Path })
+	c.Meta = Meta{Format: formatName, Version: Version, ExportedAt: exportedAt, Songs: len(songs), Caches: len(caches)}
+	return c
+}
+
+// line is one line of the JSON Lines format; Type says which of the other fields is set.
+type line struct {
+	Type  string        `json:"type"`
+	Meta  *Meta         `json:"meta,omitempty"`
+	Song  *mp3util.Song `json:"song,omitempty"`
+	Cache *Cache        `json:"cache,omitempty"`
+}
+
+var csvHeader = []string{"Type", "Path", "Artist", "AlbumArtist", "Album", "Title", "Genre", "TrackNumber",
+	"TotalTracks", "DiscNumber", "TotalDiscs", "Bitrate", "SampleRate", "Duration", "Size", "TagFormat", "Hash",
+	"FileHash", "Collection"}
+
+// Write writes the catalogue as "jsonl" (a meta line, then a line per song and per cache) or "csv" (a comment line
+// holding the meta as JSON, then a row per song and per cache, with the Type column saying which).
+func Write(w io.Writer, format string, c Catalogue) error {
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		err := enc.Encode(line{Type: "meta", Meta: &c.Meta})
+		for i := 0; err == nil && i < len(c.Songs); i++ {
+			err = enc.Encode(line{Type: "song", Song: &c.Songs[i]})
+		}
+		for i := 0; err == nil && i < len(c.Caches); i++ {
+			err = enc.Encode(line{Type: "cache", Cache: &c.Caches[i]})
+		}
+		return err
+	case "csv":
+		meta, err := json.Marshal(c.Meta)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "# %s\n", meta)
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(w)
+		_ = cw.Write(csvHeader)
+		for _, s := range c.Songs {
+			_ = cw.Write([]string{"song", s.Path, s.Artist, s.AlbumArtist, s.Album, s.Title, s.Genre,
+				strconv.Itoa(s.TrackNumber), strconv.Itoa(s.TotalTracks), strconv.Itoa(s.DiscNumber),
+				strconv.Itoa(s.TotalDiscs), strconv.Itoa(s.Bitrate), strconv.Itoa(s.SampleRate), strconv.Itoa(s.Duration),
+				strconv.FormatInt(s.Size, 10), s.TagFormat, s.Hash, s.FileHash, s.Collection})
+		}
+		for _, cache := range c.Caches {
+			_ = cw.Write([]string{"cache", cache.Path, "", "", "", "", "", "", "", "", "", "", "", "", "", "", cache.Hash,
+				cache.FileHash, ""})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Read reads a catalogue written by Write.
+func Read(r io.Reader, format string) (Catalogue, error) {
+	var c Catalogue
+	var err error
+	switch format {
+	case "jsonl":
+		c, err = readJSONLines(r)
+	case "csv":
+		c, err = readCSV(r)
+	default:
+		return c, fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return c, err
+	}
+
+	if c.Meta.Format != formatName {
+		return c, errors.New("not a smartmp3mgr catalogue")
+	}
+	if c.Meta.Version > Version {
+		return c, fmt.Errorf("the catalogue is version %d, but only version %d and before can be read", c.Meta.Version,
+			Version)
+	}
+	return c, nil
+}
+
+func readJSONLines(r io.Reader) (Catalogue, error) {
+	var c Catalogue
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var l line
+		err := json.Unmarshal(scanner.Bytes(), &l)
+		if err != nil {
+			return c, fmt.Errorf("line %d:  %s", n, err)
+		}
+		switch {
+		case l.Type == "meta" && l.Meta != nil:
+			c.Meta = *l.Meta
+		case l.Type == "song" && l.Song != nil:
+			c.Songs = append(c.Songs, *l.Song)
+		case l.Type == "cache" && l.Cache != nil:
+			c.Caches = append(c.Caches, *l.Cache)
+		default:
+			return c, fmt.Errorf("line %d:  unexpected %q line", n, l.Type)
+		}
+	}
+	return c, scanner.Err()
+}
+
+func readCSV(r io.Reader) (Catalogue, error) {
+	var c Catalogue
+	br := bufio.NewReader(r)
+	first, err := br.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return c, err
+	}
+	if !strings.HasPrefix(first, "# ") {
+		return c, errors.New("the first line should be the catalogue's metadata")
+	}
+	err = json.Unmarshal([]byte(first[2:]), &c.Meta)
+	if err != nil {
+		return c, fmt.Errorf("bad metadata:  %s", err)
+	}
+
+	cr := csv.NewReader(br)
+	// every row has as many fields as the header, which in version 1 catalogues is without Collection
+	cr.FieldsPerRecord = 0
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return c, err
+	}
+	header := strings.Join(csvHeader, ",")
+	if len(rows) == 0 || (strings.Join(rows[0], ",") != header &&
+		strings.Join(rows[0], ",") != strings.Join(csvHeader[:len(csvHeader)-1], ",")) {
+		return c, errors.New("unexpected CSV header")
+	}
+
+	for i, row := range rows[1:] {
+		switch row[0] {
+		case "song":
+			song, err := songFromRow(row)
+			if err != nil {
+				return c, fmt.Errorf("row %d:  %s", i+1, err)
+			}
+			c.Songs = append(c.Songs, song)
+		case "cache":
+			c.Caches = append(c.Caches, Cache{Path: row[1], Hash: row[16], FileHash: row[17]})
+		default:
+			return c, fmt.Errorf("row %d:  unexpected type %q", i+1, row[0])
+		}
+	}
+	return c, nil
+}
+
+func songFromRow(row []string) (mp3util.Song, error) {
+	s := mp3util.Song{Path: row[1], Artist: row[2], AlbumArtist: row[3], Album: row[4], Title: row[5], Genre: row[6],
+		TagFormat: row[15], Hash: row[16], FileHash: row[17]}
+	if len(row) > 18 {
+		s.Collection = row[18]
+	}
+	var err error
+	for i, n := range []*int{&s.TrackNumber, &s.TotalTracks, &s.DiscNumber, &s.TotalDiscs, &s.Bitrate, &s.SampleRate,
+		&s.Duration} {
+		*n, err = strconv.Atoi(row[7+i])
+		if err != nil {
+			return s, fmt.Errorf("bad %s %q", csvHeader[7+i], row[7+i])
+		}
+	}
+	s.Size, err = strconv.ParseInt(row[14], 10, 64)
+	if err != nil {
+		return s, fmt.Errorf("bad Size %q", row[14])
+	}
+	return s, nil
+}
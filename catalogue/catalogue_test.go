@@ -0,0 +1,87 @@
+package catalogue
+
+import (
+	"bytes"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var songs = []mp3util.Song{{
+	Path:        "/music/b, with a comma.mp3",
+	Artist:      "Starpoint",
+	Album:       "Restless",
+	Title:       "Object of \"My\" Desire",
+	Hash:        "adfd",
+	Genre:       "R&B",
+	AlbumArtist: "Starpoint",
+	TrackNumber: 1,
+	TotalTracks: 12,
+	DiscNumber:  1,
+	TotalDiscs:  1,
+	FileHash:    "beef",
+	TagFormat:   "ID3v2.3",
+	Bitrate:     320,
+	SampleRate:  44100,
+	Duration:    301,
+	Size:        12345678,
+	Collection:  "canonical",
+}, {
+	Path:   "/music/a.mp3",
+	Artist: "浜崎あゆみ",
+	Title:  "オリアの木\nwith a newline",
+	Hash:   "cfdk",
+}}
+
+var caches = []Cache{{"/incoming/x.mp3", "adfd", ""}, {"/incoming/a.mp3", "cfdk", "f00d"}}
+
+func TestRoundTrip(t *testing.T) {
+	expected := New(songs, caches, "2020-10-31T00:00:00Z")
+	if expected.Songs[0].Path != "/music/a.mp3" || expected.Caches[0].Path != "/incoming/a.mp3" {
+		t.Errorf("Expected rows sorted by path:  %+v", expected)
+	}
+
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		err := Write(&buf, format, expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := Read(&buf, format)
+		if err != nil {
+			t.Fatalf("%s:  %s", format, err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s round trip doesn't match  \r\nExpected:  %+v  \r\nActual:  %+v", format, expected, actual)
+		}
+	}
+}
+
+func TestReadRefusesNewerVersions(t *testing.T) {
+	jsonl := `{"type":"meta","meta":{"format":"smartmp3mgr-catalogue","version":99,"songs":0,"caches":0}}`
+	_, err := Read(strings.NewReader(jsonl), "jsonl")
+	if err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("Expected an error about the version, got %v", err)
+	}
+
+	_, err = Read(strings.NewReader(`{"type":"song","song":{"Path":"x"}}`), "jsonl")
+	if err == nil {
+		t.Error("Expected an error reading a file without metadata")
+	}
+}
+
+func TestReadVersion1CSV(t *testing.T) {
+	// version 1 catalogues had every column but Collection
+	csv := `# {"format":"smartmp3mgr-catalogue","version":1,"songs":1,"caches":0}` + "\n" +
+		strings.Join(csvHeader[:len(csvHeader)-1], ",") + "\n" +
+		"song,/music/a.mp3,Starpoint,,,,,1,0,0,0,0,0,0,0,,adfd,\n"
+	c, err := Read(strings.NewReader(csv), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []mp3util.Song{{Path: "/music/a.mp3", Artist: "Starpoint", TrackNumber: 1, Hash: "adfd"}}
+	if !reflect.DeepEqual(c.Songs, expected) {
+		t.Errorf("Unexpected songs  \r\nExpected:  %+v  \r\nActual:  %+v", expected, c.Songs)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+// collectionName is how a collection is shown; songs recorded without one are in the collection with no name.
+func collectionName(name string) string {
+	if name == "" {
+		return "(none)"
+	}
+	return name
+}
+
+// listCollections prints each collection and how many songs are in it.
+func listCollections(stdout io.Writer, stderr io.Writer, args collectionArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	collections, err := db.FetchCollections()
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	for _, c := range collections {
+		_, _ = fmt.Fprintf(stdout, "%s\t%d\n", collectionName(c.Name), c.Songs)
+	}
+}
+
+// renameCollection moves every song in args.names[0] into args.names[1].
+func renameCollection(stdout io.Writer, stderr io.Writer, args collectionArgs) {
+	from, to := args.names[0], args.names[1]
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	renamed, err := db.RenameCollection(from, to)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	if renamed == 0 {
+		diePrintf(stderr, "no songs are recorded in collection %q\n", from)
+	}
+	_, _ = fmt.Fprintf(stdout, "Moved %d songs from %s to %s\n", renamed, collectionName(from), collectionName(to))
+}
+
+// dropCollection forgets every song in args.names[0].  The files themselves are left alone.
+func dropCollection(stdout io.Writer, stderr io.Writer, args collectionArgs) {
+	name := args.names[0]
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	dropped, err := db.DropCollection(name)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	if dropped == 0 {
+		diePrintf(stderr, "no songs are recorded in collection %q\n", name)
+	}
+	err = db.RebuildAlbums()
+	if err != nil {
+		diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stdout, "Forgot %d songs in %s\n", dropped, collectionName(name))
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// comparedSong is one song (one audio hash) in a comparison, with where it is on each side that has it.
+type comparedSong struct {
+	Hash        string `json:"hash"`
+	Title       string `json:"title"`
+	DiscNumber  int    `json:"discNumber,omitempty"`
+	TrackNumber int    `json:"trackNumber,omitempty"`
+	PathA       string `json:"pathA,omitempty"`
+	PathB       string `json:"pathB,omitempty"`
+}
+
+type comparedAlbum struct {
+	Artist string         `json:"artist"`
+	Album  string         `json:"album"`
+	Songs  []comparedSong `json:"songs"`
+}
+
+// comparison is two libraries' songs sorted into those only in the first, only in the second and in both, matched by
+// audio hash and grouped by album.
+type comparison struct {
+	OnlyInA []comparedAlbum `json:"onlyInA"`
+	OnlyInB []comparedAlbum `json:"onlyInB"`
+	InBoth  []comparedAlbum `json:"inBoth"`
+}
+
+// compareLibraries compares a and b.  Songs in both are described by the tags of whichever side's copy is better
+// tagged.
+func compareLibraries(a []mp3util.Song, b []mp3util.Song) comparison {
+	byHashA, byHashB := songsByHash(a), songsByHash(b)
+	var onlyA, onlyB, both []comparedEntry
+	for hash, songA := range byHashA {
+		if songB, ok := byHashB[hash]; ok {
+			tags := songA
+			if tagCount(songB) > tagCount(songA) {
+				tags = songB
+			}
+			both = append(both, comparedEntry{tags, songA.Path, songB.Path})
+		} else {
+			onlyA = append(onlyA, comparedEntry{songA, songA.Path, ""})
+		}
+	}
+	for hash, songB := range byHashB {
+		if _, ok := byHashA[hash]; !ok {
+			onlyB = append(onlyB, comparedEntry{songB, "", songB.Path})
+		}
+	}
+	return comparison{OnlyInA: groupCompared(onlyA), OnlyInB: groupCompared(onlyB), InBoth: groupCompared(both)}
+}
+
+// compareWithManifest compares a with the library a manifest describes.  A manifest only holds hashes, so nothing can
+// be said about what's only in it, and OnlyInB is left nil.
+func compareWithManifest(a []mp3util.Song, m manifest.Manifest) comparison {
+	var onlyA, both []comparedEntry
+	for hash, song := range songsByHash(a) {
+		if m.Contains(hash) {
+			both = append(both, comparedEntry{song, song.Path, ""})
+		} else {
+			onlyA = append(onlyA, comparedEntry{song, song.Path, ""})
+		}
+	}
+	return comparison{OnlyInA: groupCompared(onlyA), InBoth: groupCompared(both)}
+}
+
+// comparedEntry is a song before grouping:  the song whose tags describe it and its paths on each side.
+type comparedEntry struct {
+	tags         mp3util.Song
+	pathA, pathB string
+}
+
+// songsByHash picks one song per audio hash, the first by path.
+func songsByHash(songs []mp3util.Song) map[string]mp3util.Song {
+	result := make(map[string]mp3util.Song)
+	for _, song := range songs {
+		if existing, ok := result[song.Hash]; song.Hash != "" && (!ok || song.Path < existing.Path) {
+			result[song.Hash] = song
+		}
+	}
+	return result
+}
+
+func tagCount(song mp3util.Song) int {
+	count := 0
+	for _, tag := range []string{song.Artist, song.AlbumArtist, song.Album, song.Title} {
+		if tag != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// groupCompared groups entries by album artist (or artist) and album, sorted by name, each in disc and track order.
+func groupCompared(entries []comparedEntry) []comparedAlbum {
+	type key struct{ artist, album string }
+	byAlbum := make(map[key]*comparedAlbum)
+	result := []comparedAlbum{}
+	var keys []key
+	for _, e := range entries {
+		k := key{e.tags.AlbumArtist, e.tags.Album}
+		if k.artist == "" {
+			k.artist = e.tags.Artist
+		}
+		if byAlbum[k] == nil {
+			byAlbum[k] = &comparedAlbum{Artist: k.artist, Album: k.album}
+			keys = append(keys, k)
+		}
+		title := e.tags.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(e.tags.Path), filepath.Ext(e.tags.Path))
+		}
+		byAlbum[k].Songs = append(byAlbum[k].Songs, comparedSong{Hash: e.tags.Hash, Title: title,
+			DiscNumber: e.tags.DiscNumber, TrackNumber: e.tags.TrackNumber, PathA: e.pathA, PathB: e.pathB})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if a, b := strings.ToLower(keys[i].artist), strings.ToLower(keys[j].artist); a != b {
+			return a < b
+		}
+		return strings.ToLower(keys[i].album) < strings.ToLower(keys[j].album)
+	})
+	for _, k := range keys {
+		album := *byAlbum[k]
+		sort.Slice(album.Songs, func(i, j int) bool {
+			a, b := album.Songs[i], album.Songs[j]
+			if a.DiscNumber != b.DiscNumber {
+				return a.DiscNumber < b.DiscNumber
+			}
+			if a.TrackNumber != b.TrackNumber {
+				return a.TrackNumber < b.TrackNumber
+			}
+			return a.Title < b.Title
+		})
+		result = append(result, album)
+	}
+	return result
+}
+
+func countCompared(albums []comparedAlbum) int {
+	count := 0
+	for _, album := range albums {
+		count += len(album.Songs)
+	}
+	return count
+}
+
+// compare reports which songs are only in args.a, only in args.b (or the manifest) and in both.
+func compare(stdout io.Writer, stderr io.Writer, args compareArgs) {
+	songsA := fetchAllSongsOrDie(stderr, args.a)
+
+	var c comparison
+	nameB := args.b
+	if args.manifest != "" {
+		m, err := manifest.ReadFile(args.manifest)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		c = compareWithManifest(songsA, m)
+		nameB = args.manifest
+	} else {
+		c = compareLibraries(songsA, fetchAllSongsOrDie(stderr, args.b))
+	}
+
+	if args.format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(c)
+		if err != nil {
+			diePrintf(stderr, "failed to write results:  %s\n", err)
+		}
+		return
+	}
+
+	sections := []struct {
+		heading string
+		albums  []comparedAlbum
+	}{{"Only in " + args.a, c.OnlyInA}, {"Only in " + nameB, c.OnlyInB}, {"In both", c.InBoth}}
+	for i, section := range sections {
+		if i == 1 && args.manifest != "" {
+			_, _ = fmt.Fprintf(stdout, "%s:  (a manifest can't say what's only in it)\n", section.heading)
+			continue
+		}
+		_, _ = fmt.Fprintf(stdout, "%s:  %d songs on %d albums\n", section.heading, countCompared(section.albums),
+			len(section.albums))
+		for _, album := range section.albums {
+			name := album.Album
+			if name == "" {
+				name = "(no album)"
+			}
+			if album.Artist != "" {
+				name = album.Artist + " - " + name
+			}
+			_, _ = fmt.Fprintf(stdout, "  %s (%d)\n", name, len(album.Songs))
+			if args.songs {
+				for _, song := range album.Songs {
+					_, _ = fmt.Fprintf(stdout, "    %s\n", song.Title)
+				}
+			}
+		}
+	}
+}
+
+// fetchAllSongsOrDie reads the songs from an existing database, rather than creating it as opening it would.
+func fetchAllSongsOrDie(stderr io.Writer, dbPath string) []mp3util.Song {
+	if _, err := os.Stat(dbPath); err != nil {
+		diePrintln(stderr, err)
+	}
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database %q:  %s\n", dbPath, err)
+	}
+	return songs
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// defaultDbName is the database in the home directory used without -dbPath.
+const defaultDbName = ".smartmp3mgr.sql"
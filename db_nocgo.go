@@ -0,0 +1,8 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+// defaultDbName is the database in the home directory used without -dbPath.  SQLite needs cgo, so builds without it
+// keep songs in a file store instead.
+const defaultDbName = ".smartmp3mgr.jsonl"
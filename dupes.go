@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+// dupes lists recorded songs that share an audio hash, saying for each copy whether it's identical to the first one
+// (safe to delete blindly) or only has the same audio.
+func dupes(stdout io.Writer, stderr io.Writer, args dupesArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	groups, err := db.FetchDuplicates()
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	copies := 0
+	for _, group := range groups {
+		_, _ = fmt.Fprintf(stdout, "%s (%d copies)\n", group[0].Hash, len(group))
+		_, _ = fmt.Fprintf(stdout, "  %s\n", group[0].Path)
+		for _, song := range group[1:] {
+			_, _ = fmt.Fprintf(stdout, "  %s  [%s]\n", song.Path, song.MatchKind(group[0]))
+			copies++
+		}
+	}
+
+	_, _ = fmt.Fprintf(stdout, "(%d redundant copies in %d groups)\n", copies, len(groups))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+)
+
+// exportManifest writes the audio hash of every recorded song to args.path ("-" for stdout), for find-new -manifest.
+func exportManifest(stdout io.Writer, stderr io.Writer, args exportManifestArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database:  %s\n", err)
+	}
+	var hashes []string
+	for _, song := range songs {
+		if song.Hash != "" {
+			hashes = append(hashes, song.Hash)
+		}
+	}
+
+	out := stdout
+	if args.path != "-" {
+		f, err := os.Create(args.path)
+		if err != nil {
+			diePrintf(stderr, "failed to create %q:  %s\n", args.path, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = manifest.Write(out, args.format, hashes, args.falsePositiveRate)
+	if err != nil {
+		diePrintf(stderr, "failed to write the manifest:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stderr, "(exported the hashes of %d songs)\n", len(songs))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	statusNew       = "new"
+	statusDuplicate = "duplicate"
+	// statusCopy is a new file with the same audio as another new file in the same batch.
+	statusCopy       = "copy"
+	statusUnreadable = "unreadable"
+)
+
+// listedInManifest is the Match of a duplicate found in a -manifest rather than in the database.
+const listedInManifest = "listed in a manifest"
+
+var findNewFormats = []string{"text", "json", "jsonl", "csv", "null", "m3u"}
+
+// findNewResult is what find-new found out about one incoming file.  DuplicateOf and Match are set for duplicates
+// (describing the best of the Matches) and for copies (describing the new file they copy), Alternates for new files
+// that have copies, and Error for unreadable files.
+type findNewResult struct {
+	Path        string        `json:"path"`
+	Status      string        `json:"status"`
+	DuplicateOf string        `json:"duplicateOf,omitempty"`
+	Match       string        `json:"match,omitempty"`
+	Matches     []resultMatch `json:"matches,omitempty"`
+	Alternates  []string      `json:"alternates,omitempty"`
+	Hash        string        `json:"hash,omitempty"`
+	FileHash    string        `json:"fileHash,omitempty"`
+	Tags        *resultTags   `json:"tags,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// resultMatch is a recorded song that an incoming file duplicates.
+type resultMatch struct {
+	Path  string      `json:"path"`
+	Match string      `json:"match"`
+	Tags  *resultTags `json:"tags"`
+}
+
+type resultTags struct {
+	Artist      string `json:"artist"`
+	AlbumArtist string `json:"albumArtist"`
+	Album       string `json:"album"`
+	Title       string `json:"title"`
+	Genre       string `json:"genre"`
+	TrackNumber int    `json:"trackNumber"`
+	TotalTracks int    `json:"totalTracks"`
+	DiscNumber  int    `json:"discNumber"`
+	TotalDiscs  int    `json:"totalDiscs"`
+}
+
+func newResultTags(song mp3util.Song) *resultTags {
+	return &resultTags{
+		Artist:      song.Artist,
+		AlbumArtist: song.AlbumArtist,
+		Album:       song.Album,
+		Title:       song.Title,
+		Genre:       song.Genre,
+		TrackNumber: song.TrackNumber,
+		TotalTracks: song.TotalTracks,
+		DiscNumber:  song.DiscNumber,
+		TotalDiscs:  song.TotalDiscs,
+	}
+}
+
+func formatNeedsTags(format string) bool {
+	return format == "json" || format == "jsonl" || format == "csv" || format == "m3u"
+}
+
+// writeFindNewResults writes results to stdout in the requested format.  The structured formats (json, jsonl, csv)
+// describe every file; the rest are lists of the new files only, or of their folders with foldersOnly.  Text output
+// follows each new file with any copies of it in the same batch.
+func writeFindNewResults(w io.Writer, format string, foldersOnly bool, results []findNewResult) error {
+	switch format {
+	case "json":
+		if results == nil {
+			results = []findNewResult{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, result := range results {
+			err := enc.Encode(result)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeFindNewCSV(w, results)
+	case "m3u":
+		_, err := fmt.Fprintln(w, "#EXTM3U")
+		if err != nil {
+			return err
+		}
+		for _, result := range newResults(results) {
+			_, err = fmt.Fprintf(w, "#EXTINF:-1,%s\n%s\n", m3uTitle(result), result.Path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "null":
+		for _, path := range newPaths(results, foldersOnly) {
+			_, err := fmt.Fprintf(w, "%s\x00", path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		if foldersOnly {
+			for _, path := range newPaths(results, foldersOnly) {
+				_, err := fmt.Fprintln(w, path)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, result := range newResults(results) {
+			_, err := fmt.Fprintln(w, result.Path)
+			if err != nil {
+				return err
+			}
+			for _, alternate := range result.Alternates {
+				_, err = fmt.Fprintf(w, "  + %s\n", alternate)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// writeDuplicateDetails lists every file that wasn't new, each followed by the recorded songs it matched, so that a
+// surprising "you already have this" can be checked without opening the database.
+func writeDuplicateDetails(w io.Writer, results []findNewResult) error {
+	for _, r := range results {
+		if r.Status == statusNew {
+			continue
+		}
+		_, err := fmt.Fprintf(w, "%s  [%s]\n", r.Path, r.Status)
+		if err != nil {
+			return err
+		}
+		if r.Error != "" {
+			_, _ = fmt.Fprintf(w, "    %s\n", r.Error)
+		}
+		if r.Status == statusCopy {
+			_, _ = fmt.Fprintf(w, "    = %s  [%s]\n", r.DuplicateOf, r.Match)
+		}
+		for _, m := range r.Matches {
+			_, err = fmt.Fprintf(w, "    = %s  [%s]\n      %s\n", m.Path, m.Match, describeTags(m.Tags))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func describeTags(tags *resultTags) string {
+	if tags == nil {
+		return "(no tags)"
+	}
+	return fmt.Sprintf("%q / %q / %q (track %d/%d, disc %d/%d)", tags.Artist, tags.Album, tags.Title,
+		tags.TrackNumber, tags.TotalTracks, tags.DiscNumber, tags.TotalDiscs)
+}
+
+func writeFindNewCSV(w io.Writer, results []findNewResult) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"Path", "Status", "DuplicateOf", "Match", "Hash", "FileHash", "Artist", "AlbumArtist",
+		"Album", "Title", "Genre", "TrackNumber", "TotalTracks", "DiscNumber", "TotalDiscs", "Error"})
+	for _, r := range results {
+		tags := r.Tags
+		if tags == nil {
+			tags = &resultTags{}
+		}
+		_ = cw.Write([]string{r.Path, r.Status, r.DuplicateOf, r.Match, r.Hash, r.FileHash, tags.Artist,
+			tags.AlbumArtist, tags.Album, tags.Title, tags.Genre, strconv.Itoa(tags.TrackNumber),
+			strconv.Itoa(tags.TotalTracks), strconv.Itoa(tags.DiscNumber), strconv.Itoa(tags.TotalDiscs), r.Error})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func newResults(results []findNewResult) []findNewResult {
+	var result []findNewResult
+	for _, r := range results {
+		if r.Status == statusNew {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// newPaths lists the new files, or with foldersOnly, each folder holding at least one new file.  Copies of new files
+// don't count, so a folder holding nothing but copies of songs found elsewhere in the batch isn't listed.
+func newPaths(results []findNewResult, foldersOnly bool) []string {
+	var paths []string
+	folders := make(map[string]bool)
+	for _, r := range newResults(results) {
+		if !foldersOnly {
+			paths = append(paths, r.Path)
+			continue
+		}
+		f := filepath.Dir(r.Path)
+		if !folders[f] {
+			folders[f] = true
+			paths = append(paths, f)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func m3uTitle(r findNewResult) string {
+	if r.Tags == nil || r.Tags.Title == "" {
+		return filepath.Base(r.Path)
+	}
+	if r.Tags.Artist == "" {
+		return r.Tags.Title
+	}
+	return r.Tags.Artist + " - " + r.Tags.Title
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+const (
+	verdictNew        = "entirely new"
+	verdictPartial    = "partially owned"
+	verdictOwned      = "fully owned"
+	verdictUnreadable = "unreadable"
+)
+
+// groupSummary counts what find-new found in one folder or album.  Copies of new songs count as new, since they
+// aren't owned either.
+type groupSummary struct {
+	Name       string `json:"name"`
+	New        int    `json:"new"`
+	Duplicate  int    `json:"duplicate"`
+	Unreadable int    `json:"unreadable"`
+	Verdict    string `json:"verdict"`
+}
+
+// summarise groups results by folder or by album ("album", keyed on AlbumArtist, or Artist if that's missing, and
+// Album).
+func summarise(results []findNewResult, by string) []groupSummary {
+	groups := make(map[string]*groupSummary)
+	var names []string
+	for _, r := range results {
+		name := filepath.Dir(r.Path)
+		if by == "album" {
+			name = albumName(r.Tags)
+		}
+		g, ok := groups[name]
+		if !ok {
+			g = &groupSummary{Name: name}
+			groups[name] = g
+			names = append(names, name)
+		}
+		switch r.Status {
+		case statusDuplicate:
+			g.Duplicate++
+		case statusUnreadable:
+			g.Unreadable++
+		default:
+			g.New++
+		}
+	}
+
+	var result []groupSummary
+	for _, name := range names {
+		g := groups[name]
+		switch {
+		case g.New > 0 && g.Duplicate == 0:
+			g.Verdict = verdictNew
+		case g.New > 0:
+			g.Verdict = verdictPartial
+		case g.Duplicate > 0:
+			g.Verdict = verdictOwned
+		default:
+			g.Verdict = verdictUnreadable
+		}
+		result = append(result, *g)
+	}
+	return result
+}
+
+func albumName(tags *resultTags) string {
+	if tags == nil || tags.Album == "" {
+		return "(no album)"
+	}
+	artist := tags.AlbumArtist
+	if artist == "" {
+		artist = tags.Artist
+	}
+	if artist == "" {
+		artist = "(no artist)"
+	}
+	return artist + " - " + tags.Album
+}
+
+// sortSummaries orders summaries by name, or with "new", by the number of new tracks (most first).
+func sortSummaries(summaries []groupSummary, by string) {
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if by == "new" && summaries[i].New != summaries[j].New {
+			return summaries[i].New > summaries[j].New
+		}
+		return summaries[i].Name < summaries[j].Name
+	})
+}
+
+func writeSummaries(w io.Writer, format string, summaries []groupSummary) error {
+	switch format {
+	case "json":
+		if summaries == nil {
+			summaries = []groupSummary{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summaries)
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		for _, s := range summaries {
+			err := enc.Encode(s)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"Name", "New", "Duplicate", "Unreadable", "Verdict"})
+		for _, s := range summaries {
+			_ = cw.Write([]string{s.Name, strconv.Itoa(s.New), strconv.Itoa(s.Duplicate), strconv.Itoa(s.Unreadable),
+				s.Verdict})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "NEW\tDUPLICATE\tUNREADABLE\tVERDICT\tNAME")
+		for _, s := range summaries {
+			_, _ = fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\n", s.New, s.Duplicate, s.Unreadable, s.Verdict, s.Name)
+		}
+		return tw.Flush()
+	}
+}
@@ -9,6 +9,6 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.4
 	github.com/schollz/progressbar/v3 v3.6.2
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897 // indirect
-	golang.org/x/sys v0.0.0-20201027140754-0fcbb8f4928c // indirect
+	golang.org/x/sys v0.0.0-20201027140754-0fcbb8f4928c
 	golang.org/x/text v0.3.3 // indirect
 )
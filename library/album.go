@@ -0,0 +1,273 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const VariousArtists = "Various Artists"
+
+// Album is the songs in one folder sharing an Album tag; Fingerprint hashes its sorted track hashes.
+type Album struct {
+	AlbumArtist, Album, Folder string
+	DiscCount                  int
+	Tracks                     []mp3util.Song
+	Fingerprint                string
+}
+
+// GroupAlbums groups the songs with an Album tag by folder and album, ordered by both.
+func GroupAlbums(songs []mp3util.Song) []Album {
+	type key struct{ folder, album string }
+	byKey := make(map[key]*Album)
+	var keys []key
+	for _, song := range songs {
+		if song.Album == "" {
+			continue
+		}
+		k := key{filepath.Dir(song.Path), song.Album}
+		album, ok := byKey[k]
+		if !ok {
+			album = &Album{Album: song.Album, Folder: k.folder}
+			byKey[k] = album
+			keys = append(keys, k)
+		}
+		album.Tracks = append(album.Tracks, song)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].folder != keys[j].folder {
+			return keys[i].folder < keys[j].folder
+		}
+		return keys[i].album < keys[j].album
+	})
+
+	var result []Album
+	for _, k := range keys {
+		album := byKey[k]
+		sortTracks(album.Tracks)
+		album.AlbumArtist = albumArtist(album.Tracks)
+		album.DiscCount = discCount(album.Tracks)
+		album.Fingerprint = Fingerprint(album.Tracks)
+		result = append(result, *album)
+	}
+	return result
+}
+
+func sortTracks(tracks []mp3util.Song) {
+	sort.Slice(tracks, func(i, j int) bool {
+		a, b := tracks[i], tracks[j]
+		if a.DiscNumber != b.DiscNumber {
+			return a.DiscNumber < b.DiscNumber
+		}
+		if a.TrackNumber != b.TrackNumber {
+			return a.TrackNumber < b.TrackNumber
+		}
+		return a.Path < b.Path
+	})
+}
+
+func albumArtist(tracks []mp3util.Song) string {
+	for _, track := range tracks {
+		if track.AlbumArtist != "" {
+			return track.AlbumArtist
+		}
+	}
+	artist := tracks[0].Artist
+	for _, track := range tracks[1:] {
+		if track.Artist != artist {
+			return VariousArtists
+		}
+	}
+	return artist
+}
+
+// discCount is the most discs the tags claim, or the highest disc number seen if that's more.
+func discCount(tracks []mp3util.Song) int {
+	count := 1
+	for _, track := range tracks {
+		if track.TotalDiscs > count {
+			count = track.TotalDiscs
+		}
+		if track.DiscNumber > count {
+			count = track.DiscNumber
+		}
+	}
+	return count
+}
+
+// Fingerprint hashes the sorted, distinct track hashes, so it doesn't depend on tags, file names or track order.
+func Fingerprint(tracks []mp3util.Song) string {
+	hashes := trackHashes(tracks)
+	var sorted []string
+	for hash := range hashes {
+		sorted = append(sorted, hash)
+	}
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func trackHashes(tracks []mp3util.Song) map[string]bool {
+	result := make(map[string]bool)
+	for _, track := range tracks {
+		result[track.Hash] = true
+	}
+	return result
+}
+
+// TotalTracks is the largest track count claimed by any track's tags, or 0 if none say.
+func (a Album) TotalTracks() int {
+	total := 0
+	for _, track := range a.Tracks {
+		if track.TotalTracks > total {
+			total = track.TotalTracks
+		}
+	}
+	return total
+}
+
+// DuplicateAlbums returns the groups of albums in different folders with the same fingerprint.
+func DuplicateAlbums(albums []Album) [][]Album {
+	byFingerprint := make(map[string][]Album)
+	var fingerprints []string
+	for _, album := range albums {
+		if _, ok := byFingerprint[album.Fingerprint]; !ok {
+			fingerprints = append(fingerprints, album.Fingerprint)
+		}
+		byFingerprint[album.Fingerprint] = append(byFingerprint[album.Fingerprint], album)
+	}
+
+	var result [][]Album
+	for _, fingerprint := range fingerprints {
+		group := byFingerprint[fingerprint]
+		if len(group) > 1 && !sameFolder(group) {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+func sameFolder(albums []Album) bool {
+	for _, album := range albums[1:] {
+		if album.Folder != albums[0].Folder {
+			return false
+		}
+	}
+	return true
+}
+
+// Overlap is a pair of albums with some, but not all, tracks in common.
+type Overlap struct {
+	A, B   Album
+	Shared int
+}
+
+// Overlaps finds albums in different folders sharing at least minFraction of the smaller one's tracks.
+func Overlaps(albums []Album, minFraction float64) []Overlap {
+	byHash := make(map[string][]int)
+	for i, album := range albums {
+		for hash := range trackHashes(album.Tracks) {
+			byHash[hash] = append(byHash[hash], i)
+		}
+	}
+
+	type pair struct{ a, b int }
+	shared := make(map[pair]int)
+	for _, indexes := range byHash {
+		for x := 0; x < len(indexes); x++ {
+			for y := x + 1; y < len(indexes); y++ {
+				shared[pair{indexes[x], indexes[y]}]++
+			}
+		}
+	}
+
+	var result []Overlap
+	for p, count := range shared {
+		a, b := albums[p.a], albums[p.b]
+		if a.Folder == b.Folder || a.Fingerprint == b.Fingerprint {
+			continue
+		}
+		smaller := len(trackHashes(a.Tracks))
+		if n := len(trackHashes(b.Tracks)); n < smaller {
+			smaller = n
+		}
+		if float64(count) >= minFraction*float64(smaller) {
+			result = append(result, Overlap{a, b, count})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].A.Folder != result[j].A.Folder {
+			return result[i].A.Folder < result[j].A.Folder
+		}
+		return result[i].B.Folder < result[j].B.Folder
+	})
+	return result
+}
+
+// Completion is an incoming album with tracks missing from an album that's only partly owned.
+type Completion struct {
+	Owned, Incoming Album
+	// Adds are the incoming tracks whose audio the owned album doesn't have.
+	Adds []mp3util.Song
+	// StillMissing is how many tracks the owned album would still lack, or -1 if its tags don't say
+	StillMissing int
+}
+
+// Completions matches incoming albums to owned ones by name or shared tracks, keeping those that would add tracks
+// to an owned album that's incomplete or of unknown size.
+func Completions(owned []Album, incoming []Album) []Completion {
+	var result []Completion
+	for _, in := range incoming {
+		inHashes := trackHashes(in.Tracks)
+		for _, own := range owned {
+			ownHashes := trackHashes(own.Tracks)
+			if !sameName(own, in) && !sharesAny(ownHashes, inHashes) {
+				continue
+			}
+			total := own.TotalTracks()
+			if total > 0 && len(ownHashes) >= total {
+				continue
+			}
+
+			var adds []mp3util.Song
+			added := make(map[string]bool)
+			for _, track := range in.Tracks {
+				if !ownHashes[track.Hash] && !added[track.Hash] {
+					added[track.Hash] = true
+					adds = append(adds, track)
+				}
+			}
+			if len(adds) == 0 {
+				continue
+			}
+
+			stillMissing := -1
+			if total > 0 {
+				stillMissing = total - len(ownHashes) - len(adds)
+				if stillMissing < 0 {
+					stillMissing = 0
+				}
+			}
+			result = append(result, Completion{own, in, adds, stillMissing})
+		}
+	}
+	return result
+}
+
+func sameName(a, b Album) bool {
+	return strings.EqualFold(strings.TrimSpace(a.Album), strings.TrimSpace(b.Album)) &&
+		strings.EqualFold(strings.TrimSpace(a.AlbumArtist), strings.TrimSpace(b.AlbumArtist))
+}
+
+func sharesAny(a, b map[string]bool) bool {
+	for hash := range a {
+		if b[hash] {
+			return true
+		}
+	}
+	return false
+}
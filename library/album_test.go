@@ -0,0 +1,172 @@
+package library
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func track(folder, album, artist string, number, total int, hash string) mp3util.Song {
+	return mp3util.Song{
+		Path:        filepath.Join(folder, hash+".mp3"),
+		Album:       album,
+		Artist:      artist,
+		TrackNumber: number,
+		TotalTracks: total,
+		Hash:        hash,
+	}
+}
+
+func TestGroupAlbums(t *testing.T) {
+	songs := []mp3util.Song{
+		track("b", "Comp", "One", 2, 2, "h2"),
+		track("b", "Comp", "Two", 1, 2, "h1"),
+		track("a", "Solo", "One", 1, 1, "h3"),
+		track("a", "", "One", 1, 1, "h4"),
+	}
+	albums := GroupAlbums(songs)
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums, got %+v", albums)
+	}
+	if albums[0].Album != "Solo" || albums[0].AlbumArtist != "One" {
+		t.Errorf("Unexpected first album:  %+v", albums[0])
+	}
+	comp := albums[1]
+	if comp.AlbumArtist != VariousArtists || comp.Tracks[0].Hash != "h1" || comp.DiscCount != 1 {
+		t.Errorf("Unexpected compilation:  %+v", comp)
+	}
+}
+
+func TestFingerprintIgnoresOrderAndTags(t *testing.T) {
+	a := []mp3util.Song{{Hash: "x", Title: "One"}, {Hash: "y"}}
+	b := []mp3util.Song{{Hash: "y"}, {Hash: "x", Title: "Uno"}}
+	if Fingerprint(a) != Fingerprint(b) {
+		t.Error("Fingerprints differed for the same set of tracks")
+	}
+	if Fingerprint(a) == Fingerprint(a[:1]) {
+		t.Error("Fingerprints matched for different sets of tracks")
+	}
+}
+
+func TestDuplicatesAndOverlaps(t *testing.T) {
+	var songs []mp3util.Song
+	for _, hash := range []string{"1", "2", "3", "4"} {
+		songs = append(songs, track("orig", "Album", "Artist", 0, 0, hash))
+		songs = append(songs, track("copy", "Album (Remaster)", "Artist", 0, 0, hash))
+	}
+	for _, hash := range []string{"1", "2", "3", "9"} {
+		songs = append(songs, track("partial", "Album", "Artist", 0, 0, hash))
+	}
+	albums := GroupAlbums(songs)
+
+	dupes := DuplicateAlbums(albums)
+	if len(dupes) != 1 || len(dupes[0]) != 2 {
+		t.Fatalf("Expected one pair of duplicate albums, got %+v", dupes)
+	}
+
+	overlaps := Overlaps(albums, 0.75)
+	if len(overlaps) != 2 {
+		t.Fatalf("Expected two overlaps, got %+v", overlaps)
+	}
+	for _, o := range overlaps {
+		if o.Shared != 3 || o.B.Folder != "partial" {
+			t.Errorf("Unexpected overlap:  %s/%s shared %d", o.A.Folder, o.B.Folder, o.Shared)
+		}
+	}
+	if len(Overlaps(albums, 0.8)) != 0 {
+		t.Error("Expected no overlaps of 80% or more")
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	owned := GroupAlbums([]mp3util.Song{
+		track("owned", "Album", "Artist", 1, 3, "1"),
+		track("full", "Other", "Artist", 1, 1, "5"),
+	})
+	incoming := GroupAlbums([]mp3util.Song{
+		track("in", "album", "artist", 2, 3, "2"),
+		track("in", "album", "artist", 3, 3, "3"),
+		track("in2", "Other", "Artist", 1, 1, "6"),
+	})
+
+	completions := Completions(owned, incoming)
+	if len(completions) != 1 {
+		t.Fatalf("Expected one completion, got %+v", completions)
+	}
+	c := completions[0]
+	if c.Owned.Folder != "owned" || len(c.Adds) != 2 || c.StillMissing != 0 {
+		t.Errorf("Unexpected completion:  %+v", c)
+	}
+}
+
+func TestCheckCompleteness(t *testing.T) {
+	songs := []mp3util.Song{
+		track("a", "Album", "Artist", 1, 5, "1"),
+		track("a", "Album", "Artist", 2, 5, "2"),
+		track("a", "Album", "Artist", 2, 5, "2b"),
+		track("a", "Album", "Artist", 4, 5, "4"),
+	}
+	for i := range songs {
+		songs[i].TotalDiscs = 2
+	}
+	album := GroupAlbums(songs)[0]
+
+	report, incomplete := CheckCompleteness(album)
+	if !incomplete {
+		t.Fatal("Expected the album to be incomplete")
+	}
+	if !reflect.DeepEqual(report.MissingDiscs, []int{2}) {
+		t.Errorf("Expected disc 2 to be missing, got %v", report.MissingDiscs)
+	}
+	expected := []DiscReport{{Disc: 1, Files: 4, TotalTracks: 5, MissingTracks: []int{3, 5}, DuplicateTracks: []int{2},
+		Short: 1}}
+	if !reflect.DeepEqual(expected, report.Discs) {
+		t.Errorf("Values differed.  \r\nExpected:  %+v  \r\nActual:  %+v", expected, report.Discs)
+	}
+
+	mistagged := GroupAlbums([]mp3util.Song{
+		track("c", "Album", "Artist", 1, 2, "1"),
+		track("c", "Album", "Artist", 102, 2, "2"),
+	})[0]
+	report, _ = CheckCompleteness(mistagged)
+	expected = []DiscReport{{Disc: 1, Files: 2, TotalTracks: 2, MissingTracks: []int{2}, SuspectTracks: []int{102}}}
+	if !reflect.DeepEqual(expected, report.Discs) {
+		t.Errorf("Values differed.  \r\nExpected:  %+v  \r\nActual:  %+v", expected, report.Discs)
+	}
+
+	complete := GroupAlbums([]mp3util.Song{track("b", "Single", "Artist", 1, 1, "9")})[0]
+	if _, incomplete = CheckCompleteness(complete); incomplete {
+		t.Error("Expected a complete album to pass")
+	}
+}
+
+func TestMergeDiscFolders(t *testing.T) {
+	var songs []mp3util.Song
+	for disc, folder := range []string{"Album/CD1", "Album/CD2"} {
+		for n := 1; n <= 2; n++ {
+			song := track(folder, "Album", "Artist", n, 2, strconv.Itoa(disc*10+n))
+			song.TotalDiscs = 2
+			if disc == 0 {
+				song.DiscNumber = 1
+			}
+			songs = append(songs, song)
+		}
+	}
+	songs = append(songs, track("Other/CD1", "Other", "Artist", 1, 2, "other"))
+
+	albums := MergeDiscFolders(GroupAlbums(songs))
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums, got %d", len(albums))
+	}
+	if albums[0].Folder != "Album" || len(albums[0].Tracks) != 4 || albums[0].DiscCount != 2 {
+		t.Errorf("Expected the discs of Album to be merged, got %+v", albums[0])
+	}
+	if _, incomplete := CheckCompleteness(albums[0]); incomplete {
+		t.Error("Expected a complete album split across disc folders to pass")
+	}
+	if report, _ := CheckCompleteness(albums[1]); !reflect.DeepEqual([]int{2}, report.Discs[0].MissingTracks) {
+		t.Errorf("Expected track 2 of Other to be missing, got %+v", report.Discs)
+	}
+}
@@ -0,0 +1,169 @@
+package library
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// DiscReport describes what's wrong with one disc of an album.
+type DiscReport struct {
+	Disc            int   `json:"disc"`
+	Files           int   `json:"files"`
+	TotalTracks     int   `json:"totalTracks"`
+	MissingTracks   []int `json:"missingTracks,omitempty"`
+	DuplicateTracks []int `json:"duplicateTracks,omitempty"`
+	// SuspectTracks are numbers too far past the total to believe, like 101 for disc 1 track 1
+	SuspectTracks []int `json:"suspectTracks,omitempty"`
+	// Short is how many files short of TotalTracks the disc is; it overlaps MissingTracks, so don't add them
+	Short int `json:"short,omitempty"`
+}
+
+// suspectMargin is how far past both the total and the file count a track number is still believed.
+const suspectMargin = 10
+
+func (d DiscReport) ok() bool {
+	return len(d.MissingTracks) == 0 && len(d.DuplicateTracks) == 0 && len(d.SuspectTracks) == 0 && d.Short == 0
+}
+
+// CompletenessReport lists the problems with an album; only discs with problems are included.
+type CompletenessReport struct {
+	AlbumArtist  string       `json:"albumArtist"`
+	Album        string       `json:"album"`
+	Folder       string       `json:"folder"`
+	DiscCount    int          `json:"discCount"`
+	MissingDiscs []int        `json:"missingDiscs,omitempty"`
+	Discs        []DiscReport `json:"discs,omitempty"`
+}
+
+// CheckCompleteness reports an album's missing discs and tracks and doubled track numbers, or false if it's fine.
+func CheckCompleteness(album Album) (CompletenessReport, bool) {
+	report := CompletenessReport{
+		AlbumArtist: album.AlbumArtist,
+		Album:       album.Album,
+		Folder:      album.Folder,
+		DiscCount:   album.DiscCount,
+	}
+
+	byDisc := make(map[int][]int)
+	files := make(map[int]int)
+	totals := make(map[int]int)
+	for _, track := range album.Tracks {
+		disc := track.DiscNumber
+		if disc == 0 {
+			disc = 1
+		}
+		files[disc]++
+		if track.TrackNumber > 0 {
+			byDisc[disc] = append(byDisc[disc], track.TrackNumber)
+		}
+		if track.TotalTracks > totals[disc] {
+			totals[disc] = track.TotalTracks
+		}
+	}
+
+	for disc := 1; disc <= album.DiscCount; disc++ {
+		if files[disc] == 0 {
+			report.MissingDiscs = append(report.MissingDiscs, disc)
+		}
+	}
+
+	var discs []int
+	for disc := range files {
+		discs = append(discs, disc)
+	}
+	sort.Ints(discs)
+	for _, disc := range discs {
+		d := checkDisc(disc, files[disc], totals[disc], byDisc[disc])
+		if !d.ok() {
+			report.Discs = append(report.Discs, d)
+		}
+	}
+
+	return report, len(report.MissingDiscs) > 0 || len(report.Discs) > 0
+}
+
+var discFolder = regexp.MustCompile(`(?i)^(?:cd|disc|disk)[\s_-]*(\d+)$`)
+
+// MergeDiscFolders merges albums in sibling disc folders like Album/CD1 and Album/CD2 into one in the parent.
+func MergeDiscFolders(albums []Album) []Album {
+	type key struct{ parent, album, albumArtist string }
+	merged := make(map[key]int)
+	var result []Album
+	for _, album := range albums {
+		match := discFolder.FindStringSubmatch(filepath.Base(album.Folder))
+		if match == nil {
+			result = append(result, album)
+			continue
+		}
+		disc, _ := strconv.Atoi(match[1])
+		tracks := make([]mp3util.Song, len(album.Tracks))
+		for i, track := range album.Tracks {
+			if track.DiscNumber == 0 {
+				track.DiscNumber = disc
+			}
+			tracks[i] = track
+		}
+
+		k := key{filepath.Dir(album.Folder), album.Album, album.AlbumArtist}
+		i, ok := merged[k]
+		if !ok {
+			merged[k] = len(result)
+			album.Folder = k.parent
+			album.Tracks = tracks
+			result = append(result, album)
+			continue
+		}
+		result[i].Tracks = append(result[i].Tracks, tracks...)
+	}
+
+	for i := range result {
+		if _, ok := merged[key{result[i].Folder, result[i].Album, result[i].AlbumArtist}]; ok {
+			sortTracks(result[i].Tracks)
+			result[i].DiscCount = discCount(result[i].Tracks)
+			result[i].Fingerprint = Fingerprint(result[i].Tracks)
+		}
+	}
+	return result
+}
+
+func checkDisc(disc int, files int, total int, numbers []int) DiscReport {
+	d := DiscReport{Disc: disc, Files: files}
+	limit := total
+	if files > limit {
+		limit = files
+	}
+	limit += suspectMargin
+
+	seen := make(map[int]int)
+	for _, n := range numbers {
+		if n > limit {
+			if seen[n] == 0 {
+				d.SuspectTracks = append(d.SuspectTracks, n)
+			}
+			seen[n]++
+			continue
+		}
+		seen[n]++
+		if n > total {
+			total = n
+		}
+	}
+	sort.Ints(d.SuspectTracks)
+	d.TotalTracks = total
+
+	for n := 1; n <= total; n++ {
+		switch {
+		case seen[n] == 0:
+			d.MissingTracks = append(d.MissingTracks, n)
+		case seen[n] > 1:
+			d.DuplicateTracks = append(d.DuplicateTracks, n)
+		}
+	}
+	if files < total {
+		d.Short = total - files
+	}
+	return d
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/plan"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// linkDupes replaces byte-for-byte identical copies under the given directories with hard links (or reflinks) to a
+// single copy, then re-hashes every replaced path to make sure it still has the same contents.
+func linkDupes(stdout io.Writer, stderr io.Writer, args linkDupesArgs) {
+	var mp3Files []string
+	for _, directory := range args.directories {
+		dieUnlessDirectoryExists(stderr, directory)
+		files, err := mp3fileutil.FindMP3Files(directory)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		mp3Files = append(mp3Files, files...)
+	}
+	mp3Files = uniqueStrings(mp3Files)
+
+	_, _ = fmt.Fprintf(stdout, "Comparing %d files\n", len(mp3Files))
+	groups := mp3fileutil.FindIdenticalFiles(mp3Files)
+
+	kind := plan.Link
+	if args.mode == "reflink" {
+		kind = plan.Reflink
+	}
+
+	var hashes []string
+	for hash := range groups {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	p := plan.Plan{Description: "link identical files"}
+	expected := make(map[string]string)
+	var saved int64
+	for _, hash := range hashes {
+		ops, size := linkOperations(kind, groups[hash])
+		for _, op := range ops {
+			p.Add(op)
+			expected[op.Destination] = hash
+		}
+		saved += size
+	}
+
+	if args.planPath != "" {
+		err := p.Save(args.planPath)
+		if err != nil {
+			diePrintf(stderr, "failed to save plan:  %s\n", err)
+		}
+		_, _ = fmt.Fprintf(stdout, "Saved %d links to %q; applying them would save %s\n", len(p.Operations),
+			args.planPath, formatBytes(saved))
+		return
+	}
+
+	if len(p.Operations) == 0 {
+		_, _ = fmt.Fprintln(stdout, "(no identical copies to link)")
+		return
+	}
+
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	runID, err := plan.Apply(stdout, db, p, defaultTrash)
+	if err != nil {
+		if runID != "" {
+			_, _ = fmt.Fprintf(stderr, "linking stopped part of the way through; undo it with \"smartmp3mgr undo %s\"\n",
+				runID)
+		}
+		diePrintln(stderr, err)
+	}
+
+	failed := 0
+	for _, op := range p.Operations {
+		b, err := ioutil.ReadFile(op.Destination)
+		hash := mp3util.FileHash(b)
+		if err != nil || hex.EncodeToString(hash[:]) != expected[op.Destination] {
+			failed++
+			_, _ = fmt.Fprintf(stderr, "verification failed for %q; undo with \"smartmp3mgr undo %s\"\n",
+				op.Destination, runID)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Linked %d files in %d groups (run %s), saving %s\n", len(p.Operations), len(hashes),
+		runID, formatBytes(saved))
+}
+
+// linkOperations links every file in a group of identical files to the first one on the same filesystem, skipping
+// files that are already links to it, and returns how many bytes that saves, counting files linked to each other once.
+func linkOperations(kind plan.Kind, group []string) ([]plan.Operation, int64) {
+	var result []plan.Operation
+	var saved int64
+	keepers := make(map[uint64]os.FileInfo)
+	keeperPaths := make(map[uint64]string)
+	var replaced []os.FileInfo
+
+	for _, path := range group {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		device, _ := mp3fileutil.DeviceID(info)
+		keeper, ok := keepers[device]
+		if !ok {
+			keepers[device] = info
+			keeperPaths[device] = path
+			continue
+		}
+		if os.SameFile(keeper, info) {
+			continue
+		}
+		result = append(result, plan.Operation{Kind: kind, Source: keeperPaths[device], Destination: path})
+		if !containsFile(replaced, info) {
+			replaced = append(replaced, info)
+			saved += info.Size()
+		}
+	}
+
+	return result, saved
+}
+
+func containsFile(infos []os.FileInfo, info os.FileInfo) bool {
+	for _, other := range infos {
+		if os.SameFile(other, info) {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(s []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, el := range s {
+		if !seen[el] {
+			seen[el] = true
+			result = append(result, el)
+		}
+	}
+	return result
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/lint"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+// lintLibrary checks every recorded song against the lint rules, returning how many violations are errors so the
+// caller can exit non-zero.
+func lintLibrary(stdout io.Writer, stderr io.Writer, args lintArgs) int {
+	if args.list {
+		disabled := make(map[string]bool)
+		for _, name := range args.config.Disabled {
+			disabled[name] = true
+		}
+		for _, rule := range lint.Rules {
+			severity := rule.Severity
+			if s, ok := args.config.Severities[rule.Name]; ok {
+				severity = s
+			}
+			state := ""
+			if disabled[rule.Name] {
+				state = " (disabled)"
+			}
+			_, _ = fmt.Fprintf(stdout, "%-28s %-8s %s%s\n", rule.Name, severity, rule.Description, state)
+		}
+		return 0
+	}
+
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database:  %s\n", err)
+	}
+
+	violations := lint.Run(songs, args.config)
+	counts := make(map[lint.Severity]int)
+	for _, v := range violations {
+		counts[v.Severity]++
+	}
+
+	if args.format == "json" {
+		if violations == nil {
+			violations = []lint.Violation{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(violations)
+		if err != nil {
+			diePrintf(stderr, "failed to write results:  %s\n", err)
+		}
+	} else {
+		for _, v := range violations {
+			_, _ = fmt.Fprintf(stdout, "%s:  %s:  %s [%s]\n", v.Path, v.Severity, v.Message, v.Rule)
+		}
+	}
+
+	_, _ = fmt.Fprintf(stderr, "(%d errors, %d warnings, %d info in %d songs)\n", counts[lint.Error],
+		counts[lint.Warning], counts[lint.Info], len(songs))
+	return counts[lint.Error]
+}
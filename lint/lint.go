@@ -0,0 +1,198 @@
+package lint
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+	Info    Severity = "info"
+)
+
+// Violation is one problem found by a rule.  Path is the file, or for album-level rules, the album's folder.
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`
+	Message  string   `json:"message"`
+}
+
+// Rule checks the whole library at once, so that album-level rules can see every track.  Severity is the default,
+// which Config can override.
+type Rule struct {
+	Name        string
+	Severity    Severity
+	Description string
+	check       func(songs []mp3util.Song, albums []library.Album) []Violation
+}
+
+var numericGenre = regexp.MustCompile(`^\(?\d+\)?$`)
+
+// Rules lists every rule, in the order they're run.
+var Rules = []Rule{
+	songRule("empty-title", Error, "Title is empty", func(s mp3util.Song) string {
+		return emptyIf(s.Title, "no title")
+	}),
+	songRule("empty-artist", Error, "Artist is empty", func(s mp3util.Song) string {
+		return emptyIf(s.Artist, "no artist")
+	}),
+	songRule("empty-album", Warning, "Album is empty", func(s mp3util.Song) string {
+		return emptyIf(s.Album, "no album")
+	}),
+	albumRule("album-artist-mismatch", Warning, "tracks of an album disagree on AlbumArtist",
+		"AlbumArtist", func(s mp3util.Song) string { return s.AlbumArtist }),
+	albumRule("album-genre-mismatch", Info, "tracks of an album disagree on Genre",
+		"Genre", func(s mp3util.Song) string { return s.Genre }),
+	albumRule("album-total-tracks-mismatch", Warning, "tracks of an album disagree on TotalTracks",
+		"TotalTracks", func(s mp3util.Song) string { return fmt.Sprint(s.TotalTracks) }),
+	songRule("track-exceeds-total", Error, "TrackNumber is greater than TotalTracks", func(s mp3util.Song) string {
+		if s.TotalTracks > 0 && s.TrackNumber > s.TotalTracks {
+			return fmt.Sprintf("track %d of %d", s.TrackNumber, s.TotalTracks)
+		}
+		return ""
+	}),
+	songRule("id3v1-only", Warning, "the only tag is ID3v1 (needs record -reparse for older records)",
+		func(s mp3util.Song) string {
+			if s.TagFormat == "ID3v1" {
+				return "ID3v1 tag only"
+			}
+			return ""
+		}),
+	songRule("numeric-genre", Warning, "Genre is an ID3v1 genre number like (17) (needs record -reparse for older "+
+		"records)", func(s mp3util.Song) string {
+		if numericGenre.MatchString(s.Genre) {
+			return fmt.Sprintf("numeric genre %q", s.Genre)
+		}
+		if numericGenre.MatchString(s.RawGenre) {
+			return fmt.Sprintf("numeric genre %q (recorded as %q)", s.RawGenre, s.Genre)
+		}
+		return ""
+	}),
+	songRule("whitespace", Info, "a tag has leading or trailing whitespace", func(s mp3util.Song) string {
+		var fields []string
+		for _, f := range []struct{ name, value string }{{"Title", s.Title}, {"Artist", s.Artist},
+			{"Album", s.Album}, {"AlbumArtist", s.AlbumArtist}, {"Genre", s.Genre}} {
+			if f.value != strings.TrimSpace(f.value) {
+				fields = append(fields, f.name)
+			}
+		}
+		if len(fields) == 0 {
+			return ""
+		}
+		return "whitespace around " + strings.Join(fields, ", ")
+	}),
+}
+
+func emptyIf(value string, message string) string {
+	if strings.TrimSpace(value) == "" {
+		return message
+	}
+	return ""
+}
+
+// songRule makes a rule from a per-song check that returns a message, or "" if the song is fine.
+func songRule(name string, severity Severity, description string, check func(mp3util.Song) string) Rule {
+	return Rule{name, severity, description, func(songs []mp3util.Song, _ []library.Album) []Violation {
+		var result []Violation
+		for _, song := range songs {
+			if message := check(song); message != "" {
+				result = append(result, Violation{name, severity, song.Path, message})
+			}
+		}
+		return result
+	}}
+}
+
+// albumRule makes a rule that flags albums whose tracks don't all have the same value for a field.
+func albumRule(name string, severity Severity, description string, field string,
+	value func(mp3util.Song) string) Rule {
+	return Rule{name, severity, description, func(_ []mp3util.Song, albums []library.Album) []Violation {
+		var result []Violation
+		for _, album := range albums {
+			seen := make(map[string]bool)
+			var values []string
+			for _, track := range album.Tracks {
+				v := value(track)
+				if !seen[v] {
+					seen[v] = true
+					values = append(values, fmt.Sprintf("%q", v))
+				}
+			}
+			if len(values) > 1 {
+				sort.Strings(values)
+				result = append(result, Violation{name, severity, album.Folder,
+					fmt.Sprintf("%q has %d different %s values:  %s", album.Album, len(values), field,
+						strings.Join(values, ", "))})
+			}
+		}
+		return result
+	}}
+}
+
+// Config turns rules off and changes their severities.  The zero value runs every rule at its default severity.
+type Config struct {
+	Disabled   []string            `json:"disabled,omitempty"`
+	Severities map[string]Severity `json:"severities,omitempty"`
+}
+
+// Validate makes sure every rule and severity named in the config exists, so that typos don't go unnoticed.
+func (c Config) Validate() error {
+	for _, name := range c.Disabled {
+		if _, ok := findRule(name); !ok {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+	}
+	for name, severity := range c.Severities {
+		if _, ok := findRule(name); !ok {
+			return fmt.Errorf("unknown rule %q", name)
+		}
+		if severity != Error && severity != Warning && severity != Info {
+			return fmt.Errorf("unknown severity %q for rule %q", severity, name)
+		}
+	}
+	return nil
+}
+
+func findRule(name string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Run checks songs against every enabled rule, returning violations ordered by path and then rule.
+func Run(songs []mp3util.Song, config Config) []Violation {
+	disabled := make(map[string]bool)
+	for _, name := range config.Disabled {
+		disabled[name] = true
+	}
+	albums := library.GroupAlbums(songs)
+
+	var result []Violation
+	for _, rule := range Rules {
+		if disabled[rule.Name] {
+			continue
+		}
+		for _, v := range rule.check(songs, albums) {
+			if severity, ok := config.Severities[rule.Name]; ok {
+				v.Severity = severity
+			}
+			result = append(result, v)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
@@ -0,0 +1,101 @@
+package lint
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func rulesHit(violations []Violation) map[string]Severity {
+	result := make(map[string]Severity)
+	for _, v := range violations {
+		result[v.Rule] = v.Severity
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	songs := []mp3util.Song{
+		{Path: "a/1.mp3", Title: "One ", Artist: "Artist", Album: "Album", AlbumArtist: "Artist", Genre: "(17)",
+			TrackNumber: 3, TotalTracks: 2, TagFormat: "ID3v1"},
+		{Path: "a/2.mp3", Title: "Two", Artist: "Artist", Album: "Album", AlbumArtist: "Someone", Genre: "Rock",
+			TrackNumber: 2, TotalTracks: 2},
+		{Path: "b/1.mp3"},
+	}
+
+	result := rulesHit(Run(songs, Config{}))
+	expected := map[string]Severity{
+		"empty-title":           Error,
+		"empty-artist":          Error,
+		"empty-album":           Warning,
+		"album-artist-mismatch": Warning,
+		"album-genre-mismatch":  Info,
+		"track-exceeds-total":   Error,
+		"id3v1-only":            Warning,
+		"numeric-genre":         Warning,
+		"whitespace":            Info,
+	}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Rules hit differed.  \r\nExpected:  %v  \r\nActual:  %v", expected, result)
+	}
+
+	config := Config{Disabled: []string{"empty-title", "whitespace"},
+		Severities: map[string]Severity{"id3v1-only": Error}}
+	result = rulesHit(Run(songs, config))
+	if _, ok := result["empty-title"]; ok {
+		t.Error("Disabled rule still ran")
+	}
+	if result["id3v1-only"] != Error {
+		t.Errorf("Severity wasn't overridden:  %v", result["id3v1-only"])
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := (Config{Disabled: []string{"no-such-rule"}}).Validate(); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+	if err := (Config{Severities: map[string]Severity{"whitespace": "fatal"}}).Validate(); err == nil {
+		t.Error("Expected an error for an unknown severity")
+	}
+	if err := (Config{Disabled: []string{"whitespace"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNumericGenreInFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "1.mp3")
+	err = mp3fileutil.CopyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mp3util.RetagMP3(path, map[string]string{"Genre": "(17)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	song, err := mp3util.ReadTags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Genre == "(17)" || song.RawGenre != "(17)" {
+		t.Fatalf("Expected the genre number to be read as a name and kept as written, got %+v", song)
+	}
+	// the rule goes by what was recorded, without reading the file again
+	_ = os.Remove(path)
+
+	config := Config{Disabled: []string{"empty-title", "empty-artist", "empty-album", "whitespace", "id3v1-only"}}
+	result := rulesHit(Run([]mp3util.Song{song}, config))
+	if _, ok := result["numeric-genre"]; !ok {
+		t.Errorf("Expected numeric-genre for a file tagged (17) and recorded as %q, got %v", song.Genre, result)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
 	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
 	"github.com/caseyjmorris/smartmp3mgr/mp3util"
 	"github.com/caseyjmorris/smartmp3mgr/records"
@@ -11,15 +12,19 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
 )
 
+const usage = "Usage:  smartmp3mgr (record|find-new|dupes|album-dupes|album-completes|missing-tracks|lint|query|search|stats|report|watch|serve|export|import|export-manifest|compare|merge|roots|add-root|relocate-root|collections|rename-collection|drop-collection|apply|undo|link-dupes) (args)"
+
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage:  smartmp3mgr (sum|record|find-new) (args)")
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 
@@ -40,8 +45,164 @@ func main() {
 			diePrintf(os.Stderr, "%s", err)
 		}
 		findNew(os.Stdout, os.Stderr, prf, args, nil)
+	case "apply":
+		args, err := parseApplyArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		apply(os.Stdout, os.Stderr, args)
+	case "undo":
+		args, err := parseUndoArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		undo(os.Stdout, os.Stderr, args)
+	case "dupes":
+		args, err := parseDupesArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		dupes(os.Stdout, os.Stderr, args)
+	case "album-dupes":
+		args, err := parseAlbumDupesArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		albumDupes(os.Stdout, os.Stderr, args)
+	case "album-completes":
+		args, err := parseAlbumCompletesArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		albumCompletes(os.Stdout, os.Stderr, args)
+	case "missing-tracks":
+		args, err := parseMissingTracksArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		missingTracks(os.Stdout, os.Stderr, args)
+	case "lint":
+		args, err := parseLintArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		if lintLibrary(os.Stdout, os.Stderr, args) > 0 {
+			os.Exit(1)
+		}
+	case "query":
+		args, err := parseQueryArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		runQuery(os.Stdout, os.Stderr, args)
+	case "search":
+		args, err := parseSearchArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		search(os.Stdout, os.Stderr, args)
+	case "stats":
+		args, err := parseStatsArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		stats(os.Stdout, os.Stderr, args)
+	case "report":
+		args, err := parseReportArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		report(os.Stdout, os.Stderr, args)
+	case "watch":
+		args, err := parseWatchArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		watchLibrary(os.Stdout, os.Stderr, args, stop)
+	case "serve":
+		args, err := parseServeArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+		serve(os.Stdout, os.Stderr, args, stop)
+	case "export":
+		args, err := parseExportArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		exportLibrary(os.Stdout, os.Stderr, args)
+	case "import":
+		args, err := parseImportArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		importLibrary(os.Stdout, os.Stderr, args)
+	case "export-manifest":
+		args, err := parseExportManifestArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		exportManifest(os.Stdout, os.Stderr, args)
+	case "compare":
+		args, err := parseCompareArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		compare(os.Stdout, os.Stderr, args)
+	case "merge":
+		args, err := parseMergeArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		mergeLibraries(os.Stdout, os.Stderr, args)
+	case "roots":
+		args, err := parseRootsArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		listRoots(os.Stdout, os.Stderr, args)
+	case "add-root":
+		args, err := parseRootArgs(addRootCmd)
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		addRoot(os.Stdout, os.Stderr, args)
+	case "relocate-root":
+		args, err := parseRootArgs(relocateRootCmd)
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		relocateRoot(os.Stdout, os.Stderr, args)
+	case "collections":
+		args, err := parseCollectionArgs(collectionsCmd, "")
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		listCollections(os.Stdout, os.Stderr, args)
+	case "rename-collection":
+		args, err := parseCollectionArgs(renameCollectionCmd, "<old> <new>")
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		renameCollection(os.Stdout, os.Stderr, args)
+	case "drop-collection":
+		args, err := parseCollectionArgs(dropCollectionCmd, "<name>")
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		dropCollection(os.Stdout, os.Stderr, args)
+	case "link-dupes":
+		args, err := parseLinkDupesArgs()
+		if err != nil {
+			diePrintf(os.Stderr, "%s\n", err)
+		}
+		linkDupes(os.Stdout, os.Stderr, args)
 	default:
-		diePrintln(os.Stderr, "Usage:  smartmp3mgr (sum|record|find-new) (args)")
+		diePrintln(os.Stderr, usage)
 	}
 
 	os.Exit(0)
@@ -49,7 +210,8 @@ func main() {
 
 func record(stdout io.Writer, stderr io.Writer, pb progressReporterFactory, args recordArgs) {
 	dieUnlessDirectoryExists(stderr, args.directory)
-	db, existingMap := fetchSongsOrDie(stderr, args.dbPath, args.reparse)
+	db, existingMap, collections := fetchSongsOrDie(stderr, args.dbPath, args.reparse)
+	recordRoot(stdout, stderr, db, args)
 
 	_, _ = fmt.Fprintf(stdout, "Scanning %q for MP3s\n", args.directory)
 	mp3Files, err := mp3fileutil.FindMP3Files(args.directory)
@@ -65,12 +227,6 @@ func record(stdout io.Writer, stderr io.Writer, pb progressReporterFactory, args
 
 	bar := pb(int64(len(mp3Files)))
 
-	tx, err := db.Begin()
-
-	if err != nil {
-		diePrintf(stderr, "error opening transaction:  %s\n", err)
-	}
-
 	songQ := make(chan mp3util.Song, len(mp3Files))
 	doneQ := make(chan int, len(mp3Files))
 
@@ -88,6 +244,10 @@ func record(stdout io.Writer, stderr io.Writer, pb progressReporterFactory, args
 					if err != nil {
 						continue
 					}
+					record.Collection = collections[file]
+				}
+				if args.collection != "" {
+					record.Collection = args.collection
 				}
 				wg.Add(1)
 				songQ <- record
@@ -108,7 +268,7 @@ func record(stdout io.Writer, stderr io.Writer, pb progressReporterFactory, args
 
 	go func(songQ <-chan mp3util.Song) {
 		for s := range songQ {
-			if _, ok := existingMap[s.Path]; ok {
+			if existing, ok := existingMap[s.Path]; ok && existing.Collection == s.Collection {
 				wg.Done()
 				continue
 			}
@@ -122,10 +282,6 @@ func record(stdout io.Writer, stderr io.Writer, pb progressReporterFactory, args
 
 	wg.Wait()
 
-	err = tx.Commit()
-	if err != nil {
-		diePrintf(stderr, "error committing transaction:  %s\n", err)
-	}
 	err = db.Close()
 	if err != nil {
 		diePrintf(stderr, "error closing db:  %s\n", err)
@@ -138,25 +294,43 @@ func findNew(stdout io.Writer, stderr io.Writer, prf progressReporterFactory, ar
 		diePrintln(stderr, "degree of parallelism must be greater than 0")
 	}
 
-	db, err := records.Open(args.dbPath)
-	if err != nil {
-		_, _ = fmt.Fprintf(stderr, "%s", err)
-		os.Exit(1)
-	}
-	defer db.Close()
+	// without a database, files are only checked against the manifests, and their hashes aren't cached
+	var db records.Store
+	knownHashes := make(map[string]records.CachedHash)
+	var err error
+	if args.dbPath != "" {
+		db, err = records.OpenStore(args.dbPath)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		if _, ok := db.(*records.RecordKeeper); !ok && args.quarantineDir != "" && args.planPath == "" {
+			diePrintln(stderr,
+				"quarantining needs an SQLite database to journal the moves in; save them with -plan instead")
+		}
 
-	knownHashes, err := db.GetHashes()
+		knownHashes, err = db.GetHashes()
 
-	if err != nil {
-		_, _ = fmt.Fprintf(stderr, "failed to open db %q:  %s", args.dbPath, err)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "failed to open db %q:  %s", args.dbPath, err)
+		}
+	}
+
+	manifests := make(map[string]manifest.Manifest)
+	for _, path := range args.manifests {
+		manifests[path], err = manifest.ReadFile(path)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
 	}
 
-	_, _ = fmt.Fprintf(stdout, "Looking for files in %q\n", args.directory)
+	_, _ = fmt.Fprintf(stderr, "Looking for files in %q\n", args.directory)
 
 	mp3Files, err := mp3fileutil.FindMP3Files(args.directory)
 
 	if err != nil {
-		diePrintln(stdout, err)
+		diePrintln(stderr, err)
 	}
 
 	fileQ := make(chan string, len(mp3Files))
@@ -166,69 +340,81 @@ func findNew(stdout io.Writer, stderr io.Writer, prf progressReporterFactory, ar
 	}
 	close(fileQ)
 
-	existsMap := make(map[string]mp3util.Song)
-	if !args.rehash {
-		_, _ = fmt.Fprintf(stdout, "Checking existing records in DB %q\n", args.dbPath)
+	existsMap := make(map[string][]mp3util.Song)
+	if db != nil && !args.rehash {
+		_, _ = fmt.Fprintf(stderr, "Checking existing records in DB %q\n", args.dbPath)
 		existingFiles, err := db.FetchSongs()
 		if err != nil {
 			diePrintf(stderr, "Error reading database:  %s\n", err)
 		}
+		owned := make(map[string]int)
+		for _, name := range args.against {
+			owned[name] = 0
+		}
 		for _, existingRecord := range existingFiles {
-			existsMap[existingRecord.Hash] = existingRecord
+			if _, ok := owned[existingRecord.Collection]; !ok && len(args.against) > 0 {
+				continue
+			}
+			owned[existingRecord.Collection]++
+			existsMap[existingRecord.Hash] = append(existsMap[existingRecord.Hash], existingRecord)
+		}
+		for _, name := range args.against {
+			if owned[name] == 0 {
+				_, _ = fmt.Fprintf(stderr, "warning:  no songs are recorded in collection %q\n", name)
+			}
 		}
-	}
-	uniq := 0
-
-	tx, err := db.Begin()
-	if err != nil {
-		diePrintf(stderr, "failed to start transaction:  %s\n", err)
 	}
 
-	_, _ = fmt.Fprintf(stdout, "Hashing %d files and comparing against existing records in DB %q\n", len(mp3Files), args.dbPath)
+	_, _ = fmt.Fprintf(stderr, "Hashing %d files and comparing against existing records in DB %q\n", len(mp3Files), args.dbPath)
 
 	bar := prf(int64(len(mp3Files)))
-	var results []string
+	var results []findNewResult
+	var duplicates []hashedFile
+	needTags := formatNeedsTags(args.format) || args.summary == "album"
 
 	doneQ := make(chan int, len(mp3Files))
-	uniqQ := make(chan string)
-	fileHashQ := make(chan [2]string)
+	hashedQ := make(chan hashedFile)
+	fileHashQ := make(chan hashedFile)
 	var wg sync.WaitGroup
 	wg.Add(len(mp3Files))
 
 	for i := 0; i < args.degreeOfParallelism; i++ {
-		go func(doneQ chan<- int, uniqQ chan<- string, fileHashQ chan<- [2]string) {
+		go func(doneQ chan<- int, hashedQ chan<- hashedFile, fileHashQ chan<- hashedFile) {
 			for file := range fileQ {
-				var hashS string
-				if existing, ok := knownHashes[file]; ok {
-					hashS = existing
+				h := hashedFile{path: file}
+				if existing, ok := knownHashes[file]; ok && existing.FileHash != "" {
+					h.hash = existing.Hash
+					h.fileHash = existing.FileHash
 				} else {
 					bytes, err := ioutil.ReadFile(file)
-					if err != nil {
-						doneQ <- 1
-						wg.Done()
-						continue
+					var hash [32]byte
+					if err == nil {
+						hash, err = mp3util.Hash(bytes)
 					}
-					hash, err := mp3util.Hash(bytes)
 					if err != nil {
-						doneQ <- 1
-						wg.Done()
-						continue
+						h.err = err
+					} else {
+						h.hash = hex.EncodeToString(hash[:])
+						fileHash := mp3util.FileHash(bytes)
+						h.fileHash = hex.EncodeToString(fileHash[:])
+						wg.Add(1)
+						fileHashQ <- h
 					}
-					hashS = hex.EncodeToString(hash[:])
-					wg.Add(1)
-					fileHashQ <- [2]string{file, hashS}
-
 				}
 
-				if _, ok := existsMap[hashS]; !ok {
-					wg.Add(1)
-					uniqQ <- file
+				if needTags && h.err == nil {
+					if tags, err := mp3util.ReadTags(file); err == nil {
+						h.tags = &tags
+					}
 				}
 
+				wg.Add(1)
+				hashedQ <- h
+
 				doneQ <- 1
 				wg.Done()
 			}
-		}(doneQ, uniqQ, fileHashQ)
+		}(doneQ, hashedQ, fileHashQ)
 	}
 
 	go func() {
@@ -237,35 +423,47 @@ func findNew(stdout io.Writer, stderr io.Writer, prf progressReporterFactory, ar
 		}
 	}()
 
-	folders := make(map[string]bool)
-
 	go func() {
-		for u := range uniqQ {
-			uniq++
-			if args.foldersOnly {
-				f := filepath.Dir(u)
-				if !folders[f] {
-					folders[f] = true
-					results = append(results, f)
-				}
-			} else {
-				results = append(results, u)
+		for h := range hashedQ {
+			result := findNewResult{Path: h.path, Hash: h.hash, FileHash: h.fileHash, Status: statusNew}
+			if h.tags != nil {
+				result.Tags = newResultTags(*h.tags)
 			}
 
-			if resultCapture != nil {
-				*resultCapture = append(*resultCapture, u)
+			if h.err != nil {
+				result.Status = statusUnreadable
+				result.Error = h.err.Error()
+			} else if _, ok := existsMap[h.hash]; ok {
+				matches := matchesFor(h, existsMap)
+				result.Status = statusDuplicate
+				result.DuplicateOf = matches[0].canonical.Path
+				result.Match = matches[0].kind
+				for _, m := range matches {
+					result.Matches = append(result.Matches, resultMatch{m.canonical.Path, m.kind,
+						newResultTags(m.canonical)})
+				}
+				duplicates = append(duplicates, h)
+			} else if listedIn := manifestsListing(manifests, h.hash); len(listedIn) > 0 {
+				result.Status = statusDuplicate
+				result.DuplicateOf = listedIn[0]
+				result.Match = listedInManifest
+				for _, path := range listedIn {
+					result.Matches = append(result.Matches, resultMatch{Path: path, Match: listedInManifest})
+				}
 			}
+
+			results = append(results, result)
 			wg.Done()
 		}
 	}()
 
 	go func() {
 		for fh := range fileHashQ {
-			file := fh[0]
-			hashS := fh[1]
-			err = db.CacheHash(file, hashS)
-			if err != nil {
-				diePrintf(stderr, "failed to write cached hash:  %s\n", err)
+			if db != nil {
+				err := db.CacheHash(fh.path, fh.hash, fh.fileHash)
+				if err != nil {
+					diePrintf(stderr, "failed to write cached hash:  %s\n", err)
+				}
 			}
 			wg.Done()
 		}
@@ -273,26 +471,118 @@ func findNew(stdout io.Writer, stderr io.Writer, prf progressReporterFactory, ar
 
 	wg.Wait()
 
-	sort.Strings(results)
-
-	err = tx.Commit()
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	groupBatchCopies(results)
+	if resultCapture != nil {
+		for _, result := range newResults(results) {
+			*resultCapture = append(*resultCapture, result.Path)
+		}
+	}
 
+	if args.summary != "" {
+		summaries := summarise(results, args.summary)
+		sortSummaries(summaries, args.sortBy)
+		err = writeSummaries(stdout, args.format, summaries)
+	} else {
+		err = writeFindNewResults(stdout, args.format, args.foldersOnly, results)
+	}
+	if err == nil && args.showDuplicates && (args.format == "text" || args.format == "") {
+		err = writeDuplicateDetails(stdout, results)
+	}
 	if err != nil {
-		diePrintf(stderr, "failed to commit transaction:  %s\n", err)
+		diePrintf(stderr, "failed to write results:  %s\n", err)
+	}
+
+	if args.quarantineDir != "" {
+		quarantine(stderr, db, args, duplicates, existsMap)
 	}
 
+	counts := make(map[string]int)
 	for _, result := range results {
-		fmt.Fprintln(stdout, result)
+		counts[result.Status]++
+		counts[result.Match]++
+	}
+	_, _ = fmt.Fprintf(stderr, "(%d new songs)\n", counts[statusNew])
+	if recorded := counts[statusDuplicate] - counts[listedInManifest]; recorded > 0 {
+		_, _ = fmt.Fprintf(stderr, "(%d already recorded:  %d %ss; %d %s; %d %s)\n", recorded,
+			counts[mp3util.IdenticalFile], mp3util.IdenticalFile, counts[mp3util.RetaggedCopy], mp3util.RetaggedCopy,
+			counts[mp3util.SameAudio], mp3util.SameAudio)
+	}
+	if counts[listedInManifest] > 0 {
+		_, _ = fmt.Fprintf(stderr, "(%d listed in manifests)\n", counts[listedInManifest])
+	}
+	if counts[statusCopy] > 0 {
+		_, _ = fmt.Fprintf(stderr, "(%d extra copies of new songs)\n", counts[statusCopy])
 	}
+	if counts[statusUnreadable] > 0 {
+		_, _ = fmt.Fprintf(stderr, "(%d unreadable files)\n", counts[statusUnreadable])
+	}
+}
 
-	_, _ = fmt.Fprintf(stdout, "(%d new songs)\n", uniq)
+type match struct {
+	canonical mp3util.Song
+	kind      string
+}
+
+// matchesFor lists every recorded song an incoming file duplicates, exact copies of the file first.
+func matchesFor(h hashedFile, existsMap map[string][]mp3util.Song) []match {
+	var result []match
+	for _, song := range existsMap[h.hash] {
+		result = append(result, match{song, mp3util.Song{FileHash: h.fileHash}.MatchKind(song)})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].kind == mp3util.IdenticalFile && result[j].kind != mp3util.IdenticalFile
+	})
+	return result
+}
+
+// manifestsListing returns the paths of the manifests that list a hash, in order.
+func manifestsListing(manifests map[string]manifest.Manifest, hash string) []string {
+	var result []string
+	for path, m := range manifests {
+		if m.Contains(hash) {
+			result = append(result, path)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// groupBatchCopies finds new files that share a hash within the batch being scanned.  One of each group stays new and
+// lists the rest as Alternates; the rest become copies of it, so each new song is only reported once.
+func groupBatchCopies(results []findNewResult) {
+	byHash := make(map[string][]int)
+	for i, result := range results {
+		if result.Status == statusNew {
+			byHash[result.Hash] = append(byHash[result.Hash], i)
+		}
+	}
+
+	for _, group := range byHash {
+		if len(group) < 2 {
+			continue
+		}
+		// prefer "01.mp3" over "01 (1).mp3"
+		sort.SliceStable(group, func(i, j int) bool {
+			return len(filepath.Base(results[group[i]].Path)) < len(filepath.Base(results[group[j]].Path))
+		})
+		primary := &results[group[0]]
+		for _, i := range group[1:] {
+			copied := &results[i]
+			copied.Status = statusCopy
+			copied.DuplicateOf = primary.Path
+			copied.Match = mp3util.Song{FileHash: copied.FileHash}.MatchKind(mp3util.Song{FileHash: primary.FileHash})
+			primary.Alternates = append(primary.Alternates, copied.Path)
+		}
+		sort.Strings(primary.Alternates)
+	}
 }
 
 func dieUnlessDirectoryExists(stderr io.Writer, directory string) {
 	info, err := os.Stat(directory)
 	if (err != nil && os.IsNotExist(err)) || !info.IsDir() {
 		_, _ = fmt.Fprintf(stderr, "%q is not a directory\n", directory)
-		if strings.HasSuffix(os.Args[2], "\\\"") {
+		if len(os.Args) > 2 && strings.HasSuffix(os.Args[2], "\\\"") {
 			_, _ = fmt.Fprintf(stderr, "hint:  are you on Windows and using a quoted directory with the trailing backslash?")
 		}
 		recordCmd.Usage()
@@ -300,8 +590,11 @@ func dieUnlessDirectoryExists(stderr io.Writer, directory string) {
 	}
 }
 
-func fetchSongsOrDie(stderr io.Writer, dbPath string, reparse bool) (*records.RecordKeeper, map[string]mp3util.Song) {
-	db, err := records.Open(dbPath)
+// fetchSongsOrDie opens the database and returns the songs that don't need reparsing, by path, along with the
+// collection of every song recorded.
+func fetchSongsOrDie(stderr io.Writer, dbPath string,
+	reparse bool) (records.Store, map[string]mp3util.Song, map[string]string) {
+	db, err := records.OpenStore(dbPath)
 	if err != nil {
 		diePrintln(stderr, err)
 	}
@@ -312,14 +605,21 @@ func fetchSongsOrDie(stderr io.Writer, dbPath string, reparse bool) (*records.Re
 	}
 
 	existingMap := make(map[string]mp3util.Song)
+	collections := make(map[string]string)
+	for _, existingFile := range existing {
+		collections[existingFile.Path] = existingFile.Collection
+	}
 
 	if !reparse {
 		for _, existingFile := range existing {
-			existingMap[existingFile.Path] = existingFile
+			// rows recorded before file hashes and sizes were are reparsed to fill them in
+			if existingFile.FileHash != "" && existingFile.Size != 0 {
+				existingMap[existingFile.Path] = existingFile
+			}
 		}
 	}
 
-	return db, existingMap
+	return db, existingMap, collections
 }
 
 func diePrintf(w io.Writer, format string, args ...interface{}) {
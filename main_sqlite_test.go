@@ -0,0 +1,449 @@
+//go:build cgo
+// +build cgo
+
+// These tests need SQLite, which needs cgo.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindNew(t *testing.T) {
+	testFindNew(t, "smartmp3mgr*.sql")
+}
+
+func TestFindNewQuarantine(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	incoming := filepath.Join(tmpPath, "incoming")
+	quarantineDir := filepath.Join(tmpPath, "quarantine")
+	_ = os.MkdirAll(filepath.Join(incoming, "album"), 0755)
+
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "1.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-altered-tags.mp3"), filepath.Join(incoming, "album", "2.mp3"), t)
+	_, _ = mp3util.RetagMP3(filepath.Join(incoming, "album", "2.mp3"), map[string]string{"Title": "Retagged"})
+	writeRandomFile(filepath.Join(incoming, "album", "3.mp3"), t)
+
+	findNew(os.Stdout, os.Stderr, newTestProgressBar, findNewArgs{
+		directory:           incoming,
+		dbPath:              dbPath,
+		degreeOfParallelism: 20,
+		quarantineDir:       quarantineDir,
+	}, nil)
+
+	remaining, _ := mp3fileutil.FindMP3Files(incoming)
+	if !reflect.DeepEqual(remaining, []string{filepath.Join(incoming, "album", "3.mp3")}) {
+		t.Errorf("Only the new file should remain, found %+v", remaining)
+	}
+	for _, moved := range []string{"1.mp3", filepath.Join("album", "2.mp3")} {
+		if _, err := os.Stat(filepath.Join(quarantineDir, moved)); err != nil {
+			t.Errorf("%s wasn't quarantined", moved)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(quarantineDir, quarantineManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, _ := csv.NewReader(f).ReadAll()
+	if len(rows) != 3 {
+		t.Fatalf("Expected a header and two rows in the manifest, found %+v", rows)
+	}
+	if rows[1][0] != filepath.Join(incoming, "1.mp3") || rows[1][2] != testHelpers.GetFixturePath("spring-chicken.mp3") {
+		t.Errorf("Manifest row doesn't name the canonical file:  %+v", rows[1])
+	}
+	if rows[1][3] != mp3util.IdenticalFile || rows[2][3] != mp3util.RetaggedCopy {
+		t.Errorf("Manifest rows weren't classified:  %+v", rows[1:])
+	}
+}
+
+func TestFindNewQuarantineRecordedFolder(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	incoming := filepath.Join(tmpPath, "incoming")
+	quarantineDir := filepath.Join(tmpPath, "quarantine")
+	_ = os.Mkdir(incoming, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "1.mp3"), t)
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           incoming,
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+	})
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "2.mp3"), t)
+
+	findNew(ioutil.Discard, os.Stderr, newTestProgressBar, findNewArgs{
+		directory:           incoming,
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+		quarantineDir:       quarantineDir,
+	}, nil)
+
+	remaining, _ := mp3fileutil.FindMP3Files(incoming)
+	expected := []string{filepath.Join(incoming, "1.mp3")}
+	if !reflect.DeepEqual(expected, remaining) {
+		t.Errorf("Only the recorded file should remain  \r\nExpected:  %v  \r\nActual:  %v", expected, remaining)
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "2.mp3")); err != nil {
+		t.Errorf("The extra copy wasn't quarantined:  %s", err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	_, dbPath := recordFixtures(t)
+	var stdout bytes.Buffer
+	runQuery(&stdout, ioutil.Discard, queryArgs{
+		query:  `title:"wakka wakka" bitrate>=160 -artist:thanks`,
+		dbPath: dbPath,
+		sortBy: "-path",
+		format: "json",
+	})
+
+	var songs []mp3util.Song
+	err := json.Unmarshal(stdout.Bytes(), &songs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, song := range songs {
+		paths = append(paths, filepath.Base(song.Path))
+	}
+	expected := []string{"wakka-wakka-with-id3v1.mp3", "wakka-wakka-default.mp3"}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Values differed.  \r\nExpected:  %v  \r\nActual:  %v", expected, paths)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	_, dbPath := recordFixtures(t)
+	var stdout bytes.Buffer
+	search(&stdout, ioutil.Discard, searchArgs{text: "SPRING chick", dbPath: dbPath, limit: 10, format: "csv"})
+
+	rows, err := csv.NewReader(&stdout).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || filepath.Base(rows[1][0]) != "spring-chicken.mp3" {
+		t.Errorf("Unexpected search results:  %v", rows)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("watching is only supported on Linux")
+	}
+	tmpPath, dbPath := tempDb(t)
+	library := filepath.Join(tmpPath, "library")
+	_ = os.MkdirAll(library, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(library, "1.mp3"), t)
+
+	stop := make(chan os.Signal)
+	done := make(chan bool)
+	go func() {
+		watchLibrary(ioutil.Discard, os.Stderr, watchArgs{
+			directories: []string{library},
+			dbPath:      dbPath,
+			debounce:    50 * time.Millisecond,
+		}, stop)
+		done <- true
+	}()
+
+	rk, err := records.Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rk.Close()
+	waitForPaths := func(expected ...string) {
+		var actual []string
+		for i := 0; i < 100; i++ {
+			songs, _ := rk.FetchSongs()
+			actual = nil
+			for _, song := range songs {
+				rel, _ := filepath.Rel(library, song.Path)
+				actual = append(actual, rel)
+			}
+			sort.Strings(actual)
+			if reflect.DeepEqual(actual, expected) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("Songs weren't updated  \r\nExpected:  %v  \r\nActual:  %v", expected, actual)
+	}
+
+	// caught up at startup
+	waitForPaths("1.mp3")
+
+	album := filepath.Join(library, "album")
+	_ = os.Mkdir(album, 0755)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-default.mp3"), filepath.Join(album, "2.mp3"), t)
+	waitForPaths("1.mp3", filepath.Join("album", "2.mp3"))
+
+	_ = os.Rename(filepath.Join(album, "2.mp3"), filepath.Join(library, "3.mp3"))
+	waitForPaths("1.mp3", "3.mp3")
+
+	_ = os.Remove(filepath.Join(library, "1.mp3"))
+	waitForPaths("3.mp3")
+
+	stop <- os.Interrupt
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The watcher didn't stop")
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	rk, _ := records.Open(dbPath)
+	_ = rk.CacheHash("/incoming/new.mp3", "abc", "def")
+	expected, _ := rk.FetchSongs()
+	_ = rk.Close()
+	sort.Slice(expected, func(i, j int) bool { return expected[i].Path < expected[j].Path })
+
+	for _, format := range []string{"jsonl", "csv"} {
+		exportPath := filepath.Join(tmpPath, "catalogue."+format)
+		exportLibrary(ioutil.Discard, ioutil.Discard, exportArgs{dbPath: dbPath, path: exportPath, format: format})
+
+		newDbPath := filepath.Join(tmpPath, format+".sql")
+		var stdout bytes.Buffer
+		importLibrary(&stdout, ioutil.Discard, importArgs{dbPath: newDbPath, path: exportPath, format: format,
+			onConflict: records.KeepExisting})
+		if !strings.Contains(stdout.String(), "5 songs added") {
+			t.Errorf("Unexpected import summary:  %s", stdout.String())
+		}
+
+		rk, _ := records.Open(newDbPath)
+		actual, _ := rk.FetchSongs()
+		hashes, _ := rk.GetHashes()
+		albums, _ := rk.FetchAlbums()
+		_ = rk.Close()
+		sort.Slice(actual, func(i, j int) bool { return actual[i].Path < actual[j].Path })
+		expected := append([]mp3util.Song(nil), expected...)
+		if format == "csv" {
+			// CSV catalogues don't keep modification times or genre frames as written
+			for i := range expected {
+				expected[i].ModTime, expected[i].RawGenre = 0, ""
+			}
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Songs don't survive a %s round trip  \r\nExpected:  %+v  \r\nActual:  %+v", format, expected,
+				actual)
+		}
+		if hashes["/incoming/new.mp3"].FileHash != "def" {
+			t.Errorf("Cached hashes don't survive a %s round trip:  %v", format, hashes)
+		}
+		if len(albums) == 0 {
+			t.Error("Expected albums to be rebuilt after importing")
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tmpPath := tempDir(t)
+
+	hash := func(i int) string { return strings.Repeat(fmt.Sprintf("%02x", i), 32) }
+	save := func(name string, songs ...mp3util.Song) string {
+		dbPath := filepath.Join(tmpPath, name)
+		db, err := records.OpenStore(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, song := range songs {
+			_ = db.RecordSong(song)
+		}
+		_ = db.Close()
+		return dbPath
+	}
+	a := save("a.sql",
+		mp3util.Song{Path: "/a/1.mp3", Hash: hash(1), Artist: "Starpoint", Album: "Restless", Title: "One", TrackNumber: 1},
+		mp3util.Song{Path: "/a/2.mp3", Hash: hash(2), Artist: "Starpoint", Album: "Restless", TrackNumber: 2},
+		mp3util.Song{Path: "/a/3.mp3", Hash: hash(3), Artist: "Starpoint", Album: "Restless", Title: "Three", TrackNumber: 3})
+	b := save("b.jsonl",
+		mp3util.Song{Path: "/b/x.mp3", Hash: hash(2), Artist: "Starpoint", Album: "Restless", Title: "Two"},
+		mp3util.Song{Path: "/b/y.mp3", Hash: hash(4), Artist: "Bryan Teoh", Album: "FreePD Music", Title: "Four"})
+
+	run := func(args compareArgs) comparison {
+		var stdout bytes.Buffer
+		args.format = "json"
+		compare(&stdout, os.Stderr, args)
+		var c comparison
+		err := json.Unmarshal(stdout.Bytes(), &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := run(compareArgs{a: a, b: b})
+	titles := func(albums []comparedAlbum) []string {
+		var result []string
+		for _, album := range albums {
+			for _, song := range album.Songs {
+				result = append(result, album.Artist+"/"+song.Title)
+			}
+		}
+		return result
+	}
+	for name, expected := range map[string][]string{
+		"only in A": {"Starpoint/One", "Starpoint/Three"},
+		"only in B": {"Bryan Teoh/Four"},
+		"in both":   {"Starpoint/Two"},
+	} {
+		actual := map[string][]string{"only in A": titles(c.OnlyInA), "only in B": titles(c.OnlyInB),
+			"in both": titles(c.InBoth)}[name]
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("Unexpected songs %s  \r\nExpected:  %v  \r\nActual:  %v", name, expected, actual)
+		}
+	}
+	if both := c.InBoth[0].Songs[0]; both.PathA != "/a/2.mp3" || both.PathB != "/b/x.mp3" {
+		t.Errorf("Unexpected paths  \r\nExpected:  %v  \r\nActual:  %v", "/a/2.mp3 and /b/x.mp3", both)
+	}
+
+	manifestPath := filepath.Join(tmpPath, "b.manifest")
+	exportManifest(ioutil.Discard, ioutil.Discard, exportManifestArgs{dbPath: b, path: manifestPath,
+		format: manifest.Binary})
+	c = run(compareArgs{a: a, manifest: manifestPath})
+	if len(titles(c.OnlyInA)) != 2 || len(titles(c.InBoth)) != 1 || c.OnlyInB != nil {
+		t.Errorf("Unexpected comparison with a manifest:  %+v", c)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tmpPath := tempDir(t)
+
+	open := func(name string) records.Store {
+		db, err := records.OpenStore(filepath.Join(tmpPath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	alice := open("alice.sql")
+	_ = alice.RecordSong(mp3util.Song{Path: `D:\Music\Starpoint\1.mp3`, Hash: "h1", Title: "Object of My Desire"})
+	_ = alice.RecordSong(mp3util.Song{Path: `D:\Music\shared.mp3`, Hash: "h2", Title: "Alice's"})
+	_ = alice.CacheHash(`D:\Music\shared.mp3`, "h2", "")
+	_ = alice.Close()
+	bob := open("bob.jsonl")
+	_ = bob.RecordSong(mp3util.Song{Path: "/home/bob/music/shared.mp3", Hash: "h2", Title: "Bob's"})
+	_ = bob.RecordSong(mp3util.Song{Path: "/home/bob/music/2.mp3", Hash: "h3", Title: "Wakka Wakka"})
+	_ = bob.CacheHash("/home/bob/music/shared.mp3", "h2", "f2")
+	_ = bob.Close()
+
+	var stdout bytes.Buffer
+	mergeLibraries(&stdout, os.Stderr, mergeArgs{
+		dbPath: filepath.Join(tmpPath, "merged.sql"),
+		sources: mergeSources{
+			{filepath.Join(tmpPath, "alice.sql"), []pathMapping{{`D:\Music`, "/srv/music"}}},
+			{filepath.Join(tmpPath, "bob.jsonl"), []pathMapping{{"/home/bob/music/", "/srv/music"}}},
+		},
+		onConflict: records.KeepExisting,
+	})
+	if !strings.Contains(stdout.String(), "3 songs added, 0 updated, 1 skipped") ||
+		!strings.Contains(stdout.String(), "1 cached hashes merged (1 duplicates dropped)") {
+		t.Errorf("Unexpected merge summary:  %s", stdout.String())
+	}
+
+	merged := open("merged.sql")
+	defer merged.Close()
+	songs, _ := merged.FetchSongs()
+	sort.Slice(songs, func(i, j int) bool { return songs[i].Path < songs[j].Path })
+	var paths []string
+	for _, song := range songs {
+		paths = append(paths, song.Path+" "+song.Title)
+	}
+	expected := []string{"/srv/music/2.mp3 Wakka Wakka", "/srv/music/Starpoint/1.mp3 Object of My Desire",
+		"/srv/music/shared.mp3 Alice's"}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Errorf("Unexpected merged songs  \r\nExpected:  %v  \r\nActual:  %v", expected, paths)
+	}
+	hashes, _ := merged.GetHashes()
+	if !reflect.DeepEqual(hashes, map[string]records.CachedHash{"/srv/music/shared.mp3": {Hash: "h2", FileHash: "f2"}}) {
+		t.Errorf("Unexpected merged hashes:  %v", hashes)
+	}
+}
+
+func TestRelocateRoot(t *testing.T) {
+	tmpPath := tempDir(t)
+	dbPath := filepath.Join(tmpPath, "library.sql")
+
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.RecordSong(mp3util.Song{Path: `D:\Music\Starpoint\1.mp3`, Hash: "h1", Title: "Object of My Desire"})
+	_ = db.RecordSong(mp3util.Song{Path: `E:\Other\2.mp3`, Hash: "h2", Title: "Wakka Wakka"})
+	_ = db.Close()
+
+	var stdout, stderr bytes.Buffer
+	addRoot(&stdout, &stderr, rootArgs{dbPath: dbPath, name: "music", path: `D:\Music\`})
+	relocateRoot(&stdout, &stderr, rootArgs{dbPath: dbPath, name: "music", path: tmpPath})
+	listRoots(&stdout, &stderr, rootsArgs{dbPath: dbPath})
+	expected := "Added root music at D:\\Music\\ (1 songs under it)\n" +
+		"Relocated root music at " + tmpPath + " (1 songs under it)\n" +
+		"music\t" + tmpPath + "\n"
+	if stdout.String() != expected {
+		t.Errorf("Unexpected output  \r\nExpected:  %v  \r\nActual:  %v", expected, stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "doesn't exist here") {
+		t.Errorf("Unexpected warnings  \r\nExpected:  %v  \r\nActual:  %v", "D:\\Music doesn't exist", stderr.String())
+	}
+
+	songs := fetchAllSongsOrDie(os.Stderr, dbPath)
+	var paths []string
+	for _, song := range songs {
+		paths = append(paths, song.Path)
+	}
+	sort.Strings(paths)
+	expectedPaths := []string{`E:\Other\2.mp3`, filepath.Join(tmpPath, "Starpoint", "1.mp3")}
+	sort.Strings(expectedPaths)
+	if !reflect.DeepEqual(expectedPaths, paths) {
+		t.Errorf("Unexpected paths  \r\nExpected:  %v  \r\nActual:  %v", expectedPaths, paths)
+	}
+}
+
+func TestLinkDupes(t *testing.T) {
+	tmpPath := tempDir(t)
+	library := filepath.Join(tmpPath, "library")
+	crate := filepath.Join(tmpPath, "crate")
+	_ = os.MkdirAll(library, 0755)
+	_ = os.MkdirAll(crate, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(library, "1.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(crate, "1.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-default.mp3"), filepath.Join(library, "2.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-altered-tags.mp3"), filepath.Join(crate, "2.mp3"), t)
+
+	linkDupes(ioutil.Discard, os.Stderr, linkDupesArgs{
+		directories: []string{library, crate},
+		dbPath:      filepath.Join(tmpPath, "records.sql"),
+		mode:        "hardlink",
+	})
+
+	sameFile := func(name string) bool {
+		a, _ := os.Stat(filepath.Join(library, name))
+		b, _ := os.Stat(filepath.Join(crate, name))
+		return os.SameFile(a, b)
+	}
+	if !sameFile("1.mp3") {
+		t.Error("Identical copies weren't linked")
+	}
+	if sameFile("2.mp3") {
+		t.Error("Copies with different tags were linked")
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/lint"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
 	"github.com/caseyjmorris/smartmp3mgr/records"
 	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
 	"io/ioutil"
@@ -11,7 +17,9 @@ import (
 	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 type testProgressBar struct {
@@ -27,9 +35,37 @@ func newTestProgressBar(max int64, description ...string) progressReporter {
 	return new(testProgressBar)
 }
 
+// tempDir makes a temporary directory that's removed when the test finishes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// tempDb returns a temporary directory and the path of a database in it, which doesn't exist yet.  The database is
+// the kind the build uses by default:  SQLite with cgo, or a file store without.
+func tempDb(t *testing.T) (dir string, dbPath string) {
+	dir = tempDir(t)
+	return dir, filepath.Join(dir, "records"+filepath.Ext(defaultDbName))
+}
+
+// recordFixtures records the test fixtures into a new database in a temporary directory.
+func recordFixtures(t *testing.T) (dir string, dbPath string) {
+	dir, dbPath = tempDb(t)
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           testHelpers.GetFixturePath(""),
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+	})
+	return dir, dbPath
+}
+
 func TestRecord(t *testing.T) {
 	path := testHelpers.GetFixturePath("")
-	dbf, err := ioutil.TempFile(os.TempDir(), "smartmp3mgr*.sql")
+	dbf, err := ioutil.TempFile(os.TempDir(), "smartmp3mgr*"+filepath.Ext(defaultDbName))
 	if err != nil {
 		t.Error("Couldn't make DB temp file")
 		return
@@ -48,9 +84,13 @@ func TestRecord(t *testing.T) {
 		record(os.Stdout, os.Stderr, newTestProgressBar, args)
 	}
 
-	rk, _ := records.Open(dbPath)
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
 
-	res, _ := rk.FetchSongs()
+	res, _ := db.FetchSongs()
 	var baseNamesOnly []string
 	expected := []string{"spring-chicken.mp3", "wakka-wakka-altered-tags.mp3", "wakka-wakka-default.mp3",
 		"wakka-wakka-no-tags.mp3", "wakka-wakka-with-id3v1.mp3"}
@@ -64,14 +104,49 @@ func TestRecord(t *testing.T) {
 	}
 }
 
-func TestFindNew(t *testing.T) {
+func TestRecordRoot(t *testing.T) {
+	_, dbPath := tempDb(t)
+	args := recordArgs{directory: testHelpers.GetFixturePath(""), dbPath: dbPath, degreeOfParallelism: 2}
+	var stderr bytes.Buffer
+	record(ioutil.Discard, &stderr, newTestProgressBar, args)
+	if !strings.Contains(stderr.String(), "isn't under any root") {
+		t.Errorf("Expected a warning about recording outside every root, got %q", stderr.String())
+	}
+
+	args.root = "fixtures"
+	record(ioutil.Discard, ioutil.Discard, newTestProgressBar, args)
+	args.root = ""
+	stderr.Reset()
+	record(ioutil.Discard, &stderr, newTestProgressBar, args)
+	if stderr.Len() != 0 {
+		t.Errorf("Unexpected warning recording under a root:  %q", stderr.String())
+	}
+
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	roots, _ := db.FetchRoots()
+	directory, _ := filepath.Abs(args.directory)
+	expected := []records.Root{{Name: "fixtures", Path: directory}}
+	if !reflect.DeepEqual(expected, roots) {
+		t.Errorf("Values differed.  \r\nExpected:  %v  \r\nActual:  %v", expected, roots)
+	}
+}
+
+func TestFindNewFileStore(t *testing.T) {
+	testFindNew(t, "smartmp3mgr*.jsonl")
+}
+
+func testFindNew(t *testing.T, dbPattern string) {
 	b := make([]byte, 8)
 	_, _ = rand.Read(b)
 	h := hex.EncodeToString(b[:7])
 	tmpPath := filepath.Join(os.TempDir(), "test"+h)
 	os.Mkdir(tmpPath, 0755)
 	path := testHelpers.GetFixturePath("")
-	dbf, err := ioutil.TempFile(os.TempDir(), "smartmp3mgr*.sql")
+	dbf, err := ioutil.TempFile(os.TempDir(), dbPattern)
 	if err != nil {
 		t.Error("Couldn't make DB temp file")
 		return
@@ -115,6 +190,424 @@ func TestFindNew(t *testing.T) {
 	}
 }
 
+func TestFindNewFormats(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	incoming := filepath.Join(tmpPath, "incoming")
+	_ = os.Mkdir(incoming, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "1.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "2.mp3"), t)
+	_ = ioutil.WriteFile(filepath.Join(incoming, "3.mp3"), []byte("too short"), 0644)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-default.mp3"), filepath.Join(incoming, "4.mp3"), t)
+
+	run := func(format string) string {
+		var stdout bytes.Buffer
+		findNew(&stdout, ioutil.Discard, newTestProgressBar, findNewArgs{
+			directory:           incoming,
+			dbPath:              dbPath,
+			degreeOfParallelism: 20,
+			format:              format,
+		}, nil)
+		return stdout.String()
+	}
+	var results []findNewResult
+
+	var statuses []string
+	dec := json.NewDecoder(strings.NewReader(run("jsonl")))
+	for dec.More() {
+		var result findNewResult
+		err := dec.Decode(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, result.Status)
+		results = append(results, result)
+	}
+	expected := []string{statusDuplicate, statusNew, statusUnreadable, statusDuplicate}
+	if !reflect.DeepEqual(expected, statuses) {
+		t.Errorf("Values differed.  \nExpected:  \n%+v\n\nFound:  \n%+v", expected, statuses)
+	}
+
+	if results[0].DuplicateOf != testHelpers.GetFixturePath("spring-chicken.mp3") || results[0].Tags == nil {
+		t.Errorf("Duplicate wasn't described:  %+v", results[0])
+	}
+	wakkaMatches := results[3].Matches
+	if len(wakkaMatches) != 4 || wakkaMatches[0].Path != testHelpers.GetFixturePath("wakka-wakka-default.mp3") ||
+		wakkaMatches[0].Match != mp3util.IdenticalFile || wakkaMatches[1].Match != mp3util.RetaggedCopy {
+		t.Errorf("Expected every recorded copy to be listed, the identical one first:  %+v", wakkaMatches)
+	}
+
+	var details bytes.Buffer
+	findNew(&details, ioutil.Discard, newTestProgressBar, findNewArgs{
+		directory:           incoming,
+		dbPath:              dbPath,
+		degreeOfParallelism: 20,
+		showDuplicates:      true,
+	}, nil)
+	if !strings.Contains(details.String(), "    = "+testHelpers.GetFixturePath("spring-chicken.mp3")) {
+		t.Errorf("Expected duplicate details, found %q", details.String())
+	}
+
+	if text := run("text"); text != filepath.Join(incoming, "2.mp3")+"\n" {
+		t.Errorf("Expected only the new file on stdout, found %q", text)
+	}
+	if null := run("null"); null != filepath.Join(incoming, "2.mp3")+"\x00" {
+		t.Errorf("Expected a NUL-terminated path, found %q", null)
+	}
+	rows, err := csv.NewReader(strings.NewReader(run("csv"))).ReadAll()
+	if err != nil || len(rows) != 5 || rows[0][0] != "Path" {
+		t.Errorf("Expected a header and four rows, found %+v (%v)", rows, err)
+	}
+}
+
+func TestFindNewBatchCopies(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	for _, dir := range []string{"a", "b", "c"} {
+		_ = os.Mkdir(filepath.Join(tmpPath, dir), 0755)
+	}
+	mp3Path := func(dir, name string) string { return filepath.Join(tmpPath, dir, name) }
+	writeRandomFile(mp3Path("a", "01 (1).mp3"), t)
+	copyFile(mp3Path("a", "01 (1).mp3"), mp3Path("a", "01.mp3"), t)
+	copyFile(mp3Path("a", "01 (1).mp3"), mp3Path("b", "01.mp3"), t)
+	writeRandomFile(mp3Path("c", "02.mp3"), t)
+
+	run := func(foldersOnly bool, resultCapture *[]string) string {
+		var stdout bytes.Buffer
+		findNew(&stdout, ioutil.Discard, newTestProgressBar, findNewArgs{
+			directory:           tmpPath,
+			dbPath:              dbPath,
+			degreeOfParallelism: 20,
+			foldersOnly:         foldersOnly,
+		}, resultCapture)
+		return stdout.String()
+	}
+
+	var res []string
+	expected := mp3Path("a", "01.mp3") + "\n  + " + mp3Path("a", "01 (1).mp3") + "\n  + " + mp3Path("b", "01.mp3") +
+		"\n" + mp3Path("c", "02.mp3") + "\n"
+	if text := run(false, &res); text != expected {
+		t.Errorf("Values differed.  \nExpected:  \n%s\n\nFound:  \n%s", expected, text)
+	}
+	if !reflect.DeepEqual(res, []string{mp3Path("a", "01.mp3"), mp3Path("c", "02.mp3")}) {
+		t.Errorf("Expected each new song to be captured once, found %+v", res)
+	}
+
+	expected = filepath.Join(tmpPath, "a") + "\n" + filepath.Join(tmpPath, "c") + "\n"
+	if text := run(true, nil); text != expected {
+		t.Errorf("Values differed.  \nExpected:  \n%s\n\nFound:  \n%s", expected, text)
+	}
+}
+
+func TestFindNewSummary(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	incoming := filepath.Join(tmpPath, "incoming")
+	for _, dir := range []string{"owned", "partial", "new"} {
+		_ = os.MkdirAll(filepath.Join(incoming, dir), 0755)
+	}
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "owned", "1.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-default.mp3"), filepath.Join(incoming, "partial", "1.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "partial", "2.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "new", "1.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "new", "2.mp3"), t)
+
+	var stdout bytes.Buffer
+	findNew(&stdout, ioutil.Discard, newTestProgressBar, findNewArgs{
+		directory:           incoming,
+		dbPath:              dbPath,
+		degreeOfParallelism: 20,
+		format:              "json",
+		summary:             "folder",
+		sortBy:              "new",
+	}, nil)
+
+	var summaries []groupSummary
+	err := json.Unmarshal(stdout.Bytes(), &summaries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []groupSummary{
+		{Name: filepath.Join(incoming, "new"), New: 2, Verdict: verdictNew},
+		{Name: filepath.Join(incoming, "partial"), New: 1, Duplicate: 1, Verdict: verdictPartial},
+		{Name: filepath.Join(incoming, "owned"), Duplicate: 1, Verdict: verdictOwned},
+	}
+	if !reflect.DeepEqual(expected, summaries) {
+		t.Errorf("Values differed.  \nExpected:  \n%+v\n\nFound:  \n%+v", expected, summaries)
+	}
+}
+
+func TestAlbumCompletes(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	owned := filepath.Join(tmpPath, "owned")
+	incoming := filepath.Join(tmpPath, "incoming")
+	_ = os.MkdirAll(owned, 0755)
+	_ = os.MkdirAll(incoming, 0755)
+
+	tracks := []struct{ fixture, path, track string }{
+		{"spring-chicken.mp3", filepath.Join(owned, "1.mp3"), "1/2"},
+		{"wakka-wakka-default.mp3", filepath.Join(incoming, "2.mp3"), "2/2"},
+	}
+	for _, track := range tracks {
+		copyFile(testHelpers.GetFixturePath(track.fixture), track.path, t)
+		_, err := mp3util.RetagMP3(track.path, map[string]string{"Album": "Album", "AlbumArtist": "Band",
+			"Track": track.track})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           owned,
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+	})
+
+	var stdout bytes.Buffer
+	albumCompletes(&stdout, ioutil.Discard, albumCompletesArgs{directory: incoming, dbPath: dbPath})
+
+	expected := fmt.Sprintf("Band - Album  %s (have 1 of 2)\n  + %s\n  (complete)\n(1 owned albums can be added to)\n",
+		owned, tracks[1].path)
+	if stdout.String() != expected {
+		t.Errorf("Unexpected output.  \r\nExpected:  %q  \r\nActual:  %q", expected, stdout.String())
+	}
+}
+
+func TestMissingTracks(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	path := filepath.Join(tmpPath, "2.mp3")
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), path, t)
+	_, err := mp3util.RetagMP3(path, map[string]string{"Album": "Album", "Track": "2/3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           tmpPath,
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+	})
+
+	var stdout bytes.Buffer
+	missingTracks(&stdout, ioutil.Discard, missingTracksArgs{dbPath: dbPath, format: "json"})
+
+	var reports []struct {
+		Album string
+		Discs []struct {
+			MissingTracks []int
+		}
+	}
+	err = json.Unmarshal(stdout.Bytes(), &reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Album != "Album" || len(reports[0].Discs) != 1 ||
+		!reflect.DeepEqual(reports[0].Discs[0].MissingTracks, []int{1, 3}) {
+		t.Errorf("Unexpected report:  %s", stdout.String())
+	}
+}
+
+func TestLint(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-no-tags.mp3"), filepath.Join(tmpPath, "1.mp3"), t)
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           tmpPath,
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+	})
+
+	var stdout bytes.Buffer
+	errors := lintLibrary(&stdout, ioutil.Discard, lintArgs{dbPath: dbPath, format: "text"})
+	if errors != 2 || !strings.Contains(stdout.String(), "no title [empty-title]") {
+		t.Errorf("Expected 2 errors for an untagged file, got %d:  \n%s", errors, stdout.String())
+	}
+
+	stdout.Reset()
+	config := lint.Config{Disabled: []string{"empty-title"}, Severities: map[string]lint.Severity{
+		"empty-artist": lint.Warning}}
+	errors = lintLibrary(&stdout, ioutil.Discard, lintArgs{dbPath: dbPath, format: "text", config: config})
+	if errors != 0 {
+		t.Errorf("Expected no errors with the rules toggled, got %d:  \n%s", errors, stdout.String())
+	}
+}
+
+func TestFindNewManifest(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	incoming := filepath.Join(tmpPath, "incoming")
+	_ = os.Mkdir(incoming, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "1.mp3"), t)
+	copyFile(testHelpers.GetFixturePath("wakka-wakka-altered-tags.mp3"), filepath.Join(incoming, "2.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "3.mp3"), t)
+
+	for _, format := range manifest.Formats {
+		manifestPath := filepath.Join(tmpPath, "manifest."+format)
+		exportManifest(ioutil.Discard, ioutil.Discard, exportManifestArgs{dbPath: dbPath, path: manifestPath,
+			format: format, falsePositiveRate: 0.001})
+
+		var res []string
+		var stdout bytes.Buffer
+		findNew(&stdout, ioutil.Discard, newTestProgressBar, findNewArgs{directory: incoming, degreeOfParallelism: 2,
+			format: "jsonl", manifests: []string{manifestPath}}, &res)
+		expected := []string{filepath.Join(incoming, "3.mp3")}
+		if !reflect.DeepEqual(expected, res) {
+			t.Errorf("Unexpected new files with a %s manifest  \r\nExpected:  %v  \r\nActual:  %v", format, expected,
+				res)
+		}
+		if !strings.Contains(stdout.String(), `"match":"`+listedInManifest+`"`) {
+			t.Errorf("Expected matches listed in the %s manifest:  %s", format, stdout.String())
+		}
+	}
+}
+
+func TestFindNewAgainst(t *testing.T) {
+	tmpPath, dbPath := tempDb(t)
+	record(ioutil.Discard, os.Stderr, newTestProgressBar, recordArgs{
+		directory:           testHelpers.GetFixturePath(""),
+		dbPath:              dbPath,
+		degreeOfParallelism: 2,
+		collection:          "dj",
+	})
+
+	incoming := filepath.Join(tmpPath, "incoming")
+	_ = os.Mkdir(incoming, 0755)
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), filepath.Join(incoming, "1.mp3"), t)
+	writeRandomFile(filepath.Join(incoming, "2.mp3"), t)
+
+	findNewAgainst := func(against ...string) ([]string, string) {
+		var res []string
+		var stderr bytes.Buffer
+		findNew(ioutil.Discard, &stderr, newTestProgressBar, findNewArgs{directory: incoming, dbPath: dbPath,
+			degreeOfParallelism: 2, against: against}, &res)
+		return res, stderr.String()
+	}
+	onlyNew := []string{filepath.Join(incoming, "2.mp3")}
+	if res, _ := findNewAgainst("dj"); !reflect.DeepEqual(onlyNew, res) {
+		t.Errorf("Unexpected new files against dj  \r\nExpected:  %v  \r\nActual:  %v", onlyNew, res)
+	}
+	res, stderr := findNewAgainst("canonical")
+	if len(res) != 2 || !strings.Contains(stderr, `no songs are recorded in collection "canonical"`) {
+		t.Errorf("Unexpected new files against canonical  \r\nExpected:  %v  \r\nActual:  %v %s", "both", res,
+			stderr)
+	}
+
+	var stdout bytes.Buffer
+	renameCollection(&stdout, os.Stderr, collectionArgs{dbPath: dbPath, names: []string{"dj", "canonical"}})
+	if res, _ := findNewAgainst("canonical", "kids"); !reflect.DeepEqual(onlyNew, res) {
+		t.Errorf("Unexpected new files after renaming  \r\nExpected:  %v  \r\nActual:  %v", onlyNew, res)
+	}
+	dropCollection(&stdout, os.Stderr, collectionArgs{dbPath: dbPath, names: []string{"canonical"}})
+	listCollections(&stdout, os.Stderr, collectionArgs{dbPath: dbPath})
+	if !strings.Contains(stdout.String(), "from dj to canonical") || !strings.Contains(stdout.String(), "Forgot ") {
+		t.Errorf("Unexpected output  \r\nExpected:  %v  \r\nActual:  %v", "a rename and a drop", stdout.String())
+	}
+	if res, _ := findNewAgainst(); len(res) != 2 {
+		t.Errorf("Unexpected new files after dropping  \r\nExpected:  %v  \r\nActual:  %v", "both", res)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, dbPath := recordFixtures(t)
+	var stdout bytes.Buffer
+	stats(&stdout, ioutil.Discard, statsArgs{dbPath: dbPath, top: 1, format: "json"})
+
+	var s records.Stats
+	err := json.Unmarshal(stdout.Bytes(), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the four wakka-wakka fixtures share their audio
+	if s.Files != 5 || s.Untagged != 1 || s.DuplicateFiles != 3 || s.TotalSize == 0 || len(s.TopArtists) != 1 ||
+		s.TopArtists[0].Value != "Bryan Teoh" {
+		t.Errorf("Unexpected stats:  %+v", s)
+	}
+
+	stdout.Reset()
+	stats(&stdout, ioutil.Discard, statsArgs{dbPath: dbPath, top: 1, format: "text"})
+	if !strings.Contains(stdout.String(), "48000 Hz") {
+		t.Errorf("Expected sample rates in the text output:  \n%s", stdout.String())
+	}
+}
+
+func TestReport(t *testing.T) {
+	tmpPath, dbPath := recordFixtures(t)
+	outPath := filepath.Join(tmpPath, "library.html")
+	report(ioutil.Discard, ioutil.Discard, reportArgs{dbPath: dbPath, outPath: outPath, top: 5})
+
+	b, err := ioutil.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(b)
+	for _, expected := range []string{"<h3>Bryan Teoh</h3>", "<h4>FreePD Music</h4>", "Spring Chicken",
+		"wakka-wakka-no-tags.mp3", "same audio, different tags", "empty-title", `id="filter"`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the report to contain %q", expected)
+		}
+	}
+	if strings.Contains(html, "src=") || strings.Contains(html, "href=\"http") {
+		t.Error("The report shouldn't load anything external")
+	}
+}
+
+func TestLinkDupesSavings(t *testing.T) {
+	tmpPath := tempDir(t)
+	library := filepath.Join(tmpPath, "library")
+	crate := filepath.Join(tmpPath, "crate")
+	_ = os.MkdirAll(library, 0755)
+	_ = os.MkdirAll(crate, 0755)
+	fixture := testHelpers.GetFixturePath("spring-chicken.mp3")
+	copyFile(fixture, filepath.Join(library, "1.mp3"), t)
+	copyFile(fixture, filepath.Join(crate, "1.mp3"), t)
+	copyFile(fixture, filepath.Join(library, "2.mp3"), t)
+	// a second name for a copy saves nothing more, and one for the keeper needs no link at all
+	for from, to := range map[string]string{filepath.Join(crate, "1.mp3"): filepath.Join(crate, "1b.mp3"),
+		filepath.Join(library, "1.mp3"): filepath.Join(crate, "1c.mp3")} {
+		if err := os.Link(from, to); err != nil {
+			t.Skip("hard links aren't supported here:  ", err)
+		}
+	}
+	info, _ := os.Stat(fixture)
+
+	var stdout bytes.Buffer
+	linkDupes(&stdout, os.Stderr, linkDupesArgs{
+		directories: []string{library, crate},
+		dbPath:      filepath.Join(tmpPath, "records.sql"),
+		mode:        "hardlink",
+		planPath:    filepath.Join(tmpPath, "plan.json"),
+	})
+	expected := fmt.Sprintf("Saved 3 links to %q; applying them would save %s\n", filepath.Join(tmpPath, "plan.json"),
+		formatBytes(2*info.Size()))
+	if !strings.HasSuffix(stdout.String(), expected) {
+		t.Errorf("Values differed.  \r\nExpected:  %v  \r\nActual:  %v", expected, stdout.String())
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	dir, dbPath := tempDb(t)
+	library := filepath.Join(dir, "library")
+	_ = os.MkdirAll(library, 0755)
+	path := filepath.Join(library, "1.mp3")
+	copyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), path, t)
+
+	db, err := records.OpenStore(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, tc := range []struct {
+		change   func()
+		recorded int
+	}{
+		{func() {}, 1},
+		{func() {}, 0},
+		// a retag that fits in the tag's padding changes the file but not its size
+		{func() { _ = os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)) }, 1},
+	} {
+		tc.change()
+		recorded, _ := catchUp(ioutil.Discard, db, []string{library})
+		if recorded != tc.recorded {
+			t.Errorf("Unexpected number of files recorded  \r\nExpected:  %v  \r\nActual:  %v", tc.recorded, recorded)
+		}
+	}
+}
+
 func writeRandomFile(to string, t *testing.T) {
 	b := make([]byte, 1024)
 	_, err := rand.Read(b)
@@ -138,3 +631,18 @@ func copyFile(from string, to string, t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestIsLoopback(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"192.168.1.2:80": false,
+	} {
+		if isLoopback(addr) != expected {
+			t.Errorf("Unexpected isLoopback(%q)  \r\nExpected:  %v  \r\nActual:  %v", addr, expected, !expected)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package manifest reads and writes hash manifests:  the audio hashes of a library and nothing else, so that someone
+// else can check which of their files it already has without seeing its paths or tags.
+//
+// A manifest is text (a header line, then a hex hash per line, sorted), binary (a header, then the hashes as raw
+// bytes, sorted) or a Bloom filter, which is smaller still but will now and then say it contains a hash it doesn't.
+// Read tells them apart by their headers.
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Version is the version of the formats written; Read refuses anything newer.
+const Version = 1
+
+const (
+	Text   = "text"
+	Binary = "binary"
+	Bloom  = "bloom"
+)
+
+var Formats = []string{Text, Binary, Bloom}
+
+const textHeader = "# smartmp3mgr manifest"
+
+// the binary headers are a magic string followed by a version byte
+var (
+	binaryMagic = []byte("SMP3MAN")
+	bloomMagic  = []byte("SMP3BLM")
+)
+
+// hashSize is the size of an audio hash, which is a SHA-256 (see mp3util.Hash).
+const hashSize = 32
+
+type hash [hashSize]byte
+
+func parseHash(s string) (hash, bool) {
+	var h hash
+	if hex.DecodedLen(len(s)) != hashSize {
+		return h, false
+	}
+	_, err := hex.Decode(h[:], []byte(s))
+	return h, err == nil
+}
+
+// Manifest is a set of hashes read from a manifest.
+type Manifest interface {
+	// Contains says whether the manifest lists a hash.  Bloom filters can say so wrongly.
+	Contains(hash string) bool
+	// Len is how many hashes were written into the manifest.
+	Len() int
+}
+
+// Set is a manifest that lists its hashes exactly, read from the text or binary format.
+type Set map[hash]struct{}
+
+func (s Set) Contains(hash string) bool {
+	h, ok := parseHash(hash)
+	if !ok {
+		return false
+	}
+	_, ok = s[h]
+	return ok
+}
+
+func (s Set) Len() int {
+	return len(s)
+}
+
+// sortedHashes parses, de-duplicates and sorts hashes.
+func sortedHashes(hashes []string) ([]hash, error) {
+	set := make(Set)
+	for _, s := range hashes {
+		h, ok := parseHash(s)
+		if !ok {
+			return nil, fmt.Errorf("%q isn't an audio hash", s)
+		}
+		set[h] = struct{}{}
+	}
+	result := make([]hash, 0, len(set))
+	for h := range set {
+		result = append(result, h)
+	}
+	sort.Slice(result, func(i, j int) bool { return bytes.Compare(result[i][:], result[j][:]) < 0 })
+	return result, nil
+}
+
+// Write writes a manifest of hashes in format.  falsePositiveRate is how often a Bloom filter may claim to contain a
+// hash it doesn't; it's ignored by the other formats.
+func Write(w io.Writer, format string, hashes []string, falsePositiveRate float64) error {
+	sorted, err := sortedHashes(hashes)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	switch format {
+	case Text:
+		_, _ = fmt.Fprintf(bw, "%s %d\n", textHeader, Version)
+		for _, h := range sorted {
+			_, _ = fmt.Fprintf(bw, "%x\n", h[:])
+		}
+	case Binary:
+		_, _ = bw.Write(append(append([]byte(nil), binaryMagic...), Version))
+		_ = binary.Write(bw, binary.BigEndian, uint32(len(sorted)))
+		for _, h := range sorted {
+			_, _ = bw.Write(h[:])
+		}
+	case Bloom:
+		if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+			return fmt.Errorf("the false positive rate must be between 0 and 1, not %g", falsePositiveRate)
+		}
+		f := newFilter(len(sorted), falsePositiveRate)
+		for _, h := range sorted {
+			f.add(h)
+		}
+		_, _ = bw.Write(append(append([]byte(nil), bloomMagic...), Version))
+		_ = binary.Write(bw, binary.BigEndian, []uint64{uint64(f.n), uint64(f.k), f.m})
+		_, _ = bw.Write(f.bits)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return bw.Flush()
+}
+
+// Read reads a manifest in any of the formats.
+func Read(r io.Reader) (Manifest, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(binaryMagic) + 1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, binaryMagic):
+		return readBinary(br)
+	case bytes.HasPrefix(head, bloomMagic):
+		return readBloom(br)
+	default:
+		return readText(br)
+	}
+}
+
+// ReadFile reads the manifest at path.
+func ReadFile(path string) (Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest %q:  %s", path, err)
+	}
+	return m, nil
+}
+
+func checkVersion(version int) error {
+	if version > Version {
+		return fmt.Errorf("the manifest is version %d, but only version %d and before can be read", version, Version)
+	}
+	return nil
+}
+
+func readText(br *bufio.Reader) (Manifest, error) {
+	set := make(Set)
+	scanner := bufio.NewScanner(br)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			var version int
+			_, err := fmt.Sscanf(strings.TrimPrefix(text, textHeader), "%d", &version)
+			if !strings.HasPrefix(text, textHeader) || err != nil {
+				return nil, errors.New("not a smartmp3mgr manifest")
+			}
+			err = checkVersion(version)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		h, ok := parseHash(text)
+		if !ok {
+			return nil, fmt.Errorf("line %d:  %q isn't an audio hash", n, text)
+		}
+		set[h] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func readBinary(br *bufio.Reader) (Manifest, error) {
+	header := make([]byte, len(binaryMagic)+1)
+	var count uint32
+	_, err := io.ReadFull(br, header)
+	if err == nil {
+		err = checkVersion(int(header[len(binaryMagic)]))
+	}
+	if err == nil {
+		err = binary.Read(br, binary.BigEndian, &count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the count comes from the file, so the set grows as the hashes are read rather than being sized by it
+	set := make(Set)
+	for i := uint32(0); i < count; i++ {
+		var h hash
+		_, err = io.ReadFull(br, h[:])
+		if err != nil {
+			return nil, fmt.Errorf("the manifest is cut short:  %s", err)
+		}
+		set[h] = struct{}{}
+	}
+	return set, nil
+}
+
+func readBloom(br *bufio.Reader) (Manifest, error) {
+	header := make([]byte, len(bloomMagic)+1)
+	sizes := make([]uint64, 3)
+	_, err := io.ReadFull(br, header)
+	if err == nil {
+		err = checkVersion(int(header[len(bloomMagic)]))
+	}
+	if err == nil {
+		err = binary.Read(br, binary.BigEndian, sizes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sizes[0] > math.MaxInt32 || sizes[1] < 1 || sizes[1] > maxFilterProbes || sizes[2] < 1 || sizes[2] > 1<<40 {
+		return nil, errors.New("the Bloom filter's header is corrupt")
+	}
+	f := &filter{n: int(sizes[0]), k: int(sizes[1]), m: sizes[2]}
+	// as with the binary format, the bits are only as many as are actually there, not as many as the header claims
+	size := int64((f.m + 7) / 8)
+	f.bits, err = ioutil.ReadAll(io.LimitReader(br, size))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(f.bits)) < size {
+		return nil, fmt.Errorf("the manifest is cut short:  %s", io.ErrUnexpectedEOF)
+	}
+	return f, nil
+}
+
+// filter is a Bloom filter of m bits set by k hash functions.  The hashes going in are already uniformly distributed,
+// so the functions are just combinations of two numbers taken from each (see Kirsch and Mitzenmacher, "Less Hashing,
+// Same Performance").
+type filter struct {
+	n, k int
+	m    uint64
+	bits []byte
+}
+
+const minFilterBits = 1024
+
+// maxFilterProbes is the most hash functions a filter can use; more would only be wanted for false positive rates
+// below one in 10^19.
+const maxFilterProbes = 64
+
+// newFilter sizes a filter for n hashes with the given false positive rate.
+func newFilter(n int, falsePositiveRate float64) *filter {
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := 1
+	if n > 0 {
+		k = int(math.Min(maxFilterProbes, math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2))))
+	}
+	// probes taken from two numbers fall on the same bits too often in tiny filters to keep to the rate, so those
+	// get more bits (but not more probes, which would only slow them down)
+	if m < minFilterBits {
+		m = minFilterBits
+	}
+	return &filter{n: n, k: k, m: m, bits: make([]byte, (m+7)/8)}
+}
+
+func (f *filter) positions(h hash) []uint64 {
+	a := binary.BigEndian.Uint64(h[0:8]) % f.m
+	// a step that's a multiple of m would put every probe on the same bit
+	b := binary.BigEndian.Uint64(h[8:16]) % f.m
+	if b == 0 {
+		b = 1
+	}
+	result := make([]uint64, f.k)
+	for i := range result {
+		result[i] = (a + uint64(i)*b) % f.m
+	}
+	return result
+}
+
+func (f *filter) add(h hash) {
+	for _, p := range f.positions(h) {
+		f.bits[p/8] |= 1 << (p % 8)
+	}
+}
+
+func (f *filter) Contains(hash string) bool {
+	h, ok := parseHash(hash)
+	if !ok {
+		return false
+	}
+	for _, p := range f.positions(h) {
+		if f.bits[p/8]&(1<<(p%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *filter) Len() int {
+	return f.n
+}
@@ -0,0 +1,124 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testHash(i int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprint(i)))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRoundTrip(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, testHash(i))
+	}
+	// duplicates are written once
+	hashes = append(hashes, testHash(0))
+
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		err := Write(&buf, format, hashes, 0.001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := Read(&buf)
+		if err != nil {
+			t.Fatalf("%s:  %s", format, err)
+		}
+
+		if m.Len() != 1000 {
+			t.Errorf("%s has the wrong length  \r\nExpected:  %v  \r\nActual:  %v", format, 1000, m.Len())
+		}
+		for _, h := range hashes {
+			if !m.Contains(h) {
+				t.Errorf("%s lost a hash  \r\nExpected:  %v  \r\nActual:  %v", format, h, "missing")
+				break
+			}
+		}
+		var falsePositives int
+		for i := 1000; i < 11000; i++ {
+			if m.Contains(testHash(i)) {
+				falsePositives++
+			}
+		}
+		if limit := map[string]int{Text: 0, Binary: 0, Bloom: 30}[format]; falsePositives > limit {
+			t.Errorf("%s has too many false positives  \r\nExpected:  <= %v  \r\nActual:  %v", format, limit,
+				falsePositives)
+		}
+		if m.Contains("nonsense") {
+			t.Errorf("%s contains nonsense", format)
+		}
+	}
+}
+
+func TestSizes(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, testHash(i))
+	}
+	sizes := make(map[string]int)
+	for _, format := range Formats {
+		var buf bytes.Buffer
+		_ = Write(&buf, format, hashes, 0.01)
+		sizes[format] = buf.Len()
+	}
+	if !(sizes[Bloom] < sizes[Binary] && sizes[Binary] < sizes[Text]) {
+		t.Errorf("Unexpected sizes  \r\nExpected:  %v  \r\nActual:  %v", "bloom < binary < text", sizes)
+	}
+}
+
+func bloomSizes(n, k, m uint64) string {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, []uint64{n, k, m})
+	return buf.String()
+}
+
+func TestReadRejects(t *testing.T) {
+	for name, data := range map[string]string{
+		"other file":    "Path,Hash\n",
+		"newer version": textHeader + " 2\n",
+		"bad hash":      textHeader + " 1\nnot-a-hash\n",
+		"cut short":     string(binaryMagic) + "\x01\x00\x00\x00\x02" + strings.Repeat("x", 40),
+		// headers claiming far more than is there, which mustn't be allocated up front
+		"huge count":  string(binaryMagic) + "\x01\xff\xff\xff\xff" + strings.Repeat("x", 40),
+		"huge filter": string(bloomMagic) + "\x01" + bloomSizes(1, 3, 1<<40) + strings.Repeat("x", 40),
+		"many probes": string(bloomMagic) + "\x01" + bloomSizes(1, 1<<40, 1024) + strings.Repeat("x", 128),
+	} {
+		_, err := Read(strings.NewReader(data))
+		if err == nil {
+			t.Errorf("Unexpected success reading %s", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, Text, []string{"adfd"}, 0)
+	if err == nil {
+		t.Errorf("Unexpected success writing a short hash")
+	}
+}
+
+func TestSmallBloomFilters(t *testing.T) {
+	var buf bytes.Buffer
+	_ = Write(&buf, Bloom, []string{testHash(0), testHash(1)}, 0.001)
+	m, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var falsePositives int
+	for i := 2; i < 10002; i++ {
+		if m.Contains(testHash(i)) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("Unexpected false positives  \r\nExpected:  <= %v  \r\nActual:  %v", 50, falsePositives)
+	}
+}
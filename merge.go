@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"strings"
+)
+
+// pathMapping rewrites paths starting with From to start with To instead.
+type pathMapping struct {
+	From, To string
+}
+
+func parsePathMapping(s string) (pathMapping, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 || i == len(s)-1 {
+		return pathMapping{}, fmt.Errorf("%q should be OLD=NEW", s)
+	}
+	return pathMapping{s[:i], s[i+1:]}, nil
+}
+
+// rewrite applies the mapping to path, if path is From or under it.  The rest of the path takes on the separators of
+// To, so that mapping D:\Music to /mnt/music turns D:\Music\a\b.mp3 into /mnt/music/a/b.mp3.
+func (m pathMapping) rewrite(path string) (string, bool) {
+	from := strings.TrimRight(m.From, `/\`)
+	if !strings.HasPrefix(path, from) {
+		return path, false
+	}
+	rest := path[len(from):]
+	if rest != "" && rest[0] != '/' && rest[0] != '\\' {
+		return path, false
+	}
+
+	to := strings.TrimRight(m.To, `/\`)
+	switch {
+	case strings.Contains(to, "/"):
+		rest = strings.ReplaceAll(rest, `\`, "/")
+	case strings.Contains(to, `\`):
+		rest = strings.ReplaceAll(rest, "/", `\`)
+	}
+	return to + rest, true
+}
+
+// mergeSource is a database to merge and the mappings for its paths.
+type mergeSource struct {
+	dbPath   string
+	mappings []pathMapping
+}
+
+// rewrite applies the first mapping that matches path.
+func (s mergeSource) rewrite(path string) (string, bool) {
+	for _, m := range s.mappings {
+		if rewritten, ok := m.rewrite(path); ok {
+			return rewritten, true
+		}
+	}
+	return path, false
+}
+
+// mergeSources is the -from and -map flags, which go together:  each -map applies to the -from before it.
+type mergeSources []mergeSource
+
+func (s *mergeSources) String() string {
+	var names []string
+	for _, source := range *s {
+		names = append(names, source.dbPath)
+	}
+	return strings.Join(names, ",")
+}
+
+// fromFlag is -from, which starts a new source.
+type fromFlag struct{ sources *mergeSources }
+
+func (f fromFlag) String() string {
+	if f.sources == nil {
+		return ""
+	}
+	return f.sources.String()
+}
+
+func (f fromFlag) Set(value string) error {
+	*f.sources = append(*f.sources, mergeSource{dbPath: value})
+	return nil
+}
+
+// mapFlag is -map, which adds a mapping to the last source.
+type mapFlag struct{ sources *mergeSources }
+
+func (f mapFlag) String() string {
+	return ""
+}
+
+func (f mapFlag) Set(value string) error {
+	if f.sources == nil || len(*f.sources) == 0 {
+		return errors.New("-map has to follow the -from it applies to")
+	}
+	m, err := parsePathMapping(value)
+	if err != nil {
+		return err
+	}
+	last := &(*f.sources)[len(*f.sources)-1]
+	last.mappings = append(last.mappings, m)
+	return nil
+}
+
+// mergeLibraries combines the songs and cached hashes of several databases into one, in a single transaction.  Paths
+// from each source are rewritten by its mappings; songs for a path that's already taken, whether in the target or by
+// an earlier source, are resolved by args.onConflict.  A path's cached hashes are only kept once, preferring ones
+// with a FileHash.
+func mergeLibraries(stdout io.Writer, stderr io.Writer, args mergeArgs) {
+	var songs []mp3util.Song
+	caches := make(map[string]records.CachedHash)
+	droppedCaches := 0
+
+	for _, source := range args.sources {
+		if _, err := os.Stat(source.dbPath); err != nil {
+			diePrintln(stderr, err)
+		}
+		db, err := records.OpenStore(source.dbPath)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		sourceSongs, err := db.FetchSongs()
+		var hashes map[string]records.CachedHash
+		if err == nil {
+			hashes, err = db.GetHashes()
+		}
+		_ = db.Close()
+		if err != nil {
+			diePrintf(stderr, "Error reading database %q:  %s\n", source.dbPath, err)
+		}
+
+		rewritten := 0
+		for _, song := range sourceSongs {
+			var ok bool
+			song.Path, ok = source.rewrite(song.Path)
+			if ok {
+				rewritten++
+			}
+			songs = append(songs, song)
+		}
+		for path, cached := range hashes {
+			path, _ = source.rewrite(path)
+			if existing, ok := caches[path]; ok {
+				droppedCaches++
+				if existing.FileHash != "" || cached.FileHash == "" {
+					continue
+				}
+			}
+			caches[path] = cached
+		}
+
+		_, _ = fmt.Fprintf(stdout, "%s:  %d songs (%d paths rewritten), %d cached hashes\n", source.dbPath,
+			len(sourceSongs), rewritten, len(hashes))
+	}
+
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	result, err := db.Import(songs, caches, args.onConflict)
+	if err != nil {
+		diePrintf(stderr, "merge failed, so nothing was merged:  %s\n", err)
+	}
+	err = db.RebuildAlbums()
+	if err != nil {
+		diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "%d songs added, %d updated, %d skipped, %d unchanged\n", result.Added, result.Updated,
+		result.Skipped, result.Unchanged)
+	_, _ = fmt.Fprintf(stdout, "%d cached hashes merged (%d duplicates dropped)\n", len(caches), droppedCaches)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// missingTracks reports every recorded album with gaps in its track numbers, missing discs or doubled-up tracks.
+func missingTracks(stdout io.Writer, stderr io.Writer, args missingTracksArgs) {
+	var reports []library.CompletenessReport
+	for _, album := range library.MergeDiscFolders(fetchAlbumsOrDie(stderr, args.dbPath)) {
+		if report, incomplete := library.CheckCompleteness(album); incomplete {
+			reports = append(reports, report)
+		}
+	}
+
+	if args.format == "json" {
+		if reports == nil {
+			reports = []library.CompletenessReport{}
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(reports)
+		if err != nil {
+			diePrintf(stderr, "failed to write results:  %s\n", err)
+		}
+		return
+	}
+
+	for _, r := range reports {
+		_, _ = fmt.Fprintf(stdout, "%s  %s\n", describeAlbum(library.Album{AlbumArtist: r.AlbumArtist, Album: r.Album}),
+			r.Folder)
+		if len(r.MissingDiscs) > 0 {
+			_, _ = fmt.Fprintf(stdout, "  missing disc %s of %d\n", joinInts(r.MissingDiscs), r.DiscCount)
+		}
+		for _, d := range r.Discs {
+			if len(d.MissingTracks) > 0 {
+				_, _ = fmt.Fprintf(stdout, "  disc %d:  missing track %s (%d files, %d tracks)\n", d.Disc,
+					joinInts(d.MissingTracks), d.Files, d.TotalTracks)
+			} else if d.Short > 0 {
+				_, _ = fmt.Fprintf(stdout, "  disc %d:  %d files, %d tracks\n", d.Disc, d.Files, d.TotalTracks)
+			}
+			if len(d.DuplicateTracks) > 0 {
+				_, _ = fmt.Fprintf(stdout, "  disc %d:  more than one track %s\n", d.Disc, joinInts(d.DuplicateTracks))
+			}
+			if len(d.SuspectTracks) > 0 {
+				_, _ = fmt.Fprintf(stdout, "  disc %d:  suspect track number %s\n", d.Disc, joinInts(d.SuspectTracks))
+			}
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "(%d incomplete albums)\n", len(reports))
+}
+
+func joinInts(ns []int) string {
+	var s []string
+	for _, n := range ns {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ", ")
+}
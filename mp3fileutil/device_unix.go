@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package mp3fileutil
+
+import (
+	"os"
+	"syscall"
+)
+
+// DeviceID identifies the filesystem a file lives on, since hard links can't cross filesystems.
+func DeviceID(info os.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
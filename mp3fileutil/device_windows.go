@@ -0,0 +1,9 @@
+package mp3fileutil
+
+import "os"
+
+// DeviceID isn't available from os.FileInfo on Windows; callers fall back to treating every file as linkable and
+// letting os.Link report cross-volume links.
+func DeviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
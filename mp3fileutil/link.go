@@ -0,0 +1,133 @@
+package mp3fileutil
+
+import (
+	"encoding/hex"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FindIdenticalFiles groups paths whose contents are byte-for-byte identical, keyed by the SHA-256 of the whole file.
+// Unlike mp3util.Hash, tags count, so a retagged copy won't match.  Files that can't be read are skipped.
+func FindIdenticalFiles(paths []string) map[string][]string {
+	bySize := make(map[int64][]string)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], path)
+	}
+
+	result := make(map[string][]string)
+	for _, sameSize := range bySize {
+		if len(sameSize) < 2 {
+			continue
+		}
+		byHash := make(map[string][]string)
+		for _, path := range sameSize {
+			hash, err := hashFile(path)
+			if err != nil {
+				continue
+			}
+			byHash[hash] = append(byHash[hash], path)
+		}
+		for hash, group := range byHash {
+			if len(group) > 1 {
+				sort.Strings(group)
+				result[hash] = group
+			}
+		}
+	}
+
+	return result
+}
+
+// SameContents says whether two files are byte-for-byte identical.
+func SameContents(a string, b string) (bool, error) {
+	aHash, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	bHash, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return aHash == bHash, nil
+}
+
+func hashFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	hash := mp3util.FileHash(b)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// HardLink replaces dst with a hard link to src.  The link is made under a temporary name first, so dst is never
+// missing if linking fails.
+func HardLink(src string, dst string) error {
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+	err = os.Link(src, tmp)
+	if err != nil {
+		return err
+	}
+	return renameOver(tmp, dst)
+}
+
+// Reflink replaces dst with a copy-on-write clone of src, on filesystems that support it (see reflink_linux.go).
+func Reflink(src string, dst string) error {
+	info, err := os.Stat(dst)
+	if err != nil {
+		return err
+	}
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+	err = cloneFile(src, tmp, info.Mode())
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return renameOver(tmp, dst)
+}
+
+// ReplaceWithCopy replaces dst with an independent copy of src, which undoes HardLink or Reflink.
+func ReplaceWithCopy(src string, dst string) error {
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+	err = CopyFile(src, tmp)
+	if err != nil {
+		return err
+	}
+	return renameOver(tmp, dst)
+}
+
+// tempSibling picks an unused name next to path for a file that will be renamed over it.  The name is only reserved
+// briefly, so the file has to be created exclusively (as os.Link and CopyFile do), failing rather than clobbering
+// anything that took the name in the meantime.
+func tempSibling(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".smartmp3mgr-*-"+filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	_ = f.Close()
+	return f.Name(), os.Remove(f.Name())
+}
+
+func renameOver(tmp string, dst string) error {
+	err := os.Rename(tmp, dst)
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
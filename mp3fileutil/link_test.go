@@ -0,0 +1,73 @@
+package mp3fileutil
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindIdenticalFilesAndLink(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	copies := map[string]string{
+		"a.mp3": "spring-chicken.mp3",
+		"b.mp3": "spring-chicken.mp3",
+		"c.mp3": "wakka-wakka-default.mp3",
+		"d.mp3": "wakka-wakka-altered-tags.mp3",
+	}
+	var paths []string
+	for to, from := range copies {
+		err = CopyFile(testHelpers.GetFixturePath(from), filepath.Join(dir, to))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Join(dir, to))
+	}
+
+	groups := FindIdenticalFiles(paths)
+	if len(groups) != 1 {
+		t.Fatalf("Expected one group of identical files, found %+v", groups)
+	}
+	for hash, group := range groups {
+		expected := []string{filepath.Join(dir, "a.mp3"), filepath.Join(dir, "b.mp3")}
+		if !reflect.DeepEqual(group, expected) {
+			t.Errorf("Elements did not match.  \r\nExpected:  %v  \r\nFound:  %v", expected, group)
+		}
+		if fileHash, _ := hashFile(group[1]); fileHash != hash {
+			t.Errorf("Group hash %s doesn't match file hash %s", hash, fileHash)
+		}
+	}
+	if same, err := SameContents(filepath.Join(dir, "a.mp3"), filepath.Join(dir, "c.mp3")); err != nil || same {
+		t.Errorf("Expected different files to differ (%v)", err)
+	}
+
+	a, b := filepath.Join(dir, "a.mp3"), filepath.Join(dir, "b.mp3")
+	err = HardLink(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aInfo, _ := os.Stat(a)
+	bInfo, _ := os.Stat(b)
+	if !os.SameFile(aInfo, bInfo) {
+		t.Error("Expected a hard link")
+	}
+
+	err = ReplaceWithCopy(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bInfo, _ = os.Stat(b)
+	if os.SameFile(aInfo, bInfo) {
+		t.Error("Expected an independent copy")
+	}
+	if len(FindIdenticalFiles([]string{a, b})) != 1 {
+		t.Error("Copy isn't identical")
+	}
+}
@@ -0,0 +1,60 @@
+package mp3fileutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// MoveFile renames a file, creating the destination's parent directories and falling back to copy-and-delete when
+// the two paths are on different filesystems.  It refuses to overwrite an existing file.
+func MoveFile(from string, to string) error {
+	if _, err := os.Lstat(to); err == nil {
+		return fmt.Errorf("%q already exists", to)
+	}
+	err := os.MkdirAll(filepath.Dir(to), 0755)
+	if err != nil {
+		return err
+	}
+
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+
+	err = CopyFile(from, to)
+	if err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// CopyFile copies a file's contents and permissions to a new path, which must not already exist.
+func CopyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(to)
+		return fmt.Errorf("error copying %q to %q:  %s", from, to, err)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package mp3fileutil
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+func cloneFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+	closeErr := out.Close()
+	if err != nil {
+		return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: err}
+	}
+	return closeErr
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package mp3fileutil
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneFile(src string, dst string, mode os.FileMode) error {
+	return &os.LinkError{Op: "reflink", Old: src, New: dst, Err: errors.New("reflinks are only supported on Linux")}
+}
@@ -0,0 +1,21 @@
+package mp3fileutil
+
+// Watcher reports paths under a set of directories that have changed.  A reported path may be a file or a directory
+// that was created, written, moved in or out, or deleted; it's up to the receiver to look at what's there now.
+// Directories created under a watched directory are watched too.
+type Watcher struct {
+	// Changes receives each changed path; it's closed once the watcher is closed.
+	Changes <-chan string
+	// Errors receives problems that don't stop the watcher, such as a directory that couldn't be watched.
+	Errors <-chan error
+	// Overflows receives a value when changes were missed because too many happened at once, after which the
+	// receiver has to rescan the directories.  Overflows that haven't been received yet are merged into one.
+	Overflows <-chan struct{}
+
+	impl *watcherImpl
+}
+
+// Close stops watching and closes Changes, Errors and Overflows.
+func (w *Watcher) Close() error {
+	return w.impl.close()
+}
@@ -0,0 +1,141 @@
+package mp3fileutil
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE |
+	unix.IN_DELETE_SELF
+
+type watcherImpl struct {
+	file      *os.File
+	changes   chan string
+	errors    chan error
+	overflows chan struct{}
+
+	roots []string
+	mu    sync.Mutex
+	dirs  map[int]string
+}
+
+// NewWatcher starts watching every directory under the given roots with inotify.
+func NewWatcher(roots []string) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start inotify:  %s", err)
+	}
+
+	impl := &watcherImpl{
+		// a non-blocking descriptor goes through the runtime poller, so closing the file interrupts a pending read
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		changes:   make(chan string, 256),
+		errors:    make(chan error, 16),
+		overflows: make(chan struct{}, 1),
+		roots:     roots,
+		dirs:      make(map[int]string),
+	}
+	for _, root := range roots {
+		err = impl.addTree(root)
+		if err != nil {
+			_ = impl.file.Close()
+			return nil, err
+		}
+	}
+
+	go impl.read()
+
+	return &Watcher{Changes: impl.changes, Errors: impl.errors, Overflows: impl.overflows, impl: impl}, nil
+}
+
+// addTree watches dir and every directory under it.
+func (w *watcherImpl) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		wd, err := unix.InotifyAddWatch(int(w.file.Fd()), path, watchMask)
+		if err != nil {
+			return fmt.Errorf("failed to watch %q:  %s", path, err)
+		}
+		w.mu.Lock()
+		w.dirs[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+func (w *watcherImpl) read() {
+	defer close(w.changes)
+	defer close(w.errors)
+	defer close(w.overflows)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			// closed
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			w.handle(int(event.Wd), event.Mask, string(bytes.TrimRight(nameBytes, "\x00")))
+		}
+	}
+}
+
+func (w *watcherImpl) handle(wd int, mask uint32, name string) {
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+	}
+	w.mu.Unlock()
+
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		// directories created while events were being dropped aren't watched yet
+		for _, root := range w.roots {
+			err := w.addTree(root)
+			if err != nil {
+				w.sendError(err)
+			}
+		}
+		select {
+		case w.overflows <- struct{}{}:
+		default:
+		}
+		return
+	}
+	if !ok || name == "" {
+		// events about the watched directory itself are also reported against its parent
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if mask&unix.IN_ISDIR != 0 && mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		err := w.addTree(path)
+		if err != nil {
+			w.sendError(err)
+		}
+	}
+	w.changes <- path
+}
+
+func (w *watcherImpl) sendError(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
+
+func (w *watcherImpl) close() error {
+	return w.file.Close()
+}
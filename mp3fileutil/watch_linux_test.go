@@ -0,0 +1,111 @@
+package mp3fileutil
+
+import (
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWatcher([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(expected string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case path := <-w.Changes:
+				if path == expected {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("No change reported for %q", expected)
+			}
+		}
+	}
+
+	sub := filepath.Join(dir, "new")
+	_ = os.Mkdir(sub, 0755)
+	waitFor(sub)
+
+	// the new directory is watched as well
+	file := filepath.Join(sub, "1.mp3")
+	_ = ioutil.WriteFile(file, []byte("x"), 0644)
+	waitFor(file)
+
+	_ = os.Remove(file)
+	waitFor(file)
+
+	_ = w.Close()
+	for range w.Changes {
+	}
+}
+
+func TestWatcherOverflow(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWatcher([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	waitFor := func(expected string) {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case path := <-w.Changes:
+				if path == expected {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("No change reported for %q", expected)
+			}
+		}
+	}
+
+	// a directory whose creation was among the dropped events isn't watched until the overflow
+	sub := filepath.Join(dir, "missed")
+	_ = os.Mkdir(sub, 0755)
+	waitFor(sub)
+	w.impl.mu.Lock()
+	for wd, path := range w.impl.dirs {
+		if path == sub {
+			_, _ = unix.InotifyRmWatch(int(w.impl.file.Fd()), uint32(wd))
+		}
+	}
+	w.impl.mu.Unlock()
+
+	// the kernel reports an overflow with no watch descriptor; repeats before it's received are merged
+	w.impl.handle(-1, unix.IN_Q_OVERFLOW, "")
+	w.impl.handle(-1, unix.IN_Q_OVERFLOW, "")
+	select {
+	case <-w.Overflows:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No overflow reported")
+	}
+	select {
+	case <-w.Overflows:
+		t.Error("Expected overflows to be merged")
+	default:
+	}
+
+	file := filepath.Join(sub, "1.mp3")
+	_ = ioutil.WriteFile(file, []byte("x"), 0644)
+	waitFor(file)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package mp3fileutil
+
+import "errors"
+
+type watcherImpl struct{}
+
+func NewWatcher(roots []string) (*Watcher, error) {
+	return nil, errors.New("watching is only supported on Linux")
+}
+
+func (w *watcherImpl) close() error {
+	return nil
+}
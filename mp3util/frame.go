@@ -0,0 +1,129 @@
+package mp3util
+
+import "encoding/binary"
+
+// bitrates in kbps by [MPEG-1?][layer][index], with layers counted from 1
+var bitrates = [2][4][16]int{
+	{ // MPEG-2 and 2.5
+		{},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+	{ // MPEG-1
+		{},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+}
+
+// sample rates in Hz by MPEG version bits (00 = 2.5, 10 = 2, 11 = 1) and index
+var sampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// frameHeader is the part of an MPEG audio frame header needed for AudioInfo.
+type frameHeader struct {
+	mpeg1           bool
+	layer           int
+	bitrate         int
+	sampleRate      int
+	samplesPerFrame int
+	mono            bool
+}
+
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return frameHeader{}, false
+	}
+	version := int(b[1]>>3) & 3
+	layer := 4 - int(b[1]>>1)&3
+	bitrateIndex := int(b[2] >> 4)
+	rateIndex := int(b[2]>>2) & 3
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frameHeader{}, false
+	}
+
+	h := frameHeader{mpeg1: version == 3, layer: layer, sampleRate: sampleRates[version][rateIndex],
+		mono: b[3]>>6 == 3}
+	mpeg1 := 0
+	if h.mpeg1 {
+		mpeg1 = 1
+	}
+	h.bitrate = bitrates[mpeg1][layer][bitrateIndex]
+	switch {
+	case layer == 1:
+		h.samplesPerFrame = 384
+	case layer == 3 && !h.mpeg1:
+		h.samplesPerFrame = 576
+	default:
+		h.samplesPerFrame = 1152
+	}
+	return h, true
+}
+
+// AudioInfo reads the bitrate (in kbps), sample rate (in Hz) and duration (in seconds) from the first MPEG frame after
+// the ID3v2 tag.  VBR files with a Xing or Info header get their average bitrate; otherwise the first frame's bitrate
+// is assumed throughout.  All are 0 if no frame can be found.
+func AudioInfo(mp3Bytes []byte) (bitrate int, sampleRate int, duration int) {
+	start := id3v2Length(mp3Bytes)
+	end := len(mp3Bytes)
+	if end >= 128 && string(mp3Bytes[end-128:end-125]) == "TAG" {
+		end -= 128
+	}
+	if start >= end {
+		return 0, 0, 0
+	}
+
+	// skip any padding or junk between the tag and the first frame, within reason
+	limit := start + 64*1024
+	if limit > end-4 {
+		limit = end - 4
+	}
+	for i := start; i < limit; i++ {
+		h, ok := parseFrameHeader(mp3Bytes[i : i+4])
+		if !ok {
+			continue
+		}
+		audioBytes := end - i
+		if frames, ok := xingFrames(mp3Bytes[i:end], h); ok && h.sampleRate > 0 {
+			seconds := float64(frames) * float64(h.samplesPerFrame) / float64(h.sampleRate)
+			if seconds == 0 {
+				return h.bitrate, h.sampleRate, 0
+			}
+			return int(float64(audioBytes)*8/seconds/1000 + 0.5), h.sampleRate, int(seconds + 0.5)
+		}
+		return h.bitrate, h.sampleRate, int(float64(audioBytes)*8/float64(h.bitrate*1000) + 0.5)
+	}
+	return 0, 0, 0
+}
+
+// xingFrames reads the frame count from a Xing or Info header, which sits after the side information in the first
+// frame of files written by most VBR encoders.
+func xingFrames(frame []byte, h frameHeader) (int, bool) {
+	offset := 4
+	switch {
+	case h.mpeg1 && !h.mono:
+		offset += 32
+	case h.mpeg1 || !h.mono:
+		offset += 17
+	default:
+		offset += 9
+	}
+	if len(frame) < offset+12 {
+		return 0, false
+	}
+	id := string(frame[offset : offset+4])
+	if id != "Xing" && id != "Info" {
+		return 0, false
+	}
+	flags := binary.BigEndian.Uint32(frame[offset+4 : offset+8])
+	if flags&1 == 0 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(frame[offset+8 : offset+12])), true
+}
@@ -0,0 +1,32 @@
+package mp3util
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestAudioInfoCBR(t *testing.T) {
+	// MPEG-1 layer III, 128kbps, 44.1kHz; one second of audio is 16000 bytes
+	b := make([]byte, 16000)
+	copy(b, []byte{0xFF, 0xFB, 0x90, 0x40})
+	bitrate, sampleRate, duration := AudioInfo(append(id3v2Header(3, nil), b...))
+	if bitrate != 128 || sampleRate != 44100 || duration != 1 {
+		t.Errorf("Expected 128kbps at 44100Hz for 1s, got %dkbps at %dHz for %ds", bitrate, sampleRate, duration)
+	}
+}
+
+func TestAudioInfoXing(t *testing.T) {
+	b := make([]byte, 40000)
+	copy(b, []byte{0xFF, 0xFB, 0x90, 0x40})
+	copy(b[36:], "Xing")
+	binary.BigEndian.PutUint32(b[40:], 1)
+	// 383 frames of 1152 samples at 44.1kHz is 10s
+	binary.BigEndian.PutUint32(b[44:], 383)
+	bitrate, _, duration := AudioInfo(b)
+	if bitrate != 32 || duration != 10 {
+		t.Errorf("Expected 32kbps for 10s, got %dkbps for %ds", bitrate, duration)
+	}
+	if bitrate, _, duration = AudioInfo([]byte("not an mp3")); bitrate != 0 || duration != 0 {
+		t.Errorf("Expected nothing from garbage, got %dkbps for %ds", bitrate, duration)
+	}
+}
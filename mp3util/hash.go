@@ -10,19 +10,7 @@ func Hash(bytes []byte) ([32]byte, error) {
 	if len(bytes) < 128 {
 		return [32]byte{}, errors.New("file too short")
 	}
-	leftBound := 0
-
-	if string(bytes[:3]) == "ID3" {
-		if bytes[5]&0x10 > 0 {
-			leftBound = 20
-		} else {
-			leftBound = 10
-		}
-		leftBound += int(bytes[9])
-		leftBound += int(bytes[8]) * 128
-		leftBound += int(bytes[7]) * 128 * 128
-		leftBound += int(bytes[6]) * 128 * 128 * 128
-	}
+	leftBound := id3v2Length(bytes)
 
 	rightBound := len(bytes) - 1
 
@@ -40,3 +28,8 @@ func Hash(bytes []byte) ([32]byte, error) {
 
 	return hash, nil
 }
+
+// FileHash hashes the whole file, tags included, so unlike Hash it only matches exact copies.
+func FileHash(bytes []byte) [32]byte {
+	return sha256.Sum256(bytes)
+}
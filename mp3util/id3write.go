@@ -0,0 +1,264 @@
+package mp3util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dhowden/tag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Tag field names accepted by RetagMP3, mapped to their ID3v2.3/2.4 frame IDs.
+var textFrames = map[string]string{
+	"Title":       "TIT2",
+	"Artist":      "TPE1",
+	"Album":       "TALB",
+	"AlbumArtist": "TPE2",
+	"Genre":       "TCON",
+	"Track":       "TRCK",
+	"Disc":        "TPOS",
+}
+
+// ID3v2Tag returns the raw ID3v2 tag (header, frames, padding and footer) at the start of the file, or nil if the
+// file doesn't begin with one.
+func ID3v2Tag(mp3Bytes []byte) []byte {
+	length := id3v2Length(mp3Bytes)
+	if length == 0 || length > len(mp3Bytes) {
+		return nil
+	}
+	return mp3Bytes[:length]
+}
+
+func id3v2Length(bytes []byte) int {
+	if len(bytes) < 10 || string(bytes[:3]) != "ID3" {
+		return 0
+	}
+	length := 10
+	if bytes[5]&0x10 > 0 {
+		length = 20
+	}
+	length += int(bytes[9])
+	length += int(bytes[8]) * 128
+	length += int(bytes[7]) * 128 * 128
+	length += int(bytes[6]) * 128 * 128 * 128
+
+	return length
+}
+
+// ReplaceID3v2Tag swaps whatever ID3v2 tag the file has for newTag, which may be empty to strip the tag entirely.
+// The audio payload and any ID3v1 tag are left untouched, so the result of Hash doesn't change.
+func ReplaceID3v2Tag(mp3Path string, newTag []byte) error {
+	mp3Bytes, err := ioutil.ReadFile(mp3Path)
+	if err != nil {
+		return fmt.Errorf("error reading %q:  %s", mp3Path, err)
+	}
+	rest := mp3Bytes[len(ID3v2Tag(mp3Bytes)):]
+
+	info, err := os.Stat(mp3Path)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(mp3Path), ".smartmp3mgr-retag*")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file for %q:  %s", mp3Path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(newTag)
+	if err == nil {
+		_, err = tmp.Write(rest)
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %q:  %s", tmp.Name(), err)
+	}
+	_ = os.Chmod(tmp.Name(), info.Mode())
+
+	return os.Rename(tmp.Name(), mp3Path)
+}
+
+// RetagMP3 rewrites the given fields (see textFrames for the accepted names) in the file's ID3v2 tag, keeping every
+// other frame as it was.  Files with no usable ID3v2.3/2.4 tag get a fresh ID3v2.3 tag seeded from whatever tags
+// could be read.  It returns the original tag bytes so that the change can be reverted with ReplaceID3v2Tag.
+func RetagMP3(mp3Path string, fields map[string]string) ([]byte, error) {
+	for field := range fields {
+		if _, ok := textFrames[field]; !ok {
+			return nil, fmt.Errorf("unknown tag field %q", field)
+		}
+	}
+
+	mp3Bytes, err := ioutil.ReadFile(mp3Path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %q:  %s", mp3Path, err)
+	}
+	original := append([]byte(nil), ID3v2Tag(mp3Bytes)...)
+
+	newTag, err := rewriteID3v2Tag(original, fields)
+	if err != nil {
+		newTag, err = freshID3v2Tag(mp3Path, fields)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return original, ReplaceID3v2Tag(mp3Path, newTag)
+}
+
+func rewriteID3v2Tag(original []byte, fields map[string]string) ([]byte, error) {
+	if len(original) < 10 {
+		return nil, errors.New("no ID3v2 tag")
+	}
+	version := original[3]
+	if version != 3 && version != 4 {
+		return nil, fmt.Errorf("unsupported ID3v2 version 2.%d", version)
+	}
+	if original[5]&0xC0 > 0 {
+		return nil, errors.New("unsynchronised or extended ID3v2 headers aren't supported")
+	}
+
+	replaced := make(map[string]bool)
+	for field := range fields {
+		replaced[textFrames[field]] = true
+	}
+
+	var frames bytes.Buffer
+	body := original[10:]
+	if original[5]&0x10 > 0 {
+		body = body[:len(body)-10]
+	}
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		var size int
+		if version == 4 {
+			size = synchsafe(body[4:8])
+		} else {
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if 10+size > len(body) {
+			return nil, fmt.Errorf("frame %q overruns the tag", id)
+		}
+		if !replaced[id] {
+			frames.Write(body[:10+size])
+		}
+		body = body[10+size:]
+	}
+
+	for _, field := range sortedFields(fields) {
+		if fields[field] != "" {
+			frames.Write(textFrame(textFrames[field], fields[field], version))
+		}
+	}
+
+	return id3v2Header(version, frames.Bytes()), nil
+}
+
+func freshID3v2Tag(mp3Path string, fields map[string]string) ([]byte, error) {
+	merged := make(map[string]string)
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if tags, err := tag.ReadFrom(file); err == nil {
+		track, tracks := tags.Track()
+		disc, discs := tags.Disc()
+		merged["Title"] = tags.Title()
+		merged["Artist"] = tags.Artist()
+		merged["Album"] = tags.Album()
+		merged["AlbumArtist"] = tags.AlbumArtist()
+		merged["Genre"] = tags.Genre()
+		merged["Track"] = numberOfTotal(track, tracks)
+		merged["Disc"] = numberOfTotal(disc, discs)
+	}
+	for field, value := range fields {
+		merged[field] = value
+	}
+
+	var frames bytes.Buffer
+	for _, field := range sortedFields(merged) {
+		if merged[field] != "" {
+			frames.Write(textFrame(textFrames[field], merged[field], 3))
+		}
+	}
+
+	return id3v2Header(3, frames.Bytes()), nil
+}
+
+func numberOfTotal(n, total int) string {
+	switch {
+	case n == 0:
+		return ""
+	case total == 0:
+		return strconv.Itoa(n)
+	default:
+		return fmt.Sprintf("%d/%d", n, total)
+	}
+}
+
+func sortedFields(fields map[string]string) []string {
+	var result []string
+	for _, field := range []string{"Title", "Artist", "Album", "AlbumArtist", "Genre", "Track", "Disc"} {
+		if _, ok := fields[field]; ok {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+func textFrame(id string, value string, version byte) []byte {
+	var payload []byte
+	switch {
+	case isASCII(value):
+		payload = append([]byte{0}, value...)
+	case version == 4:
+		payload = append([]byte{3}, value...)
+	default:
+		payload = []byte{1, 0xFF, 0xFE}
+		for _, unit := range utf16.Encode([]rune(value)) {
+			payload = append(payload, byte(unit), byte(unit>>8))
+		}
+	}
+
+	frame := make([]byte, 10, 10+len(payload))
+	copy(frame, id)
+	if version == 4 {
+		putSynchsafe(frame[4:8], len(payload))
+	} else {
+		binary.BigEndian.PutUint32(frame[4:8], uint32(len(payload)))
+	}
+	return append(frame, payload...)
+}
+
+func id3v2Header(version byte, frames []byte) []byte {
+	header := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+	putSynchsafe(header[6:10], len(frames))
+	return append(header, frames...)
+}
+
+func isASCII(s string) bool {
+	for _, r := range s {
+		if r > 0x7F {
+			return false
+		}
+	}
+	return true
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+func putSynchsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7F
+	b[1] = byte(n>>14) & 0x7F
+	b[2] = byte(n>>7) & 0x7F
+	b[3] = byte(n) & 0x7F
+}
@@ -0,0 +1,53 @@
+package mp3util
+
+import (
+	"bytes"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetagMP3(t *testing.T) {
+	for _, fixture := range []string{"wakka-wakka-altered-tags.mp3", "wakka-wakka-no-tags.mp3"} {
+		original, err := ioutil.ReadFile(testHelpers.GetFixturePath(fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, fixture)
+		_ = ioutil.WriteFile(path, original, 0644)
+
+		backup, err := RetagMP3(path, map[string]string{"Title": "Wakka Wakka", "Artist": "浜崎あゆみ", "Track": "3/9"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		song, err := ParseMP3(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if song.Title != "Wakka Wakka" || song.Artist != "浜崎あゆみ" || song.TrackNumber != 3 || song.TotalTracks != 9 {
+			t.Errorf("%s:  tags weren't rewritten:  %+v", fixture, song)
+		}
+		if song.Hash != "883a8beab2a44c5bfcd637855eec3e4b1c89232cb1e1bb17d8cccf9e82c87ecf" {
+			t.Errorf("%s:  retagging changed the hash to %s", fixture, song.Hash)
+		}
+		if fixture == "wakka-wakka-altered-tags.mp3" && song.Album != "Thanks FreePD Music!" {
+			t.Errorf("%s:  album wasn't kept:  %q", fixture, song.Album)
+		}
+
+		err = ReplaceID3v2Tag(path, backup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored, _ := ioutil.ReadFile(path)
+		if !bytes.Equal(original, restored) {
+			t.Errorf("%s:  restoring the original tag didn't restore the original file", fixture)
+		}
+	}
+}
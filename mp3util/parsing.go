@@ -9,21 +9,15 @@ import (
 )
 
 func ParseMP3(mp3Path string) (Song, error) {
-	file, err := os.OpenFile(mp3Path, os.O_RDONLY, 0)
-	defer file.Close()
-	song := Song{Path: mp3Path}
+	song, err := ReadTags(mp3Path)
 	if err != nil {
-		return song, fmt.Errorf("error opening %q:  %s", mp3Path, err)
-	}
-	tags, err := tag.ReadFrom(file)
-	if err == nil {
-		trackNumber, tracks := tags.Track()
-		discNumber, discs := tags.Disc()
-		song = Song{Path: mp3Path, Artist: tags.Artist(), Album: tags.Album(), Genre: tags.Genre(),
-			Title: tags.Title(), TrackNumber: trackNumber, TotalTracks: tracks, DiscNumber: discNumber, TotalDiscs: discs,
-			AlbumArtist: tags.AlbumArtist()}
+		return song, err
 	}
 
+	info, err := os.Stat(mp3Path)
+	if err != nil {
+		return song, fmt.Errorf("error reading %q:  %s", mp3Path, err)
+	}
 	mp3Bytes, err := ioutil.ReadFile(mp3Path)
 
 	if err != nil {
@@ -37,6 +31,53 @@ func ParseMP3(mp3Path string) (Song, error) {
 	str := hex.EncodeToString(hash[:])
 
 	song.Hash = str
+	fileHash := FileHash(mp3Bytes)
+	song.FileHash = hex.EncodeToString(fileHash[:])
+	song.Bitrate, song.SampleRate, song.Duration = AudioInfo(mp3Bytes)
+	song.Size = int64(len(mp3Bytes))
+	song.ModTime = info.ModTime().UnixNano()
 
 	return song, nil
 }
+
+// ReadTags is ParseMP3 without the hashing, for when the hashes are already known.  A file without readable tags
+// isn't an error; it just comes back with only its Path set.
+func ReadTags(mp3Path string) (Song, error) {
+	file, err := os.OpenFile(mp3Path, os.O_RDONLY, 0)
+	song := Song{Path: mp3Path}
+	if err != nil {
+		return song, fmt.Errorf("error opening %q:  %s", mp3Path, err)
+	}
+	defer file.Close()
+	tags, err := tag.ReadFrom(file)
+	if err == nil {
+		trackNumber, tracks := tags.Track()
+		discNumber, discs := tags.Disc()
+		song = Song{Path: mp3Path, Artist: tags.Artist(), Album: tags.Album(), Genre: tags.Genre(),
+			Title: tags.Title(), TrackNumber: trackNumber, TotalTracks: tracks, DiscNumber: discNumber, TotalDiscs: discs,
+			AlbumArtist: tags.AlbumArtist(), TagFormat: string(tags.Format())}
+		for _, frame := range []string{"TCON", "TCO"} {
+			if raw, ok := tags.Raw()[frame].(string); ok && raw != song.Genre {
+				song.RawGenre = raw
+			}
+		}
+	}
+
+	return song, nil
+}
+
+// ReadPicture returns the picture embedded in the file's tags (usually the front cover) and its MIME type, or nil if
+// there isn't one.
+func ReadPicture(mp3Path string) ([]byte, string, error) {
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening %q:  %s", mp3Path, err)
+	}
+	defer file.Close()
+
+	tags, err := tag.ReadFrom(file)
+	if err != nil || tags.Picture() == nil {
+		return nil, "", nil
+	}
+	return tags.Picture().Data, tags.Picture().MIMEType, nil
+}
@@ -2,6 +2,7 @@ package mp3util
 
 import (
 	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"os"
 	"testing"
 )
 
@@ -13,7 +14,12 @@ func TestParseMP3(t *testing.T) {
 	}
 	expected := Song{Path: path, Artist: "Thanks Bryan Teoh!", Album: "Thanks FreePD Music!",
 		Title: "Wakka Wakka wakkaa", Hash: "883a8beab2a44c5bfcd637855eec3e4b1c89232cb1e1bb17d8cccf9e82c87ecf",
-		TrackNumber: 1, DiscNumber: 1}
+		TrackNumber: 1, DiscNumber: 1, FileHash: "02549c9e61a564cccb8ce8fbacb92d97b9b8d9f94d21b3af8740e513b9d509d0",
+		TagFormat: "ID3v2.3", Bitrate: 160, SampleRate: 48000, Duration: 122,
+		Size: 2434429}
+	if info, err := os.Stat(path); err == nil {
+		expected.ModTime = info.ModTime().UnixNano()
+	}
 	if result != expected {
 		t.Errorf("Elements did not match.  \r\nExpected:  %v  \r\nFound:  %v", expected, result)
 	}
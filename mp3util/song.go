@@ -3,4 +3,37 @@ package mp3util
 type Song struct {
 	Path, Artist, Album, Title, Hash, Genre, AlbumArtist string
 	TrackNumber, TotalTracks, DiscNumber, TotalDiscs     int
+	FileHash                                             string
+	// TagFormat is the kind of tag the fields were read from (e.g. "ID3v2.3", "ID3v1"), or empty for untagged files.
+	TagFormat string
+	// Bitrate (kbps), SampleRate (Hz) and Duration (seconds) come from the MPEG frames; see AudioInfo.
+	Bitrate, SampleRate, Duration int
+	// Size is the length of the file in bytes, and ModTime when it was last modified, in Unix nanoseconds.
+	Size, ModTime int64
+	// RawGenre is the ID3v2 genre frame as written, when that isn't Genre, which has ID3v1 genre numbers like (17)
+	// turned into the names they stand for.
+	RawGenre string
+	// Collection is the named collection (e.g. "canonical" or "dj") the song was recorded into, or empty for none.
+	Collection string
+}
+
+const (
+	IdenticalFile = "identical file"
+	RetaggedCopy  = "same audio, different tags"
+	// SameAudio is used when one side has no FileHash (e.g., it was recorded before file hashes were), so it isn't
+	// known whether the tags differ.
+	SameAudio = "same audio"
+)
+
+// MatchKind describes how a song relates to another with the same Hash:  an exact copy of the file, which can be
+// deleted blindly, or the same audio with different tags.
+func (s Song) MatchKind(other Song) string {
+	switch {
+	case s.FileHash == "" || other.FileHash == "":
+		return SameAudio
+	case s.FileHash == other.FileHash:
+		return IdenticalFile
+	default:
+		return RetaggedCopy
+	}
 }
@@ -1,16 +1,57 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/catalogue"
+	"github.com/caseyjmorris/smartmp3mgr/lint"
+	"github.com/caseyjmorris/smartmp3mgr/manifest"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 var findNewCmd = flag.NewFlagSet("find-new", flag.ExitOnError)
 var recordCmd = flag.NewFlagSet("record", flag.ExitOnError)
+var applyCmd = flag.NewFlagSet("apply", flag.ExitOnError)
+var undoCmd = flag.NewFlagSet("undo", flag.ExitOnError)
+var linkDupesCmd = flag.NewFlagSet("link-dupes", flag.ExitOnError)
+var dupesCmd = flag.NewFlagSet("dupes", flag.ExitOnError)
+var albumDupesCmd = flag.NewFlagSet("album-dupes", flag.ExitOnError)
+var albumCompletesCmd = flag.NewFlagSet("album-completes", flag.ExitOnError)
+var missingTracksCmd = flag.NewFlagSet("missing-tracks", flag.ExitOnError)
+var lintCmd = flag.NewFlagSet("lint", flag.ExitOnError)
+var queryCmd = flag.NewFlagSet("query", flag.ExitOnError)
+var searchCmd = flag.NewFlagSet("search", flag.ExitOnError)
+var statsCmd = flag.NewFlagSet("stats", flag.ExitOnError)
+var reportCmd = flag.NewFlagSet("report", flag.ExitOnError)
+var watchCmd = flag.NewFlagSet("watch", flag.ExitOnError)
+var serveCmd = flag.NewFlagSet("serve", flag.ExitOnError)
+var exportCmd = flag.NewFlagSet("export", flag.ExitOnError)
+var importCmd = flag.NewFlagSet("import", flag.ExitOnError)
+var exportManifestCmd = flag.NewFlagSet("export-manifest", flag.ExitOnError)
+var compareCmd = flag.NewFlagSet("compare", flag.ExitOnError)
+var mergeCmd = flag.NewFlagSet("merge", flag.ExitOnError)
+var rootsCmd = flag.NewFlagSet("roots", flag.ExitOnError)
+var addRootCmd = flag.NewFlagSet("add-root", flag.ExitOnError)
+var relocateRootCmd = flag.NewFlagSet("relocate-root", flag.ExitOnError)
+var collectionsCmd = flag.NewFlagSet("collections", flag.ExitOnError)
+var renameCollectionCmd = flag.NewFlagSet("rename-collection", flag.ExitOnError)
+var dropCollectionCmd = flag.NewFlagSet("drop-collection", flag.ExitOnError)
 var homeDir, _ = os.UserHomeDir()
-var defaultDb = filepath.Join(homeDir, ".smartmp3mgr.sql")
+var defaultDb = filepath.Join(homeDir, defaultDbName)
+var defaultTrash = filepath.Join(homeDir, ".smartmp3mgr-trash")
+
+const storeUsage = "path to sqlite db, or to a .jsonl file store"
+const sqliteUsage = "path to sqlite db (not a .jsonl file store, which this command can't use)"
+
+// subsonicPasswordVar is the environment variable serve reads the Subsonic password from.
+const subsonicPasswordVar = "SMARTMP3MGR_SUBSONIC_PASSWORD"
 
 type findNewArgs struct {
 	directory           string
@@ -18,6 +59,16 @@ type findNewArgs struct {
 	rehash              bool
 	degreeOfParallelism int
 	foldersOnly         bool
+	quarantineDir       string
+	planPath            string
+	format              string
+	showDuplicates      bool
+	summary             string
+	sortBy              string
+	// manifests are hash manifests whose songs count as recorded too
+	manifests []string
+	// against are the collections whose songs count as recorded, or nil for all of them
+	against []string
 }
 
 type recordArgs struct {
@@ -25,28 +76,265 @@ type recordArgs struct {
 	directory           string
 	dbPath              string
 	reparse             bool
+	// collection is what to record the songs into; songs already recorded keep theirs if it's empty
+	collection string
+	// root names a root to add for directory if it isn't under one already
+	root string
+}
+
+type applyArgs struct {
+	planPath string
+	dbPath   string
+	trashDir string
+	dryRun   bool
+}
+
+type linkDupesArgs struct {
+	directories []string
+	dbPath      string
+	mode        string
+	planPath    string
+}
+
+type dupesArgs struct {
+	dbPath string
+}
+
+type albumDupesArgs struct {
+	dbPath     string
+	minOverlap float64
+}
+
+type albumCompletesArgs struct {
+	directory string
+	dbPath    string
+}
+
+type missingTracksArgs struct {
+	dbPath string
+	format string
+}
+
+type lintArgs struct {
+	dbPath string
+	format string
+	config lint.Config
+	list   bool
+}
+
+type queryArgs struct {
+	query  string
+	dbPath string
+	sortBy string
+	limit  int
+	format string
+}
+
+type searchArgs struct {
+	text   string
+	dbPath string
+	limit  int
+	format string
+}
+
+type statsArgs struct {
+	dbPath string
+	top    int
+	format string
+}
+
+type reportArgs struct {
+	dbPath  string
+	outPath string
+	top     int
+}
+
+type watchArgs struct {
+	directories []string
+	dbPath      string
+	debounce    time.Duration
+}
+
+type serveArgs struct {
+	addr             string
+	dbPath           string
+	directories      []string
+	maxUpload        int64
+	subsonicAddr     string
+	subsonicUser     string
+	subsonicPassword string
+}
+
+type exportArgs struct {
+	dbPath string
+	path   string
+	format string
+}
+
+type importArgs struct {
+	dbPath     string
+	path       string
+	format     string
+	onConflict records.ConflictPolicy
+}
+
+type exportManifestArgs struct {
+	dbPath            string
+	path              string
+	format            string
+	falsePositiveRate float64
+}
+
+type compareArgs struct {
+	a        string
+	b        string
+	manifest string
+	format   string
+	songs    bool
+}
+
+type mergeArgs struct {
+	dbPath     string
+	sources    mergeSources
+	onConflict records.ConflictPolicy
+}
+
+type rootsArgs struct {
+	dbPath string
+}
+
+// rootArgs are the arguments of add-root and relocate-root.
+type rootArgs struct {
+	dbPath string
+	name   string
+	path   string
+}
+
+// collectionArgs are the arguments of collections, rename-collection and drop-collection.
+type collectionArgs struct {
+	dbPath string
+	names  []string
+}
+
+type undoArgs struct {
+	runID  string
+	dbPath string
+	list   bool
+}
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func parseConflictPolicy(s string) (records.ConflictPolicy, error) {
+	for _, policy := range records.ConflictPolicies {
+		if string(policy) == s {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown conflict policy %q", s)
+}
+
+// flagGiven says whether a flag was set on the command line, rather than left at its default.
+func flagGiven(flags *flag.FlagSet, name string) bool {
+	given := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	return given
+}
+
+func containsString(s []string, value string) bool {
+	for _, el := range s {
+		if el == value {
+			return true
+		}
+	}
+	return false
 }
 
 func parseFindNewArgs() (result findNewArgs, err error) {
 	newCmdDir := findNewCmd.String("directory", "", "directory")
-	newCmdDb := findNewCmd.String("dbPath", defaultDb, "path to sqlite db")
+	newCmdDb := findNewCmd.String("dbPath", defaultDb, storeUsage)
 	rehash := findNewCmd.Bool("rehash", false, "force a recalculation of existing file hashes")
 	dop := findNewCmd.Int("dop", 20, "degree of parallelism")
 	foldersOnly := findNewCmd.Bool("fo", false, "show folders only")
+	quarantineDir := findNewCmd.String("quarantine", "", "move files that are already recorded into this directory")
+	planPath := findNewCmd.String("plan", "", "with -quarantine, save the moves as a plan instead of applying them")
+	format := findNewCmd.String("format", "text", "output format:  "+strings.Join(findNewFormats, ", "))
+	showDuplicates := findNewCmd.Bool("show-duplicates", false,
+		"with text output, also list every file that isn't new and the recorded songs it matched")
+	summary := findNewCmd.String("summary", "", "summarise by folder or album instead of listing files")
+	sortBy := findNewCmd.String("sort", "name", "with -summary, sort by name or new (most new tracks first)")
+	var manifests stringList
+	findNewCmd.Var(&manifests, "manifest",
+		"hash manifest (from export-manifest) whose songs also count as recorded; can be given more than once, and "+
+			"without -dbPath only the manifests are checked")
+	against := findNewCmd.String("against", "",
+		"comma-separated collections whose songs count as recorded (e.g. canonical,dj); all of them if not given")
 	err = findNewCmd.Parse(os.Args[2:])
+	if err == nil && len(manifests) > 0 && !flagGiven(findNewCmd, "dbPath") {
+		*newCmdDb = ""
+	}
+	if err == nil && *against != "" && *newCmdDb == "" {
+		err = errors.New("against needs a database; give -dbPath as well as -manifest")
+	}
+	if err == nil && *quarantineDir != "" && *newCmdDb == "" {
+		err = errors.New("quarantine needs a database; give -dbPath as well as -manifest")
+	}
+	if err == nil && *planPath != "" && *quarantineDir == "" {
+		err = errors.New("plan requires quarantine")
+	}
+	if err == nil && !containsString(findNewFormats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err == nil && *summary != "" && *summary != "folder" && *summary != "album" {
+		err = fmt.Errorf("unknown summary %q; use folder or album", *summary)
+	}
+	if err == nil && *summary != "" && !containsString([]string{"text", "json", "jsonl", "csv"}, *format) {
+		err = fmt.Errorf("format %q can't be used with -summary", *format)
+	}
+	if err == nil && *foldersOnly && containsString([]string{"json", "jsonl", "csv"}, *format) {
+		err = fmt.Errorf("-fo can't be used with format %q, which describes every file", *format)
+	}
+	if err == nil && *sortBy != "name" && *sortBy != "new" {
+		err = fmt.Errorf("unknown sort %q; use name or new", *sortBy)
+	}
 	if err != nil {
 		return
 	}
 
-	result = findNewArgs{*newCmdDir, *newCmdDb, *rehash, *dop, *foldersOnly}
+	var collections []string
+	if *against != "" {
+		for _, name := range strings.Split(*against, ",") {
+			collections = append(collections, strings.TrimSpace(name))
+		}
+	}
+
+	result = findNewArgs{*newCmdDir, *newCmdDb, *rehash, *dop, *foldersOnly, *quarantineDir, *planPath, *format,
+		*showDuplicates, *summary, *sortBy, manifests, collections}
 	return
 }
 
 func parseRecordArgs() (result recordArgs, err error) {
 	recordDir := recordCmd.String("directory", "", "directory")
-	recordDb := recordCmd.String("dbPath", defaultDb, "path to sqlite db")
+	recordDb := recordCmd.String("dbPath", defaultDb, storeUsage)
 	rehash := recordCmd.Bool("reparse", false, "force a rehash of reparse files")
 	dop := recordCmd.Int("dop", 20, "degree of parallelism")
+	collection := recordCmd.String("collection", "",
+		"collection to record the songs into (e.g. canonical or dj); songs already recorded are moved into it")
+	root := recordCmd.String("root", "",
+		"name of a root to add for the directory, unless it's already under one, so it can be relocated later")
 	err = recordCmd.Parse(os.Args[2:])
 	if err == nil && *dop < 1 {
 		err = errors.New("dop must be greater than zero")
@@ -60,6 +348,499 @@ func parseRecordArgs() (result recordArgs, err error) {
 		directory:           *recordDir,
 		dbPath:              *recordDb,
 		reparse:             *rehash,
+		collection:          *collection,
+		root:                *root,
+	}
+	return
+}
+
+func parseApplyArgs() (result applyArgs, err error) {
+	planPath := applyCmd.String("plan", "", "path to a plan file")
+	applyDb := applyCmd.String("dbPath", defaultDb, sqliteUsage)
+	trashDir := applyCmd.String("trash", defaultTrash, "directory deleted files are moved to")
+	dryRun := applyCmd.Bool("dry-run", false, "print the plan without applying it")
+	err = applyCmd.Parse(os.Args[2:])
+	if err == nil && *planPath == "" {
+		err = errors.New("plan is required")
+	}
+	if err != nil {
+		return
+	}
+
+	result = applyArgs{
+		planPath: *planPath,
+		dbPath:   *applyDb,
+		trashDir: *trashDir,
+		dryRun:   *dryRun,
+	}
+	return
+}
+
+func parseUndoArgs() (result undoArgs, err error) {
+	undoDb := undoCmd.String("dbPath", defaultDb, sqliteUsage)
+	list := undoCmd.Bool("list", false, "list runs instead of undoing one")
+	err = undoCmd.Parse(os.Args[2:])
+	if err == nil && !*list && undoCmd.NArg() != 1 {
+		err = errors.New("usage:  smartmp3mgr undo [-dbPath path] <run-id>")
+	}
+	if err != nil {
+		return
+	}
+
+	result = undoArgs{
+		runID:  undoCmd.Arg(0),
+		dbPath: *undoDb,
+		list:   *list,
+	}
+	return
+}
+
+func parseLinkDupesArgs() (result linkDupesArgs, err error) {
+	var directories stringList
+	linkDupesCmd.Var(&directories, "directory", "directory to look for identical files in (repeatable)")
+	linkDb := linkDupesCmd.String("dbPath", defaultDb, sqliteUsage)
+	mode := linkDupesCmd.String("mode", "hardlink", "hardlink or reflink")
+	planPath := linkDupesCmd.String("plan", "", "save the links as a plan instead of applying them")
+	err = linkDupesCmd.Parse(os.Args[2:])
+	if err == nil && len(directories) == 0 {
+		err = errors.New("at least one directory is required")
+	}
+	if err == nil && *mode != "hardlink" && *mode != "reflink" {
+		err = fmt.Errorf("unknown mode %q", *mode)
+	}
+	if err != nil {
+		return
+	}
+
+	result = linkDupesArgs{
+		directories: directories,
+		dbPath:      *linkDb,
+		mode:        *mode,
+		planPath:    *planPath,
+	}
+	return
+}
+
+func parseDupesArgs() (result dupesArgs, err error) {
+	dupesDb := dupesCmd.String("dbPath", defaultDb, storeUsage)
+	err = dupesCmd.Parse(os.Args[2:])
+	if err != nil {
+		return
+	}
+
+	result = dupesArgs{dbPath: *dupesDb}
+	return
+}
+
+func parseAlbumDupesArgs() (result albumDupesArgs, err error) {
+	albumDb := albumDupesCmd.String("dbPath", defaultDb, storeUsage)
+	minOverlap := albumDupesCmd.Float64("minOverlap", 0.5,
+		"report albums sharing at least this fraction of the smaller album's tracks (0 to 1)")
+	err = albumDupesCmd.Parse(os.Args[2:])
+	if err == nil && (*minOverlap <= 0 || *minOverlap > 1) {
+		err = fmt.Errorf("minOverlap must be greater than 0 and at most 1, not %v", *minOverlap)
+	}
+	if err != nil {
+		return
+	}
+
+	result = albumDupesArgs{dbPath: *albumDb, minOverlap: *minOverlap}
+	return
+}
+
+func parseAlbumCompletesArgs() (result albumCompletesArgs, err error) {
+	directory := albumCompletesCmd.String("directory", "", "incoming directory to look for missing tracks in")
+	albumDb := albumCompletesCmd.String("dbPath", defaultDb, storeUsage)
+	err = albumCompletesCmd.Parse(os.Args[2:])
+	if err == nil && *directory == "" {
+		err = errors.New("directory is required")
+	}
+	if err != nil {
+		return
+	}
+
+	result = albumCompletesArgs{directory: *directory, dbPath: *albumDb}
+	return
+}
+
+func parseMissingTracksArgs() (result missingTracksArgs, err error) {
+	missingDb := missingTracksCmd.String("dbPath", defaultDb, storeUsage)
+	format := missingTracksCmd.String("format", "text", "text or json")
+	err = missingTracksCmd.Parse(os.Args[2:])
+	if err == nil && *format != "text" && *format != "json" {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = missingTracksArgs{dbPath: *missingDb, format: *format}
+	return
+}
+
+func parseLintArgs() (result lintArgs, err error) {
+	lintDb := lintCmd.String("dbPath", defaultDb, storeUsage)
+	format := lintCmd.String("format", "text", "text or json")
+	configPath := lintCmd.String("config", "",
+		`JSON file of rules to turn off and severities to change, e.g. {"disabled": ["whitespace"], `+
+			`"severities": {"id3v1-only": "error"}}`)
+	var disabled, severities stringList
+	lintCmd.Var(&disabled, "disable", "rule to skip (repeatable)")
+	lintCmd.Var(&severities, "severity", "rule=severity to change a rule's severity (repeatable)")
+	list := lintCmd.Bool("list", false, "list the rules instead of running them")
+	err = lintCmd.Parse(os.Args[2:])
+	if err == nil && *format != "text" && *format != "json" {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
 	}
+
+	var config lint.Config
+	if *configPath != "" {
+		var b []byte
+		b, err = ioutil.ReadFile(*configPath)
+		if err == nil {
+			err = json.Unmarshal(b, &config)
+		}
+		if err != nil {
+			err = fmt.Errorf("error reading lint config %q:  %s", *configPath, err)
+			return
+		}
+	}
+	config.Disabled = append(config.Disabled, disabled...)
+	for _, s := range severities {
+		parts := strings.SplitN(s, "=", 2)
+		if len(parts) != 2 {
+			err = fmt.Errorf("severity %q isn't of the form rule=severity", s)
+			return
+		}
+		if config.Severities == nil {
+			config.Severities = make(map[string]lint.Severity)
+		}
+		config.Severities[parts[0]] = lint.Severity(parts[1])
+	}
+	err = config.Validate()
+	if err != nil {
+		return
+	}
+
+	result = lintArgs{dbPath: *lintDb, format: *format, config: config, list: *list}
+	return
+}
+
+func parseQueryArgs() (result queryArgs, err error) {
+	queryDb := queryCmd.String("dbPath", defaultDb, sqliteUsage)
+	sortBy := queryCmd.String("sort", "artist,album,disc,track",
+		"comma-separated fields to sort by; prefix a field with - for descending order")
+	limit := queryCmd.Int("limit", 0, "show at most this many songs (0 for all)")
+	format := queryCmd.String("format", "table", "table, json or csv")
+	queryCmd.Usage = func() {
+		_, _ = fmt.Fprint(queryCmd.Output(), `Usage:  smartmp3mgr query [flags] 'artist:"miles davis" -genre:podcast track>3'
+
+Terms are ANDed; put OR between terms to match either.  Bare words search title, artist, album and album artist.
+Fields:  path artist album albumartist title genre hash format (text:  field:contains, field=exact, field!=exact)
+         track tracks disc discs bitrate samplerate duration size (numbers:  = != < <= > >=)
+Prefix a term with - to negate it.
+`)
+		queryCmd.PrintDefaults()
+	}
+	err = queryCmd.Parse(os.Args[2:])
+	if err == nil && !containsString(queryFormats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = queryArgs{
+		query:  strings.Join(queryCmd.Args(), " "),
+		dbPath: *queryDb,
+		sortBy: *sortBy,
+		limit:  *limit,
+		format: *format,
+	}
+	return
+}
+
+func parseSearchArgs() (result searchArgs, err error) {
+	searchDb := searchCmd.String("dbPath", defaultDb, sqliteUsage)
+	limit := searchCmd.Int("limit", 50, "show at most this many songs (0 for all)")
+	format := searchCmd.String("format", "table", "table, json or csv")
+	err = searchCmd.Parse(os.Args[2:])
+	if err == nil && searchCmd.NArg() == 0 {
+		err = errors.New("nothing to search for")
+	}
+	if err == nil && !containsString(queryFormats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = searchArgs{
+		text:   strings.Join(searchCmd.Args(), " "),
+		dbPath: *searchDb,
+		limit:  *limit,
+		format: *format,
+	}
+	return
+}
+
+func parseStatsArgs() (result statsArgs, err error) {
+	statsDb := statsCmd.String("dbPath", defaultDb, storeUsage)
+	top := statsCmd.Int("top", 10, "how many of the top artists to list")
+	format := statsCmd.String("format", "text", "text or json")
+	err = statsCmd.Parse(os.Args[2:])
+	if err == nil && *format != "text" && *format != "json" {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = statsArgs{dbPath: *statsDb, top: *top, format: *format}
+	return
+}
+
+func parseReportArgs() (result reportArgs, err error) {
+	reportDb := reportCmd.String("dbPath", defaultDb, storeUsage)
+	outPath := reportCmd.String("out", "library.html", "HTML file to write")
+	top := reportCmd.Int("top", 20, "how many of the top artists to list")
+	err = reportCmd.Parse(os.Args[2:])
+	if err != nil {
+		return
+	}
+
+	result = reportArgs{dbPath: *reportDb, outPath: *outPath, top: *top}
+	return
+}
+
+func parseWatchArgs() (result watchArgs, err error) {
+	var directories stringList
+	watchCmd.Var(&directories, "directory", "directory to watch (repeatable)")
+	watchDb := watchCmd.String("dbPath", defaultDb, storeUsage)
+	debounce := watchCmd.Duration("debounce", 2*time.Second,
+		"how long to wait for changes to settle before recording them")
+	err = watchCmd.Parse(os.Args[2:])
+	if err == nil && len(directories) == 0 {
+		err = errors.New("at least one directory is required")
+	}
+	if err != nil {
+		return
+	}
+
+	// paths are recorded absolute, so the directories have to be too for changes under them to match
+	for i, directory := range directories {
+		directories[i], err = filepath.Abs(directory)
+		if err != nil {
+			return
+		}
+	}
+
+	result = watchArgs{directories: directories, dbPath: *watchDb, debounce: *debounce}
+	return
+}
+
+func parseServeArgs() (result serveArgs, err error) {
+	var directories stringList
+	serveCmd.Var(&directories, "directory", "directory clients may ask to scan (repeatable)")
+	addr := serveCmd.String("addr", "127.0.0.1:8080", "address to listen on")
+	serveDb := serveCmd.String("dbPath", defaultDb, sqliteUsage)
+	maxUpload := serveCmd.Int64("maxUpload", 512, "largest file accepted by /lookup, in MiB")
+	subsonicAddr := serveCmd.String("subsonicAddr", "127.0.0.1:4040", "address to serve the Subsonic API on")
+	subsonicUser := serveCmd.String("subsonicUser", "", "also serve the Subsonic API for this user")
+	subsonicPasswordFile := serveCmd.String("subsonicPasswordFile", "",
+		"file holding the password for -subsonicUser (default $"+subsonicPasswordVar+")")
+	err = serveCmd.Parse(os.Args[2:])
+	if err != nil {
+		return
+	}
+
+	// the password isn't a flag, since command lines are visible to every user
+	subsonicPassword := os.Getenv(subsonicPasswordVar)
+	if *subsonicPasswordFile != "" {
+		var b []byte
+		b, err = ioutil.ReadFile(*subsonicPasswordFile)
+		if err != nil {
+			return
+		}
+		subsonicPassword = strings.TrimRight(string(b), "\r\n")
+	}
+	if *subsonicUser != "" && subsonicPassword == "" {
+		err = fmt.Errorf("-subsonicUser needs a password in $%s or -subsonicPasswordFile", subsonicPasswordVar)
+		return
+	}
+
+	for i, directory := range directories {
+		directories[i], err = filepath.Abs(directory)
+		if err != nil {
+			return
+		}
+	}
+
+	result = serveArgs{
+		addr:             *addr,
+		dbPath:           *serveDb,
+		directories:      directories,
+		maxUpload:        *maxUpload,
+		subsonicAddr:     *subsonicAddr,
+		subsonicUser:     *subsonicUser,
+		subsonicPassword: subsonicPassword,
+	}
+	return
+}
+
+func parseExportArgs() (result exportArgs, err error) {
+	exportDb := exportCmd.String("dbPath", defaultDb, storeUsage)
+	out := exportCmd.String("out", "-", "file to write, or - for stdout")
+	format := exportCmd.String("format", "jsonl", "jsonl or csv")
+	err = exportCmd.Parse(os.Args[2:])
+	if err == nil && !containsString(catalogue.Formats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = exportArgs{dbPath: *exportDb, path: *out, format: *format}
+	return
+}
+
+func parseImportArgs() (result importArgs, err error) {
+	importDb := importCmd.String("dbPath", defaultDb, storeUsage)
+	in := importCmd.String("in", "", "file written by export")
+	format := importCmd.String("format", "jsonl", "jsonl or csv")
+	onConflict := importCmd.String("onConflict", string(records.KeepExisting),
+		"what to do with paths already in the db:  skip, overwrite or merge (fill in empty fields)")
+	err = importCmd.Parse(os.Args[2:])
+	if err == nil && *in == "" {
+		err = errors.New("a file to import is required")
+	}
+	if err == nil && !containsString(catalogue.Formats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	var policy records.ConflictPolicy
+	if err == nil {
+		policy, err = parseConflictPolicy(*onConflict)
+	}
+	if err != nil {
+		return
+	}
+
+	result = importArgs{dbPath: *importDb, path: *in, format: *format, onConflict: policy}
+	return
+}
+
+func parseExportManifestArgs() (result exportManifestArgs, err error) {
+	manifestDb := exportManifestCmd.String("dbPath", defaultDb, storeUsage)
+	out := exportManifestCmd.String("out", "-", "file to write, or - for stdout")
+	format := exportManifestCmd.String("format", manifest.Text, "output format:  "+strings.Join(manifest.Formats, ", "))
+	rate := exportManifestCmd.Float64("fp", 0.001,
+		"with -format bloom, the chance of a song that isn't in the library looking like it is")
+	err = exportManifestCmd.Parse(os.Args[2:])
+	if err == nil && !containsString(manifest.Formats, *format) {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err == nil && (*rate <= 0 || *rate >= 1) {
+		err = errors.New("fp must be between 0 and 1")
+	}
+	if err != nil {
+		return
+	}
+
+	result = exportManifestArgs{dbPath: *manifestDb, path: *out, format: *format, falsePositiveRate: *rate}
+	return
+}
+
+func parseCompareArgs() (result compareArgs, err error) {
+	a := compareCmd.String("a", defaultDb, "the first library's database")
+	b := compareCmd.String("b", "", "the second library's database")
+	manifestPath := compareCmd.String("manifest", "",
+		"a hash manifest (from export-manifest) to compare with instead of -b")
+	format := compareCmd.String("format", "text", "text or json")
+	songs := compareCmd.Bool("songs", false, "with text output, list each song as well as each album")
+	err = compareCmd.Parse(os.Args[2:])
+	if err == nil && (*b == "") == (*manifestPath == "") {
+		err = errors.New("give either -b or -manifest to compare with")
+	}
+	if err == nil && *format != "text" && *format != "json" {
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return
+	}
+
+	result = compareArgs{a: *a, b: *b, manifest: *manifestPath, format: *format, songs: *songs}
+	return
+}
+
+func parseMergeArgs() (result mergeArgs, err error) {
+	mergeDb := mergeCmd.String("dbPath", defaultDb, "database to merge into; "+storeUsage)
+	var sources mergeSources
+	mergeCmd.Var(fromFlag{&sources}, "from", "database to merge in (repeatable)")
+	mergeCmd.Var(mapFlag{&sources}, "map",
+		"OLD=NEW:  rewrite paths under OLD to be under NEW instead, for the -from before it (repeatable)")
+	onConflict := mergeCmd.String("onConflict", string(records.KeepExisting),
+		"what to do with a path that's already taken:  skip (the first database's song wins), overwrite (the last's "+
+			"does) or merge (fill in empty fields)")
+	err = mergeCmd.Parse(os.Args[2:])
+	if err == nil && len(sources) == 0 {
+		err = errors.New("at least one -from is required")
+	}
+	for _, source := range sources {
+		if err == nil && filepath.Clean(source.dbPath) == filepath.Clean(*mergeDb) {
+			err = fmt.Errorf("%q can't be merged into itself", source.dbPath)
+		}
+	}
+	var policy records.ConflictPolicy
+	if err == nil {
+		policy, err = parseConflictPolicy(*onConflict)
+	}
+	if err != nil {
+		return
+	}
+
+	result = mergeArgs{dbPath: *mergeDb, sources: sources, onConflict: policy}
+	return
+}
+
+func parseRootsArgs() (result rootsArgs, err error) {
+	rootsDb := rootsCmd.String("dbPath", defaultDb, storeUsage)
+	err = rootsCmd.Parse(os.Args[2:])
+	if err != nil {
+		return
+	}
+
+	result = rootsArgs{dbPath: *rootsDb}
+	return
+}
+
+func parseRootArgs(cmd *flag.FlagSet) (result rootArgs, err error) {
+	rootDb := cmd.String("dbPath", defaultDb, storeUsage)
+	err = cmd.Parse(os.Args[2:])
+	if err == nil && cmd.NArg() != 2 {
+		err = fmt.Errorf("usage:  smartmp3mgr %s [-dbPath path] <name> <path>", cmd.Name())
+	}
+	if err != nil {
+		return
+	}
+
+	result = rootArgs{dbPath: *rootDb, name: cmd.Arg(0), path: cmd.Arg(1)}
+	return
+}
+
+// parseCollectionArgs parses the flags of cmd, which takes the collection names in operands (e.g. "<old> <new>").
+func parseCollectionArgs(cmd *flag.FlagSet, operands string) (result collectionArgs, err error) {
+	collectionDb := cmd.String("dbPath", defaultDb, storeUsage)
+	err = cmd.Parse(os.Args[2:])
+	if err == nil && cmd.NArg() != len(strings.Fields(operands)) {
+		err = fmt.Errorf("usage:  smartmp3mgr %s [-dbPath path] %s", cmd.Name(), operands)
+	}
+	if err != nil {
+		return
+	}
+
+	result = collectionArgs{dbPath: *collectionDb, names: cmd.Args()}
 	return
 }
@@ -0,0 +1,186 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Apply carries out a plan, journaling each operation in the records DB as it goes so that the run can be undone.
+// Deleted files aren't really deleted; they're moved under trashDir.  The run ID is returned even on failure, since
+// a partially applied run can still be undone.
+func Apply(w io.Writer, rk *records.RecordKeeper, p Plan, trashDir string) (string, error) {
+	err := p.Validate()
+	if err != nil {
+		return "", err
+	}
+
+	runID := newRunID()
+	err = rk.StartRun(runID, p.Description)
+	if err != nil {
+		return "", err
+	}
+
+	for i, op := range p.Operations {
+		entry := records.JournalEntry{RunID: runID, Seq: i + 1, Kind: string(op.Kind), Source: op.Source}
+
+		// bookkeeping updates the records once the file has changed and the change is journaled
+		var bookkeeping func() error
+		switch op.Kind {
+		case Move:
+			entry.Destination = op.Destination
+			err = mp3fileutil.MoveFile(op.Source, op.Destination)
+			bookkeeping = func() error { return rk.RenamePath(op.Source, op.Destination) }
+		case Delete:
+			entry.Destination = filepath.Join(trashDir, runID, fmt.Sprintf("%d-%s", entry.Seq, filepath.Base(op.Source)))
+			entry.Backup, err = backupSong(rk, op.Source)
+			if err == nil {
+				err = mp3fileutil.MoveFile(op.Source, entry.Destination)
+			}
+			bookkeeping = func() error {
+				_, err := rk.ForgetPath(op.Source)
+				return err
+			}
+		case Link:
+			entry.Destination = op.Destination
+			err = mp3fileutil.HardLink(op.Source, op.Destination)
+		case Reflink:
+			entry.Destination = op.Destination
+			err = mp3fileutil.Reflink(op.Source, op.Destination)
+		case Retag:
+			entry.Backup, err = mp3util.RetagMP3(op.Source, op.Tags)
+			bookkeeping = func() error { return rerecord(rk, op.Source) }
+		}
+		if err != nil {
+			return runID, fmt.Errorf("failed to %s %q:  %s", op.Kind, op.Source, err)
+		}
+
+		// journaled before the records are updated, so that the file can be put back even if that fails
+		err = rk.RecordJournalEntry(entry)
+		if err != nil {
+			return runID, err
+		}
+		if bookkeeping != nil {
+			err = bookkeeping()
+			if err != nil {
+				return runID, fmt.Errorf("failed to update the records after %s %q:  %s", op.Kind, op.Source, err)
+			}
+		}
+		_, _ = fmt.Fprintln(w, op)
+	}
+
+	return runID, nil
+}
+
+// Undo reverses a run, last operation first.  Operations that already look reverted are skipped, so an undo that
+// failed part of the way through can simply be tried again.
+func Undo(w io.Writer, rk *records.RecordKeeper, runID string) error {
+	run, err := rk.FetchRun(runID)
+	if err != nil {
+		return err
+	}
+	if run.UndoneAt != "" {
+		return fmt.Errorf("run %q was already undone at %s", runID, run.UndoneAt)
+	}
+
+	entries, err := rk.FetchJournal(runID)
+	if err != nil {
+		return err
+	}
+
+	var failures []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		err = undoEntry(rk, entry)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s %q:  %s", entry.Kind, entry.Source, err))
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "undid %s %q\n", entry.Kind, entry.Source)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to undo %d operations:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+
+	return rk.MarkRunUndone(runID)
+}
+
+func undoEntry(rk *records.RecordKeeper, entry records.JournalEntry) error {
+	switch Kind(entry.Kind) {
+	case Move:
+		if alreadyBack(entry) {
+			return nil
+		}
+		err := mp3fileutil.MoveFile(entry.Destination, entry.Source)
+		if err != nil {
+			return err
+		}
+		return rk.RenamePath(entry.Destination, entry.Source)
+	case Delete:
+		if !alreadyBack(entry) {
+			err := mp3fileutil.MoveFile(entry.Destination, entry.Source)
+			if err != nil {
+				return err
+			}
+		}
+		if len(entry.Backup) == 0 {
+			return nil
+		}
+		var song mp3util.Song
+		err := json.Unmarshal(entry.Backup, &song)
+		if err != nil {
+			return err
+		}
+		// the backup has the path the song had then, which its root may have been relocated from since
+		song.Path = entry.Source
+		return rk.RecordSong(song)
+	case Link, Reflink:
+		// the two files were identical when linked, so a fresh copy of the source is as good as the original
+		return mp3fileutil.ReplaceWithCopy(entry.Source, entry.Destination)
+	case Retag:
+		err := mp3util.ReplaceID3v2Tag(entry.Source, entry.Backup)
+		if err != nil {
+			return err
+		}
+		return rerecord(rk, entry.Source)
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Kind)
+	}
+}
+
+func alreadyBack(entry records.JournalEntry) bool {
+	_, srcErr := os.Stat(entry.Source)
+	_, dstErr := os.Stat(entry.Destination)
+	return srcErr == nil && os.IsNotExist(dstErr)
+}
+
+// backupSong returns the Songs row for a path as JSON, so that undoing its deletion can record it again, or nil if it
+// wasn't recorded.
+func backupSong(rk *records.RecordKeeper, path string) ([]byte, error) {
+	song, err := rk.FetchSong(path)
+	if err != nil || song == nil {
+		return nil, err
+	}
+	return json.Marshal(song)
+}
+
+// rerecord refreshes the Songs row for a file whose tags changed, if the file was recorded in the first place.
+func rerecord(rk *records.RecordKeeper, path string) error {
+	existing, err := rk.FetchSong(path)
+	if err != nil || existing == nil {
+		return err
+	}
+	song, err := mp3util.ParseMP3(path)
+	if err != nil {
+		return err
+	}
+	song.Collection = existing.Collection
+	return rk.RecordSong(song)
+}
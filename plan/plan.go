@@ -0,0 +1,136 @@
+package plan
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Kind string
+
+const (
+	Move   Kind = "move"
+	Delete Kind = "delete"
+	Retag  Kind = "retag"
+	// Link and Reflink replace Destination, an identical copy of Source, with a hard link or reflink to Source.
+	Link    Kind = "link"
+	Reflink Kind = "reflink"
+)
+
+// Operation is one intended change to a file.  Destination is only used by moves and links, and Tags only by retags
+// (see mp3util.RetagMP3 for the field names).
+type Operation struct {
+	Kind        Kind              `json:"kind"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Plan is an ordered list of operations that can be reviewed before anything on disk changes.
+type Plan struct {
+	Description string      `json:"description,omitempty"`
+	Operations  []Operation `json:"operations"`
+}
+
+func (p *Plan) Add(op Operation) {
+	p.Operations = append(p.Operations, op)
+}
+
+func (op Operation) String() string {
+	switch op.Kind {
+	case Move:
+		return fmt.Sprintf("move    %q -> %q", op.Source, op.Destination)
+	case Link, Reflink:
+		return fmt.Sprintf("%-7s %q -> %q", op.Kind, op.Destination, op.Source)
+	case Retag:
+		var fields []string
+		for field, value := range op.Tags {
+			fields = append(fields, fmt.Sprintf("%s=%q", field, value))
+		}
+		sort.Strings(fields)
+		return fmt.Sprintf("retag   %q %s", op.Source, strings.Join(fields, " "))
+	default:
+		return fmt.Sprintf("%-7s %q", op.Kind, op.Source)
+	}
+}
+
+func (p Plan) Print(w io.Writer) {
+	for _, op := range p.Operations {
+		_, _ = fmt.Fprintln(w, op)
+	}
+	_, _ = fmt.Fprintf(w, "(%d operations)\n", len(p.Operations))
+}
+
+func Load(path string) (Plan, error) {
+	var p Plan
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("error reading plan %q:  %s", path, err)
+	}
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return p, fmt.Errorf("error parsing plan %q:  %s", path, err)
+	}
+	return p, nil
+}
+
+func (p Plan) Save(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Validate checks every operation up front, so that a plan that can't possibly apply fails before touching anything.
+func (p Plan) Validate() error {
+	for i, op := range p.Operations {
+		if op.Source == "" {
+			return fmt.Errorf("operation %d has no source", i+1)
+		}
+		if _, err := os.Stat(op.Source); err != nil {
+			return fmt.Errorf("operation %d:  %s", i+1, err)
+		}
+		switch op.Kind {
+		case Move:
+			if op.Destination == "" {
+				return fmt.Errorf("operation %d moves %q nowhere", i+1, op.Source)
+			}
+			if _, err := os.Stat(op.Destination); err == nil {
+				return fmt.Errorf("operation %d would overwrite %q", i+1, op.Destination)
+			}
+		case Link, Reflink:
+			// the plan may be applied long after it was made, so make sure Destination is still a copy of Source
+			same, err := mp3fileutil.SameContents(op.Source, op.Destination)
+			if err != nil {
+				return fmt.Errorf("operation %d:  %s", i+1, err)
+			}
+			if !same {
+				return fmt.Errorf("operation %d would %s %q, which is no longer identical to %q", i+1, op.Kind,
+					op.Destination, op.Source)
+			}
+		case Retag:
+			if len(op.Tags) == 0 {
+				return fmt.Errorf("operation %d retags %q with no tags", i+1, op.Source)
+			}
+		case Delete:
+		default:
+			return fmt.Errorf("operation %d has unknown kind %q", i+1, op.Kind)
+		}
+	}
+	return nil
+}
+
+func newRunID() string {
+	b := make([]byte, 3)
+	_, _ = rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,146 @@
+//go:build cgo
+// +build cgo
+
+package plan
+
+import (
+	"bytes"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestApplyAndUndo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := []string{"spring-chicken.mp3", "wakka-wakka-default.mp3", "wakka-wakka-altered-tags.mp3"}
+	original := make(map[string][]byte)
+	for _, fixture := range fixtures {
+		original[fixture], _ = ioutil.ReadFile(testHelpers.GetFixturePath(fixture))
+		_ = ioutil.WriteFile(filepath.Join(dir, fixture), original[fixture], 0644)
+	}
+
+	db, err := records.Open(filepath.Join(dir, "records.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	recorded, _ := mp3util.ParseMP3(filepath.Join(dir, "wakka-wakka-default.mp3"))
+	_ = db.RecordSong(recorded)
+
+	var p Plan
+	p.Add(Operation{Kind: Move, Source: filepath.Join(dir, "spring-chicken.mp3"),
+		Destination: filepath.Join(dir, "moved", "spring-chicken.mp3")})
+	p.Add(Operation{Kind: Delete, Source: filepath.Join(dir, "wakka-wakka-default.mp3")})
+	p.Add(Operation{Kind: Retag, Source: filepath.Join(dir, "wakka-wakka-altered-tags.mp3"),
+		Tags: map[string]string{"Title": "Retagged"}})
+
+	planPath := filepath.Join(dir, "plan.json")
+	err = p.Save(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(planPath)
+	if err != nil || !reflect.DeepEqual(p, loaded) {
+		t.Fatalf("Plan didn't round-trip:  %+v (%v)", loaded, err)
+	}
+
+	runID, err := Apply(ioutil.Discard, db, loaded, filepath.Join(dir, "trash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "moved", "spring-chicken.mp3")); err != nil {
+		t.Error("File wasn't moved")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "wakka-wakka-default.mp3")); err == nil {
+		t.Error("File wasn't deleted")
+	}
+	if song, _ := db.FetchSong(recorded.Path); song != nil {
+		t.Error("Deleted file is still recorded")
+	}
+	if retagged, _ := mp3util.ParseMP3(filepath.Join(dir, "wakka-wakka-altered-tags.mp3")); retagged.Title != "Retagged" {
+		t.Errorf("File wasn't retagged:  %+v", retagged)
+	}
+
+	err = Undo(ioutil.Discard, db, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		restored, err := ioutil.ReadFile(filepath.Join(dir, fixture))
+		if err != nil || !bytes.Equal(restored, original[fixture]) {
+			t.Errorf("%s wasn't restored (%v)", fixture, err)
+		}
+	}
+	if song, _ := db.FetchSong(recorded.Path); song == nil || *song != recorded {
+		t.Errorf("Deleted song wasn't re-recorded:  %+v", song)
+	}
+	if err = Undo(ioutil.Discard, db, runID); err == nil {
+		t.Error("Expected an error undoing the same run twice")
+	}
+}
+
+func TestApplyJournalsBeforeUpdatingRecords(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "spring-chicken.mp3")
+	original, _ := ioutil.ReadFile(testHelpers.GetFixturePath("spring-chicken.mp3"))
+	_ = ioutil.WriteFile(source, original, 0644)
+
+	db, err := records.Open(filepath.Join(dir, "records.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	recorded, _ := mp3util.ParseMP3(source)
+	_ = db.RecordSong(recorded)
+	// the move succeeds, but renaming its Songs row doesn't
+	_, err = db.Exec("CREATE TRIGGER FailRename BEFORE UPDATE ON Songs BEGIN SELECT RAISE(ABORT, 'disk full'); END")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var p Plan
+	p.Add(Operation{Kind: Move, Source: source, Destination: filepath.Join(dir, "moved", "spring-chicken.mp3")})
+	runID, err := Apply(ioutil.Discard, db, p, filepath.Join(dir, "trash"))
+	if err == nil {
+		t.Fatal("Expected an error updating the records")
+	}
+
+	err = Undo(ioutil.Discard, db, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := ioutil.ReadFile(source); err != nil || !bytes.Equal(restored, original) {
+		t.Errorf("The moved file wasn't put back (%v)", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	existing := testHelpers.GetFixturePath("spring-chicken.mp3")
+	invalid := []Plan{
+		{Operations: []Operation{{Kind: Move, Source: existing}}},
+		{Operations: []Operation{{Kind: Move, Source: existing, Destination: existing}}},
+		{Operations: []Operation{{Kind: Delete, Source: existing + ".missing"}}},
+		{Operations: []Operation{{Kind: Retag, Source: existing}}},
+		{Operations: []Operation{{Kind: "shred", Source: existing}}},
+		{Operations: []Operation{{Kind: Link, Source: existing,
+			Destination: testHelpers.GetFixturePath("wakka-wakka-default.mp3")}}},
+	}
+	for _, p := range invalid {
+		if p.Validate() == nil {
+			t.Errorf("Expected %+v to be invalid", p.Operations[0])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/plan"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type hashedFile struct {
+	path, hash, fileHash string
+	err                  error
+	tags                 *mp3util.Song
+}
+
+const quarantineManifest = "manifest.csv"
+
+// quarantine moves every duplicate out of the incoming folder into the quarantine folder (keeping paths relative to
+// the incoming folder) and appends a line per file to the quarantine manifest saying which canonical file it
+// duplicated.  With a plan path, the moves are only saved for review; otherwise db has to be SQLite, for the journal.
+func quarantine(stderr io.Writer, db records.Store, args findNewArgs,
+	duplicates []hashedFile, existsMap map[string][]mp3util.Song) {
+	root, err := filepath.Abs(args.directory)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	quarantineDir, err := filepath.Abs(args.quarantineDir)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	sort.Slice(duplicates, func(i, j int) bool { return duplicates[i].path < duplicates[j].path })
+
+	p := plan.Plan{Description: fmt.Sprintf("quarantine duplicates in %q", root)}
+	var moved []hashedFile
+	for _, d := range duplicates {
+		if strings.HasPrefix(d.path, quarantineDir+string(filepath.Separator)) || isRecordedCopy(d, existsMap) {
+			continue
+		}
+		rel, err := filepath.Rel(root, d.path)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		moved = append(moved, d)
+		p.Add(plan.Operation{Kind: plan.Move, Source: d.path, Destination: filepath.Join(quarantineDir, rel)})
+	}
+
+	if args.planPath != "" {
+		err = p.Save(args.planPath)
+		if err != nil {
+			diePrintf(stderr, "failed to save plan:  %s\n", err)
+		}
+		_, _ = fmt.Fprintf(stderr, "Saved %d quarantine moves to %q\n", len(p.Operations), args.planPath)
+		return
+	}
+
+	if len(p.Operations) == 0 {
+		_, _ = fmt.Fprintln(stderr, "(nothing to quarantine)")
+		return
+	}
+
+	runID, err := plan.Apply(ioutil.Discard, db.(*records.RecordKeeper), p, defaultTrash)
+	if err != nil {
+		if runID != "" {
+			_, _ = fmt.Fprintf(stderr, "quarantine stopped part of the way through; undo it with \"smartmp3mgr undo %s\"\n",
+				runID)
+		}
+		diePrintln(stderr, err)
+	}
+
+	err = writeQuarantineManifest(quarantineDir, runID, p.Operations, moved, existsMap)
+	if err != nil {
+		diePrintf(stderr, "failed to write quarantine manifest:  %s\n", err)
+	}
+
+	_, _ = fmt.Fprintf(stderr, "Quarantined %d duplicates in %q (run %s)\n", len(p.Operations), quarantineDir, runID)
+}
+
+// isRecordedCopy says whether d is itself one of the recorded songs it matches, as happens when the incoming folder has
+// already been recorded; quarantining it would leave the library without that song.
+func isRecordedCopy(d hashedFile, existsMap map[string][]mp3util.Song) bool {
+	abs, err := filepath.Abs(d.path)
+	if err != nil {
+		abs = d.path
+	}
+	for _, m := range matchesFor(d, existsMap) {
+		if m.canonical.Path == d.path || m.canonical.Path == abs {
+			return true
+		}
+	}
+	return false
+}
+
+func writeQuarantineManifest(quarantineDir string, runID string, moves []plan.Operation, duplicates []hashedFile,
+	existsMap map[string][]mp3util.Song) error {
+	manifestPath := filepath.Join(quarantineDir, quarantineManifest)
+	_, statErr := os.Stat(manifestPath)
+
+	f, err := os.OpenFile(manifestPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if os.IsNotExist(statErr) {
+		_ = w.Write([]string{"Original", "Quarantined", "Canonical", "Match", "Hash", "Run"})
+	}
+	for i, move := range moves {
+		m := matchesFor(duplicates[i], existsMap)[0]
+		_ = w.Write([]string{move.Source, move.Destination, m.canonical.Path, m.kind, duplicates[i].hash, runID})
+	}
+	w.Flush()
+
+	return w.Error()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/query"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+var queryFormats = []string{"table", "json", "csv"}
+
+// runQuery prints the recorded songs matching a query; see query.Compile for the language.
+func runQuery(stdout io.Writer, stderr io.Writer, args queryArgs) {
+	compiled, err := query.Compile(args.query, args.sortBy)
+	if err != nil {
+		diePrintf(stderr, "bad query:  %s\n", err)
+	}
+
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.QuerySongs(compiled, args.limit)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	err = writeSongs(stdout, args.format, songs)
+	if err != nil {
+		diePrintf(stderr, "failed to write results:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stderr, "(%d songs)\n", len(songs))
+}
+
+func writeSongs(w io.Writer, format string, songs []mp3util.Song) error {
+	switch format {
+	case "json":
+		if songs == nil {
+			songs = []mp3util.Song{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(songs)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"Path", "Artist", "AlbumArtist", "Album", "Title", "Genre", "TrackNumber", "TotalTracks",
+			"DiscNumber", "TotalDiscs", "Bitrate", "SampleRate", "Duration", "Size", "TagFormat", "Hash", "FileHash"})
+		for _, s := range songs {
+			_ = cw.Write([]string{s.Path, s.Artist, s.AlbumArtist, s.Album, s.Title, s.Genre,
+				strconv.Itoa(s.TrackNumber), strconv.Itoa(s.TotalTracks), strconv.Itoa(s.DiscNumber),
+				strconv.Itoa(s.TotalDiscs), strconv.Itoa(s.Bitrate), strconv.Itoa(s.SampleRate), strconv.Itoa(s.Duration),
+				strconv.FormatInt(s.Size, 10), s.TagFormat, s.Hash, s.FileHash})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "ARTIST\tALBUM\tTRACK\tTITLE\tKBPS\tLENGTH\tPATH")
+		for _, s := range songs {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d:%02d\t%s\n", s.Artist, s.Album, trackLabel(s), s.Title,
+				s.Bitrate, s.Duration/60, s.Duration%60, s.Path)
+		}
+		return tw.Flush()
+	}
+}
+
+func trackLabel(s mp3util.Song) string {
+	switch {
+	case s.TrackNumber == 0:
+		return ""
+	case s.TotalDiscs > 1:
+		return fmt.Sprintf("%d-%d", s.DiscNumber, s.TrackNumber)
+	default:
+		return strconv.Itoa(s.TrackNumber)
+	}
+}
@@ -0,0 +1,248 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fields maps the names usable in a query to Songs columns.
+var fields = map[string]field{
+	"path":        {"Path", false},
+	"artist":      {"Artist", false},
+	"album":       {"Album", false},
+	"albumartist": {"AlbumArtist", false},
+	"title":       {"Title", false},
+	"genre":       {"Genre", false},
+	"hash":        {"Hash", false},
+	"format":      {"TagFormat", false},
+	"track":       {"TrackNumber", true},
+	"tracks":      {"TotalTracks", true},
+	"disc":        {"DiscNumber", true},
+	"discs":       {"TotalDiscs", true},
+	"bitrate":     {"Bitrate", true},
+	"samplerate":  {"SampleRate", true},
+	"duration":    {"Duration", true},
+	"size":        {"Size", true},
+	"collection":  {"Collection", false},
+}
+
+type field struct {
+	column  string
+	numeric bool
+}
+
+// bare words with no field are looked for in all of these
+var bareColumns = []string{"Title", "Artist", "Album", "AlbumArtist"}
+
+// operators, longest first so that "<=" isn't read as "<"
+var operators = []string{"<=", ">=", "!=", ":", "=", "<", ">"}
+
+// Compiled is a query turned into SQL.  Where and OrderBy only ever contain column names and placeholders from this
+// package; every value from the query is in Args.
+type Compiled struct {
+	Where   string
+	Args    []interface{}
+	OrderBy string
+}
+
+// Compile turns a query into a parameterised WHERE clause over Songs.  A query is a list of terms that must all match,
+// where a term is
+//
+//	word              a word (or "quoted phrase") in the title, artist, album or album artist
+//	field:value       a text field containing value, or a numeric field equal to it
+//	field=value       a field equal to value (ignoring case for text)
+//	field!=value      the opposite
+//	field<n, <=, >, >=  numeric comparisons (sizes are in bytes, durations in seconds)
+//
+// Any term can be negated with a leading "-", and "OR" between terms matches either side (AND binds tighter).
+// sortBy is a comma-separated list of fields, each optionally prefixed with "-" for descending order.
+func Compile(q string, sortBy string) (Compiled, error) {
+	var c Compiled
+	tokens, err := tokenize(q)
+	if err != nil {
+		return c, err
+	}
+
+	var groups []string
+	var group []string
+	for i, tok := range tokens {
+		if !tok.quoted && tok.text == "OR" {
+			if len(group) == 0 || i == len(tokens)-1 {
+				return c, errors.New("OR needs a term on each side")
+			}
+			groups = append(groups, strings.Join(group, " AND "))
+			group = nil
+			continue
+		}
+		clause, args, err := compileTerm(tok)
+		if err != nil {
+			return c, err
+		}
+		group = append(group, clause)
+		c.Args = append(c.Args, args...)
+	}
+	if len(group) > 0 {
+		groups = append(groups, strings.Join(group, " AND "))
+	}
+
+	switch len(groups) {
+	case 0:
+		c.Where = "1"
+	case 1:
+		c.Where = groups[0]
+	default:
+		c.Where = "(" + strings.Join(groups, ") OR (") + ")"
+	}
+
+	c.OrderBy, err = compileSort(sortBy)
+	return c, err
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits on whitespace outside double quotes.  Quotes may start mid-token (artist:"miles davis"); the quotes
+// themselves are dropped.
+func tokenize(q string) ([]token, error) {
+	var result []token
+	var current strings.Builder
+	inQuotes, quoted, started := false, false, false
+	for _, r := range q {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			quoted, started = true, true
+		case unicode.IsSpace(r) && !inQuotes:
+			if started {
+				result = append(result, token{current.String(), quoted})
+			}
+			current.Reset()
+			quoted, started = false, false
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quote")
+	}
+	if started {
+		result = append(result, token{current.String(), quoted})
+	}
+	return result, nil
+}
+
+func compileTerm(tok token) (string, []interface{}, error) {
+	text := tok.text
+	negated := false
+	if strings.HasPrefix(text, "-") && len(text) > 1 {
+		negated = true
+		text = text[1:]
+	}
+
+	clause, args, err := compilePositive(text)
+	if err != nil {
+		return "", nil, err
+	}
+	if negated {
+		clause = "NOT (" + clause + ")"
+	}
+	return clause, args, nil
+}
+
+func compilePositive(text string) (string, []interface{}, error) {
+	name, op, value := splitTerm(text)
+	if op == "" {
+		var clauses []string
+		var args []interface{}
+		for _, column := range bareColumns {
+			clauses = append(clauses, fmt.Sprintf(`IFNULL(%s, '') LIKE ? ESCAPE '\'`, column))
+			args = append(args, "%"+escapeLike(text)+"%")
+		}
+		return "(" + strings.Join(clauses, " OR ") + ")", args, nil
+	}
+
+	f, ok := fields[strings.ToLower(name)]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %q", name)
+	}
+
+	if !f.numeric {
+		switch op {
+		case ":":
+			return fmt.Sprintf(`IFNULL(%s, '') LIKE ? ESCAPE '\'`, f.column), []interface{}{"%" + escapeLike(value) + "%"},
+				nil
+		case "=":
+			return fmt.Sprintf("IFNULL(%s, '') = ? COLLATE NOCASE", f.column), []interface{}{value}, nil
+		case "!=":
+			return fmt.Sprintf("IFNULL(%s, '') != ? COLLATE NOCASE", f.column), []interface{}{value}, nil
+		default:
+			return "", nil, fmt.Errorf("%s is a text field, so %q can't be used with it", name, op)
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s needs a number, not %q", name, value)
+	}
+	if op == ":" {
+		op = "="
+	}
+	return fmt.Sprintf("IFNULL(%s, 0) %s ?", f.column, op), []interface{}{n}, nil
+}
+
+// splitTerm splits "field<op>value" at the first operator, if what's before it looks like a field name.
+func splitTerm(text string) (name string, op string, value string) {
+	best := -1
+	for _, candidate := range operators {
+		i := strings.Index(text, candidate)
+		if i > 0 && (best == -1 || i < best || (i == best && len(candidate) > len(op))) {
+			best, op = i, candidate
+		}
+	}
+	if best == -1 {
+		return "", "", text
+	}
+	for _, r := range text[:best] {
+		if !unicode.IsLetter(r) {
+			return "", "", text
+		}
+	}
+	return text[:best], op, text[best+len(op):]
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func compileSort(sortBy string) (string, error) {
+	var terms []string
+	for _, name := range strings.Split(sortBy, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		direction := "ASC"
+		if strings.HasPrefix(name, "-") {
+			direction = "DESC"
+			name = name[1:]
+		}
+		f, ok := fields[strings.ToLower(name)]
+		if !ok {
+			return "", fmt.Errorf("can't sort by unknown field %q", name)
+		}
+		collation := ""
+		if !f.numeric {
+			collation = " COLLATE NOCASE"
+		}
+		terms = append(terms, f.column+collation+" "+direction)
+	}
+	// keep the order stable for equal keys
+	terms = append(terms, "Path ASC")
+	return strings.Join(terms, ", "), nil
+}
@@ -0,0 +1,47 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	c, err := Compile(`artist:"miles davis" album:kind -genre:podcast track>3 bitrate<=192`, "artist,-track")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedWhere := `IFNULL(Artist, '') LIKE ? ESCAPE '\' AND IFNULL(Album, '') LIKE ? ESCAPE '\' AND ` +
+		`NOT (IFNULL(Genre, '') LIKE ? ESCAPE '\') AND IFNULL(TrackNumber, 0) > ? AND IFNULL(Bitrate, 0) <= ?`
+	if c.Where != expectedWhere {
+		t.Errorf("Where differed.  \r\nExpected:  %v  \r\nActual:  %v", expectedWhere, c.Where)
+	}
+	expectedArgs := []interface{}{"%miles davis%", "%kind%", "%podcast%", 3, 192}
+	if !reflect.DeepEqual(expectedArgs, c.Args) {
+		t.Errorf("Args differed.  \r\nExpected:  %v  \r\nActual:  %v", expectedArgs, c.Args)
+	}
+	expectedOrder := "Artist COLLATE NOCASE ASC, TrackNumber DESC, Path ASC"
+	if c.OrderBy != expectedOrder {
+		t.Errorf("OrderBy differed.  \r\nExpected:  %v  \r\nActual:  %v", expectedOrder, c.OrderBy)
+	}
+}
+
+func TestCompileOrAndBareWords(t *testing.T) {
+	c, err := Compile(`genre=jazz OR 100%`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Where[:30] != "(IFNULL(Genre, '') = ? COLLATE" || len(c.Args) != 5 || c.Args[1] != `%100\%%` {
+		t.Errorf("Unexpected compilation:  %v %v", c.Where, c.Args)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, q := range []string{`nosuchfield:x`, `track:abc`, `artist>x`, `artist:"unterminated`, `OR artist:x`} {
+		if _, err := Compile(q, ""); err == nil {
+			t.Errorf("Expected an error compiling %q", q)
+		}
+	}
+	if _, err := Compile("", "nosuchfield"); err == nil {
+		t.Error("Expected an error sorting by an unknown field")
+	}
+}
@@ -0,0 +1,191 @@
+package records
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"path/filepath"
+)
+
+func (rk *RecordKeeper) prepareAlbumsTables() error {
+	const statement = `
+		CREATE TABLE IF NOT EXISTS
+		  Albums (AlbumID INTEGER PRIMARY KEY, AlbumArtist TEXT NOT NULL, Album TEXT NOT NULL, Folder TEXT NOT NULL,
+		  DiscCount INTEGER NOT NULL, TrackCount INTEGER NOT NULL, Fingerprint TEXT NOT NULL);
+		CREATE INDEX IF NOT EXISTS
+		  AlbumsFingerprintIndex ON Albums(Fingerprint);
+		CREATE INDEX IF NOT EXISTS
+		  AlbumsAlbumIndex ON Albums(Album);
+		CREATE TABLE IF NOT EXISTS
+		  AlbumTracks (AlbumID INTEGER NOT NULL, Seq INTEGER NOT NULL, Path TEXT NOT NULL, PRIMARY KEY (AlbumID, Seq));
+		CREATE INDEX IF NOT EXISTS
+		  SongsAlbumIndex ON Songs(Album)
+    `
+
+	_, err := rk.Exec(statement)
+
+	return err
+}
+
+// RebuildAlbums regroups Albums and AlbumTracks from Songs, for after songs are forgotten, moved or imported.
+func (rk *RecordKeeper) RebuildAlbums() error {
+	songs, err := rk.FetchSongs()
+	if err != nil {
+		return err
+	}
+	albums := library.GroupAlbums(songs)
+
+	tx, err := rk.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{"DELETE FROM AlbumTracks", "DELETE FROM Albums"} {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("error clearing albums:  %s", err)
+		}
+	}
+
+	err = rk.insertAlbums(tx, albums)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// refreshAlbums regroups the albums in folder with the given Album tags, within tx.
+func (rk *RecordKeeper) refreshAlbums(tx *sql.Tx, folder string, names ...string) error {
+	prefix := rk.rootTable().storedPrefixes(folder, string(filepath.Separator))[0]
+	done := make(map[string]bool)
+	for _, name := range names {
+		if name == "" || done[name] {
+			continue
+		}
+		done[name] = true
+
+		for _, statement := range []string{
+			`DELETE FROM AlbumTracks
+			  WHERE AlbumID IN (SELECT AlbumID FROM Albums WHERE Album = @Album AND Folder = @Folder)`,
+			"DELETE FROM Albums WHERE Album = @Album AND Folder = @Folder",
+		} {
+			_, err := tx.Exec(statement, name, folder)
+			if err != nil {
+				return fmt.Errorf("error clearing album %q:  %s", name, err)
+			}
+		}
+
+		const query = "SELECT " + songColumns +
+			" FROM Songs WHERE Album = @Album AND substr(Path, 1, length(@Prefix)) = @Prefix"
+		rows, err := tx.Query(query, name, prefix)
+		if err != nil {
+			return err
+		}
+		var songs []mp3util.Song
+		for rows.Next() {
+			song, err := scanSong(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			// the prefix takes in subfolders too
+			if song = rk.resolvedSong(song); filepath.Dir(song.Path) == folder {
+				songs = append(songs, song)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		err = rk.insertAlbums(tx, library.GroupAlbums(songs))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rk *RecordKeeper) insertAlbums(tx *sql.Tx, albums []library.Album) error {
+	const albumStatement = `
+		INSERT INTO Albums(AlbumArtist, Album, Folder, DiscCount, TrackCount, Fingerprint)
+		VALUES (@AlbumArtist, @Album, @Folder, @DiscCount, @TrackCount, @Fingerprint)
+		`
+	const trackStatement = `
+		INSERT INTO AlbumTracks(AlbumID, Seq, Path) VALUES (@AlbumID, @Seq, @Path)
+		`
+
+	for _, album := range albums {
+		res, err := tx.Exec(albumStatement, album.AlbumArtist, album.Album, album.Folder, album.DiscCount,
+			len(album.Tracks), album.Fingerprint)
+		if err != nil {
+			return fmt.Errorf("error saving album %q in %q:  %s", album.Album, album.Folder, err)
+		}
+		albumID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for i, track := range album.Tracks {
+			_, err = tx.Exec(trackStatement, albumID, i+1, rk.storedPath(track.Path))
+			if err != nil {
+				return fmt.Errorf("error saving track %q:  %s", track.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// FetchAlbums returns the recorded albums by folder and album, with tracks in disc and track order.
+func (rk *RecordKeeper) FetchAlbums() ([]library.Album, error) {
+	return rk.fetchAlbums("1")
+}
+
+// FetchAlbum returns the album with the Album tag in folder, or nil if there isn't one.
+func (rk *RecordKeeper) FetchAlbum(folder string, album string) (*library.Album, error) {
+	albums, err := rk.fetchAlbums("a.Folder = @Folder AND a.Album = @Album", folder, album)
+	if err != nil || len(albums) == 0 {
+		return nil, err
+	}
+	return &albums[0], nil
+}
+
+func (rk *RecordKeeper) fetchAlbums(where string, args ...interface{}) ([]library.Album, error) {
+	var result []library.Album
+
+	query := "SELECT " + qualifiedSongColumns("s") + `,
+		  a.AlbumID, a.AlbumArtist, a.Album, a.Folder, a.DiscCount, a.Fingerprint
+		FROM Albums a
+		JOIN AlbumTracks t ON t.AlbumID = a.AlbumID
+		JOIN Songs s ON s.Path = t.Path
+		WHERE ` + where + `
+		ORDER BY a.Folder, a.Album, a.AlbumID, t.Seq
+		`
+
+	rows, err := rk.Query(query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to get albums:  %s", err)
+	}
+	defer rows.Close()
+
+	lastID := int64(-1)
+	for rows.Next() {
+		var albumID int64
+		var album library.Album
+		song, err := scanSong(rows, &albumID, &album.AlbumArtist, &album.Album, &album.Folder, &album.DiscCount,
+			&album.Fingerprint)
+		if err != nil {
+			return result, err
+		}
+		if albumID != lastID {
+			result = append(result, album)
+			lastID = albumID
+		}
+		last := &result[len(result)-1]
+		last.Tracks = append(last.Tracks, rk.resolvedSong(song))
+	}
+
+	return result, rows.Err()
+}
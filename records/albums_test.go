@@ -0,0 +1,86 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"testing"
+)
+
+func TestRebuildAndFetchAlbums(t *testing.T) {
+	db, err := Open("file:albums.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	second := records[0]
+	second.Path = "c:\\Users\\Casey\\Song3.mp3"
+	second.Hash = "beef"
+	second.TrackNumber = 2
+	untagged := mp3util.Song{Path: "c:\\Users\\Casey\\Untagged.mp3", Hash: "0123"}
+	for _, record := range append(records, second, untagged) {
+		_ = db.RecordSong(record)
+	}
+
+	err = db.RebuildAlbums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// rebuilding again mustn't duplicate anything
+	err = db.RebuildAlbums()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	albums, err := db.FetchAlbums()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(albums) != 2 {
+		t.Fatalf("Expected 2 albums, got %d:  %+v", len(albums), albums)
+	}
+	restless := albums[0]
+	if restless.Album != "Restless" || restless.AlbumArtist != "Starpoint" || len(restless.Tracks) != 2 ||
+		restless.Tracks[1].Path != second.Path || restless.Fingerprint == "" {
+		t.Errorf("Unexpected album:  %+v", restless)
+	}
+	if albums[1].DiscCount != 2 {
+		t.Errorf("Expected 2 discs, got %d", albums[1].DiscCount)
+	}
+}
+
+func TestRecordSongKeepsAlbums(t *testing.T) {
+	db, err := Open("file:recordalbums.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	first := mp3util.Song{Path: "/music/restless/1.mp3", Album: "Restless", Artist: "Starpoint", Hash: "1"}
+	second := mp3util.Song{Path: "/music/restless/2.mp3", Album: "Restless", Artist: "Starpoint", Hash: "2"}
+	// albums of the same name elsewhere, including under the folder, are left alone
+	other := mp3util.Song{Path: "/music/other/1.mp3", Album: "Restless", Artist: "Someone", Hash: "3"}
+	sub := mp3util.Song{Path: "/music/restless/cd2/1.mp3", Album: "Restless", Artist: "Starpoint", Hash: "4"}
+	for _, song := range []mp3util.Song{other, sub, first, second} {
+		err = db.RecordSong(song)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	albums, _ := db.FetchAlbums()
+	if len(albums) != 3 || len(albums[0].Tracks) != 1 || len(albums[1].Tracks) != 2 || len(albums[2].Tracks) != 1 {
+		t.Fatalf("Expected an album of two tracks and two others without a rebuild, got %+v", albums)
+	}
+
+	second.Album = "Restless (Bonus)"
+	err = db.RecordSong(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	albums, _ = db.FetchAlbums()
+	if len(albums) != 4 || len(albums[1].Tracks) != 1 || albums[2].Album != "Restless (Bonus)" {
+		t.Errorf("Expected the retagged song to move to its own album, got %+v", albums)
+	}
+}
@@ -0,0 +1,63 @@
+package records
+
+import "fmt"
+
+// Collection is a named set of songs and its size; songs recorded without one are in the one named "".
+type Collection struct {
+	Name  string `json:"name"`
+	Songs int    `json:"songs"`
+}
+
+// FetchCollections returns the collections songs are in, ordered by name.
+func (rk *RecordKeeper) FetchCollections() ([]Collection, error) {
+	var result []Collection
+
+	rows, err := rk.Query("SELECT Collection, COUNT(*) FROM Songs GROUP BY Collection ORDER BY Collection")
+	if err != nil {
+		return result, fmt.Errorf("failed to get collections:  %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Collection
+		err = rows.Scan(&c.Name, &c.Songs)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// RenameCollection moves every song in one collection into another, returning how many were moved.
+func (rk *RecordKeeper) RenameCollection(from string, to string) (int64, error) {
+	res, err := rk.Exec("UPDATE Songs SET Collection = @To WHERE Collection = @From", to, from)
+	if err != nil {
+		return 0, fmt.Errorf("error renaming collection %q:  %s", from, err)
+	}
+	return res.RowsAffected()
+}
+
+// DropCollection forgets a collection's songs and their cached hashes, returning how many songs were forgotten.
+func (rk *RecordKeeper) DropCollection(name string) (int64, error) {
+	tx, err := rk.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM Caches WHERE Path IN (SELECT Path FROM Songs WHERE Collection = @Name)", name)
+	if err != nil {
+		return 0, fmt.Errorf("error dropping collection %q:  %s", name, err)
+	}
+	res, err := tx.Exec("DELETE FROM Songs WHERE Collection = @Name", name)
+	if err != nil {
+		return 0, fmt.Errorf("error dropping collection %q:  %s", name, err)
+	}
+	dropped, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return dropped, tx.Commit()
+}
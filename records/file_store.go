@@ -0,0 +1,595 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const fileStoreFormat = "smartmp3mgr-store"
+
+// FileStore is a Store in an append-only JSON Lines file, read into memory when opened.  Paths under a root are
+// written relative to it, as in SQLite.  Only one process should have it open at a time.
+type FileStore struct {
+	path   string
+	file   *os.File
+	songs  map[string]mp3util.Song
+	caches map[string]CachedHash
+	roots  rootTable
+	// lines counts the lines in the file, to tell when it's worth compacting
+	lines int
+	// complete is the length of the file up to its last full line; torn says whether a partial line follows
+	complete int64
+	torn     bool
+	// guards everything above, so that a FileStore can be shared between goroutines like a RecordKeeper
+	lock sync.Mutex
+}
+
+var _ Store = (*FileStore)(nil)
+
+// fileEntry is one line of a FileStore:  Op says which change it records.
+type fileEntry struct {
+	Op      string        `json:"op"`
+	Format  string        `json:"format,omitempty"`
+	Name    string        `json:"name,omitempty"`
+	Path    string        `json:"path,omitempty"`
+	To      string        `json:"to,omitempty"`
+	Song    *mp3util.Song `json:"song,omitempty"`
+	Cache   *CachedHash   `json:"cache,omitempty"`
+	Version int           `json:"version,omitempty"`
+}
+
+// withPaths returns a copy of the entry with its paths passed through convert.  A root's path is left alone.
+func (e fileEntry) withPaths(convert func(path string) string) fileEntry {
+	if e.Op == "root" {
+		return e
+	}
+	if e.Path != "" {
+		e.Path = convert(e.Path)
+	}
+	if e.To != "" {
+		e.To = convert(e.To)
+	}
+	if e.Song != nil {
+		song := *e.Song
+		song.Path = convert(song.Path)
+		e.Song = &song
+	}
+	return e
+}
+
+// OpenFileStore opens the store at path, creating it if it doesn't exist.
+func OpenFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{path: path, songs: map[string]mp3util.Song{}, caches: map[string]CachedHash{}}
+
+	err := fs.load()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %q:  %s", path, err)
+	}
+
+	if fs.lines == 0 || fs.lines > 2*(len(fs.songs)+len(fs.caches))+100 {
+		err = fs.compact()
+		if err != nil {
+			return nil, fmt.Errorf("error compacting %q:  %s", path, err)
+		}
+	} else if fs.torn {
+		err = os.Truncate(path, fs.complete)
+		if err != nil {
+			return nil, fmt.Errorf("error removing the incomplete last line of %q:  %s", path, err)
+		}
+	}
+
+	fs.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %q:  %s", path, err)
+	}
+	return fs, nil
+}
+
+// load replays the file into memory, ignoring a torn last line, which OpenFileStore then cuts off.
+func (fs *FileStore) load() error {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			fs.torn = len(line) > 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fs.complete += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry fileEntry
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return fmt.Errorf("line %d:  %s", n, err)
+		}
+		if n == 1 && entry.Op != "header" {
+			return errors.New("not a smartmp3mgr store")
+		}
+		err = fs.apply(entry.withPaths(fs.roots.resolve))
+		if err != nil {
+			return fmt.Errorf("line %d:  %s", n, err)
+		}
+		fs.lines++
+	}
+}
+
+// apply makes the change an entry records to the maps, once its paths have been resolved.
+func (fs *FileStore) apply(entry fileEntry) error {
+	switch {
+	case entry.Op == "header":
+		if entry.Format != fileStoreFormat {
+			return errors.New("not a smartmp3mgr store")
+		}
+		if entry.Version > 1 {
+			return fmt.Errorf("the store is version %d, but only version 1 can be read", entry.Version)
+		}
+	case entry.Op == "song" && entry.Song != nil:
+		fs.songs[entry.Song.Path] = *entry.Song
+	case entry.Op == "cache" && entry.Cache != nil:
+		fs.caches[entry.Path] = *entry.Cache
+	case entry.Op == "forget":
+		delete(fs.songs, entry.Path)
+		delete(fs.caches, entry.Path)
+	case entry.Op == "rename":
+		if song, ok := fs.songs[entry.Path]; ok {
+			delete(fs.songs, entry.Path)
+			song.Path = entry.To
+			fs.songs[entry.To] = song
+		}
+		if cached, ok := fs.caches[entry.Path]; ok {
+			delete(fs.caches, entry.Path)
+			fs.caches[entry.To] = cached
+		}
+	case entry.Op == "root":
+		err := validateRoot(entry.Name, entry.Path)
+		if err != nil {
+			return err
+		}
+		fs.applyRoot(Root{entry.Name, entry.Path})
+	default:
+		return fmt.Errorf("unexpected %q line", entry.Op)
+	}
+	return nil
+}
+
+// applyRoot adds a root, or relocates the one of the same name, moving what was under it.
+func (fs *FileStore) applyRoot(root Root) {
+	old := fs.roots
+	fs.roots = old.with(root)
+	if _, ok := old.find(root.Name); !ok {
+		return
+	}
+
+	moved := func(path string) (string, bool) {
+		stored := old.store(path)
+		if stored != rootedPrefix+root.Name && !strings.HasPrefix(stored, rootedPrefix+root.Name+"/") {
+			return path, false
+		}
+		return fs.roots.resolve(stored), true
+	}
+	songs := make(map[string]mp3util.Song, len(fs.songs))
+	for path, song := range fs.songs {
+		song.Path, _ = moved(path)
+		songs[song.Path] = song
+	}
+	caches := make(map[string]CachedHash, len(fs.caches))
+	for path, cached := range fs.caches {
+		path, _ = moved(path)
+		caches[path] = cached
+	}
+	fs.songs, fs.caches = songs, caches
+}
+
+// compact rewrites the file with a line per song and cached hash, replacing it only once the new one is complete.
+func (fs *FileStore) compact() error {
+	entries := []fileEntry{{Op: "header", Format: fileStoreFormat, Version: 1}}
+	for _, root := range fs.roots.sorted() {
+		entries = append(entries, fileEntry{Op: "root", Name: root.Name, Path: root.Path})
+	}
+	for _, song := range fs.sortedSongs() {
+		song := song
+		entries = append(entries, fileEntry{Op: "song", Song: &song})
+	}
+	var paths []string
+	for path := range fs.caches {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		cached := fs.caches[path]
+		entries = append(entries, fileEntry{Op: "cache", Path: path, Cache: &cached})
+	}
+
+	for i := range entries {
+		entries[i] = entries[i].withPaths(fs.roots.store)
+	}
+	data, err := encodeEntries(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.OpenFile(fs.path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fs.path+".tmp", fs.path)
+	}
+	if err != nil {
+		_ = os.Remove(fs.path + ".tmp")
+		return err
+	}
+	fs.lines = len(entries)
+	return nil
+}
+
+func encodeEntries(entries []fileEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		err := enc.Encode(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// write appends entries in a single write and applies them; a root entry changes how paths are stored, so it goes
+// alone.  The caller holds the lock.
+func (fs *FileStore) write(entries ...fileEntry) error {
+	stored := make([]fileEntry, len(entries))
+	for i, entry := range entries {
+		stored[i] = entry.withPaths(fs.roots.store)
+	}
+	data, err := encodeEntries(stored)
+	if err != nil {
+		return err
+	}
+	_, err = fs.file.Write(data)
+	if err != nil {
+		return fmt.Errorf("error writing to %q:  %s", fs.path, err)
+	}
+	for _, entry := range stored {
+		_ = fs.apply(entry.withPaths(fs.roots.resolve))
+	}
+	fs.lines += len(entries)
+	return nil
+}
+
+func (fs *FileStore) Sync() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.file.Sync()
+}
+
+func (fs *FileStore) Close() error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	err := fs.file.Sync()
+	closeErr := fs.file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (fs *FileStore) RecordSong(song mp3util.Song) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.write(fileEntry{Op: "song", Song: &song})
+}
+
+// sortedSongs returns the songs ordered by path.  The caller holds the lock.
+func (fs *FileStore) sortedSongs() []mp3util.Song {
+	var result []mp3util.Song
+	for _, song := range fs.songs {
+		result = append(result, song)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+// FetchSongs returns every song, ordered by path.
+func (fs *FileStore) FetchSongs() ([]mp3util.Song, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.sortedSongs(), nil
+}
+
+func (fs *FileStore) FetchSong(path string) (*mp3util.Song, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	song, ok := fs.songs[path]
+	if !ok {
+		return nil, nil
+	}
+	return &song, nil
+}
+
+func (fs *FileStore) FetchSongsByHash(hash string) ([]mp3util.Song, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var result []mp3util.Song
+	for _, song := range fs.sortedSongs() {
+		if song.Hash == hash {
+			result = append(result, song)
+		}
+	}
+	return result, nil
+}
+
+func (fs *FileStore) FetchDuplicates() ([][]mp3util.Song, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	byHash := make(map[string][]mp3util.Song)
+	var hashes []string
+	for _, song := range fs.sortedSongs() {
+		if len(byHash[song.Hash]) == 1 {
+			hashes = append(hashes, song.Hash)
+		}
+		byHash[song.Hash] = append(byHash[song.Hash], song)
+	}
+	sort.Strings(hashes)
+
+	var result [][]mp3util.Song
+	for _, hash := range hashes {
+		result = append(result, byHash[hash])
+	}
+	return result, nil
+}
+
+func (fs *FileStore) RenamePath(from string, to string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	_, isSong := fs.songs[from]
+	_, isCached := fs.caches[from]
+	if !isSong && !isCached {
+		return nil
+	}
+	return fs.write(fileEntry{Op: "rename", Path: from, To: to})
+}
+
+func (fs *FileStore) ForgetPath(path string) (*mp3util.Song, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	song, isSong := fs.songs[path]
+	_, isCached := fs.caches[path]
+	if !isSong && !isCached {
+		return nil, nil
+	}
+	err := fs.write(fileEntry{Op: "forget", Path: path})
+	if err != nil || !isSong {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (fs *FileStore) ForgetDirectory(dir string) (int64, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	prefix := strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator)
+
+	var entries []fileEntry
+	var removed int64
+	for path := range fs.songs {
+		if strings.HasPrefix(path, prefix) {
+			entries = append(entries, fileEntry{Op: "forget", Path: path})
+			removed++
+		}
+	}
+	for path := range fs.caches {
+		if _, isSong := fs.songs[path]; !isSong && strings.HasPrefix(path, prefix) {
+			entries = append(entries, fileEntry{Op: "forget", Path: path})
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return removed, fs.write(entries...)
+}
+
+func (fs *FileStore) CacheHash(path string, hash string, fileHash string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.write(fileEntry{Op: "cache", Path: path, Cache: &CachedHash{Hash: hash, FileHash: fileHash}})
+}
+
+func (fs *FileStore) GetHashes() (map[string]CachedHash, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	result := make(map[string]CachedHash, len(fs.caches))
+	for path, cached := range fs.caches {
+		result[path] = cached
+	}
+	return result, nil
+}
+
+// RebuildAlbums does nothing, since FetchAlbums works the albums out afresh each time.
+func (fs *FileStore) RebuildAlbums() error {
+	return nil
+}
+
+func (fs *FileStore) FetchAlbums() ([]library.Album, error) {
+	songs, err := fs.FetchSongs()
+	if err != nil {
+		return nil, err
+	}
+	return library.GroupAlbums(songs), nil
+}
+
+// Import adds songs and cached hashes in a single write, so that either all of them are saved or none are.
+func (fs *FileStore) Stats(topArtists int) (Stats, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return songStats(fs.sortedSongs(), topArtists), nil
+}
+
+func (fs *FileStore) Import(songs []mp3util.Song, caches map[string]CachedHash,
+	policy ConflictPolicy) (ImportResult, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var result ImportResult
+	var entries []fileEntry
+	// later songs for the same path see earlier ones, as they would in a transaction
+	pending := make(map[string]mp3util.Song)
+	for _, song := range songs {
+		var existing *mp3util.Song
+		if found, ok := pending[song.Path]; ok {
+			existing = &found
+		} else if found, ok := fs.songs[song.Path]; ok {
+			existing = &found
+		}
+		song, ok := resolveSong(existing, song, policy, &result)
+		if !ok {
+			continue
+		}
+		pending[song.Path] = song
+		entries = append(entries, fileEntry{Op: "song", Song: &song})
+	}
+
+	for path, cached := range caches {
+		var existing *CachedHash
+		if found, ok := fs.caches[path]; ok {
+			existing = &found
+		}
+		if resolveCache(existing, cached, policy) {
+			cached := cached
+			entries = append(entries, fileEntry{Op: "cache", Path: path, Cache: &cached})
+		}
+	}
+
+	if len(entries) == 0 {
+		return result, nil
+	}
+	return result, fs.write(entries...)
+}
+
+func (fs *FileStore) FetchRoots() ([]Root, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	return fs.roots.sorted(), nil
+}
+
+func (fs *FileStore) AddRoot(name string, path string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	err := validateRoot(name, path)
+	if err != nil {
+		return err
+	}
+	if _, ok := fs.roots.find(name); ok {
+		return fmt.Errorf("there's already a root called %q; use relocate-root to move it", name)
+	}
+	return fs.write(fileEntry{Op: "root", Name: name, Path: path})
+}
+
+func (fs *FileStore) RelocateRoot(name string, path string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	err := validateRoot(name, path)
+	if err != nil {
+		return err
+	}
+	if _, ok := fs.roots.find(name); !ok {
+		return fmt.Errorf("there's no root called %q", name)
+	}
+	return fs.write(fileEntry{Op: "root", Name: name, Path: path})
+}
+
+func (fs *FileStore) FetchCollections() ([]Collection, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	counts := make(map[string]int)
+	for _, song := range fs.songs {
+		counts[song.Collection]++
+	}
+	result := make([]Collection, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, Collection{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// RenameCollection rewrites each song in the collection, in a single write.
+func (fs *FileStore) RenameCollection(from string, to string) (int64, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var entries []fileEntry
+	for _, song := range fs.sortedSongs() {
+		if song.Collection == from {
+			song := song
+			song.Collection = to
+			entries = append(entries, fileEntry{Op: "song", Song: &song})
+		}
+	}
+	if len(entries) == 0 || from == to {
+		return int64(len(entries)), nil
+	}
+	return int64(len(entries)), fs.write(entries...)
+}
+
+func (fs *FileStore) DropCollection(name string) (int64, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	var entries []fileEntry
+	for _, song := range fs.sortedSongs() {
+		if song.Collection == name {
+			entries = append(entries, fileEntry{Op: "forget", Path: song.Path})
+		}
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	return int64(len(entries)), fs.write(entries...)
+}
@@ -0,0 +1,176 @@
+package records
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+)
+
+// ConflictPolicy says what Import does with a row whose path is already in the database.
+type ConflictPolicy string
+
+const (
+	// KeepExisting leaves the existing row alone.
+	KeepExisting ConflictPolicy = "skip"
+	// Overwrite replaces the existing row with the imported one.
+	Overwrite ConflictPolicy = "overwrite"
+	// Merge keeps the existing row but fills in its empty values from the imported one
+	Merge ConflictPolicy = "merge"
+)
+
+var ConflictPolicies = []ConflictPolicy{KeepExisting, Overwrite, Merge}
+
+// ImportResult counts what Import did with each row.  Conflicts that made no difference count as Unchanged.
+type ImportResult struct {
+	Added, Updated, Skipped, Unchanged int
+}
+
+// Import adds songs and cached hashes in a single transaction, resolving paths already there with policy.
+func (rk *RecordKeeper) Import(songs []mp3util.Song, caches map[string]CachedHash,
+	policy ConflictPolicy) (ImportResult, error) {
+	var result ImportResult
+
+	tx, err := rk.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	const songStatement = `
+		INSERT OR REPLACE INTO Songs(` + songColumns + `)
+		VALUES (@Path, @Artist, @Album, @Title, @Hash, @Genre, @AlbumArtist, @TrackNumber, @TotalTracks,
+		@DiscNumber, @TotalDiscs, @FileHash, @TagFormat, @Bitrate, @SampleRate, @Duration, @Size, @Collection,
+		@ModTime, @RawGenre)
+		`
+
+	for _, song := range songs {
+		var existing *mp3util.Song
+		stored := rk.storedPath(song.Path)
+		found, err := scanSong(tx.QueryRow("SELECT "+songColumns+" FROM Songs WHERE Path = @Path", stored))
+		switch {
+		case err == nil:
+			found.Path = song.Path
+			existing = &found
+		case err != sql.ErrNoRows:
+			return result, fmt.Errorf("error reading %q:  %s", song.Path, err)
+		}
+		song, ok := resolveSong(existing, song, policy, &result)
+		if !ok {
+			continue
+		}
+
+		_, err = tx.Exec(songStatement, stored, song.Artist, song.Album, song.Title, song.Hash, song.Genre,
+			song.AlbumArtist, song.TrackNumber, song.TotalTracks, song.DiscNumber, song.TotalDiscs, song.FileHash,
+			song.TagFormat, song.Bitrate, song.SampleRate, song.Duration, song.Size, song.Collection, song.ModTime,
+			song.RawGenre)
+		if err != nil {
+			return result, fmt.Errorf("error saving %q:  %s", song.Path, err)
+		}
+	}
+
+	for path, cached := range caches {
+		var existing *CachedHash
+		var found CachedHash
+		stored := rk.storedPath(path)
+		err := tx.QueryRow("SELECT Hash, FileHash FROM Caches WHERE Path = @Path", stored).Scan(&found.Hash,
+			&found.FileHash)
+		switch {
+		case err == nil:
+			existing = &found
+		case err != sql.ErrNoRows:
+			return result, fmt.Errorf("error reading cached hash for %q:  %s", path, err)
+		}
+		if !resolveCache(existing, cached, policy) {
+			continue
+		}
+
+		_, err = tx.Exec("INSERT OR REPLACE INTO Caches(Path, Hash, FileHash) VALUES (@Path, @Hash, @FileHash)", stored,
+			cached.Hash, cached.FileHash)
+		if err != nil {
+			return result, fmt.Errorf("error saving hash for %q:  %s", path, err)
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// resolveSong decides what to save for an imported song over existing (nil if none), returning false for nothing.
+func resolveSong(existing *mp3util.Song, song mp3util.Song, policy ConflictPolicy,
+	result *ImportResult) (mp3util.Song, bool) {
+	switch {
+	case existing == nil:
+		result.Added++
+	case *existing == song:
+		result.Unchanged++
+		return song, false
+	case policy == KeepExisting:
+		result.Skipped++
+		return song, false
+	case policy == Merge:
+		song = mergeSong(*existing, song)
+		if song == *existing {
+			result.Unchanged++
+			return song, false
+		}
+		result.Updated++
+	default:
+		result.Updated++
+	}
+	return song, true
+}
+
+// resolveCache says whether an imported cached hash should replace existing; merging only fills in a FileHash.
+func resolveCache(existing *CachedHash, cached CachedHash, policy ConflictPolicy) bool {
+	switch {
+	case existing == nil:
+		return true
+	case *existing == cached || policy == KeepExisting:
+		return false
+	case policy == Merge:
+		return existing.FileHash == "" && existing.Hash == cached.Hash
+	default:
+		return true
+	}
+}
+
+// mergeSong fills in existing's empty fields from incoming; those describing the file need the same audio Hash.
+func mergeSong(existing mp3util.Song, incoming mp3util.Song) mp3util.Song {
+	sameAudio := existing.Hash != "" && existing.Hash == incoming.Hash
+
+	text := []struct{ to, from *string }{
+		{&existing.Artist, &incoming.Artist}, {&existing.Album, &incoming.Album}, {&existing.Title, &incoming.Title},
+		{&existing.Hash, &incoming.Hash}, {&existing.Genre, &incoming.Genre},
+		{&existing.AlbumArtist, &incoming.AlbumArtist}, {&existing.Collection, &incoming.Collection},
+	}
+	numbers := []struct{ to, from *int }{
+		{&existing.TrackNumber, &incoming.TrackNumber}, {&existing.TotalTracks, &incoming.TotalTracks},
+		{&existing.DiscNumber, &incoming.DiscNumber}, {&existing.TotalDiscs, &incoming.TotalDiscs},
+	}
+	if sameAudio {
+		text = append(text, []struct{ to, from *string }{
+			{&existing.FileHash, &incoming.FileHash}, {&existing.TagFormat, &incoming.TagFormat},
+		}...)
+		numbers = append(numbers, []struct{ to, from *int }{
+			{&existing.Bitrate, &incoming.Bitrate}, {&existing.SampleRate, &incoming.SampleRate},
+			{&existing.Duration, &incoming.Duration},
+		}...)
+		if existing.Size == 0 {
+			existing.Size = incoming.Size
+		}
+	}
+
+	if existing.Genre == "" {
+		existing.RawGenre = incoming.RawGenre
+	}
+	for _, f := range text {
+		if *f.to == "" {
+			*f.to = *f.from
+		}
+	}
+	for _, f := range numbers {
+		if *f.to == 0 {
+			*f.to = *f.from
+		}
+	}
+	return existing
+}
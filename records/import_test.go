@@ -0,0 +1,83 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"reflect"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	for _, c := range []struct {
+		policy   ConflictPolicy
+		expected ImportResult
+		title    string
+		size     int64
+		fileHash string
+	}{
+		{KeepExisting, ImportResult{Added: 1, Skipped: 1}, records[0].Title, 0, ""},
+		{Overwrite, ImportResult{Added: 1, Updated: 1}, "New title", 99, "new"},
+		{Merge, ImportResult{Added: 1, Updated: 1}, records[0].Title, 99, "new"},
+	} {
+		db, err := Open(fmt.Sprintf("file:import%s.db?cache=shared&mode=memory", c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = db.RecordSong(records[0])
+		_ = db.CacheHash("cached.mp3", "h", "")
+		incoming := records[0]
+		incoming.Title = "New title"
+		incoming.Size = 99
+
+		result, err := db.Import([]mp3util.Song{incoming, records[1]}, map[string]CachedHash{"cached.mp3": {"h", "new"}},
+			c.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expected {
+			t.Errorf("Wrong result for %s  \r\nExpected:  %+v  \r\nActual:  %+v", c.policy, c.expected, result)
+		}
+
+		song, _ := db.FetchSong(records[0].Path)
+		if song.Title != c.title || song.Size != c.size {
+			t.Errorf("Wrong song after %s:  %+v", c.policy, song)
+		}
+		added, _ := db.FetchSong(records[1].Path)
+		if added == nil || !reflect.DeepEqual(*added, records[1]) {
+			t.Errorf("New song wasn't imported with %s:  %+v", c.policy, added)
+		}
+		hashes, _ := db.GetHashes()
+		if hashes["cached.mp3"].FileHash != c.fileHash {
+			t.Errorf("Wrong cached file hash after %s  \r\nExpected:  %v  \r\nActual:  %v", c.policy, c.fileHash,
+				hashes["cached.mp3"].FileHash)
+		}
+
+		// importing the same rows again changes nothing
+		result, _ = db.Import([]mp3util.Song{*song}, nil, c.policy)
+		if result != (ImportResult{Unchanged: 1}) {
+			t.Errorf("Expected an unchanged row re-importing with %s:  %+v", c.policy, result)
+		}
+		_ = db.Close()
+	}
+}
+
+func TestMergeSongOnlyFillsFileFieldsForTheSameAudio(t *testing.T) {
+	existing := mp3util.Song{Path: "a.mp3", Hash: "old"}
+	incoming := mp3util.Song{Path: "a.mp3", Hash: "new", Title: "Title", FileHash: "f", Size: 99, Bitrate: 320}
+
+	merged := mergeSong(existing, incoming)
+	expected := mp3util.Song{Path: "a.mp3", Hash: "old", Title: "Title"}
+	if merged != expected {
+		t.Errorf("Values differed.  \r\nExpected:  %+v  \r\nActual:  %+v", expected, merged)
+	}
+
+	existing.Hash = "new"
+	merged = mergeSong(existing, incoming)
+	if merged != incoming {
+		t.Errorf("Values differed.  \r\nExpected:  %+v  \r\nActual:  %+v", incoming, merged)
+	}
+}
@@ -0,0 +1,214 @@
+package records
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"path/filepath"
+	"time"
+)
+
+// Run is one application of a plan; undoing it reverses every entry journaled against its RunID.
+type Run struct {
+	RunID, Description, StartedAt, UndoneAt string
+}
+
+// JournalEntry records an applied operation, with what undoing it needs in Backup (the old tag or Songs row).
+type JournalEntry struct {
+	RunID                     string
+	Seq                       int
+	Kind, Source, Destination string
+	Backup                    []byte
+}
+
+func (rk *RecordKeeper) prepareJournalTables() error {
+	const statement = `
+		CREATE TABLE IF NOT EXISTS
+		  Runs (RunID TEXT NOT NULL PRIMARY KEY, Description TEXT, StartedAt TEXT NOT NULL, UndoneAt TEXT);
+		CREATE TABLE IF NOT EXISTS
+		  Journal (RunID TEXT NOT NULL, Seq INTEGER NOT NULL, Kind TEXT NOT NULL, Source TEXT NOT NULL,
+		  Destination TEXT, Backup BLOB, PRIMARY KEY (RunID, Seq))
+    `
+
+	_, err := rk.Exec(statement)
+
+	return err
+}
+
+func (rk *RecordKeeper) StartRun(runID string, description string) error {
+	const statement = `
+		INSERT INTO Runs(RunID, Description, StartedAt) VALUES (@RunID, @Description, @StartedAt)
+		`
+
+	exc, err := rk.Prepare(statement)
+	if err != nil {
+		return err
+	}
+
+	_, err = exc.Exec(runID, description, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error starting run %q:  %s", runID, err)
+	}
+	return nil
+}
+
+func (rk *RecordKeeper) RecordJournalEntry(entry JournalEntry) error {
+	const statement = `
+		INSERT INTO Journal(RunID, Seq, Kind, Source, Destination, Backup)
+		VALUES (@RunID, @Seq, @Kind, @Source, @Destination, @Backup)
+		`
+
+	exc, err := rk.Prepare(statement)
+	if err != nil {
+		return err
+	}
+
+	_, err = exc.Exec(entry.RunID, entry.Seq, entry.Kind, entry.Source, entry.Destination, entry.Backup)
+	if err != nil {
+		return fmt.Errorf("error journaling %s of %q:  %s", entry.Kind, entry.Source, err)
+	}
+	return nil
+}
+
+func (rk *RecordKeeper) FetchRuns() ([]Run, error) {
+	var result []Run
+
+	const query = `
+		SELECT RunID, IFNULL(Description, ''), StartedAt, IFNULL(UndoneAt, '') FROM Runs ORDER BY StartedAt, RunID
+		`
+
+	rows, err := rk.Query(query)
+	if err != nil {
+		return result, fmt.Errorf("failed to get runs:  %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var run Run
+		err = rows.Scan(&run.RunID, &run.Description, &run.StartedAt, &run.UndoneAt)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, run)
+	}
+
+	return result, nil
+}
+
+func (rk *RecordKeeper) FetchRun(runID string) (Run, error) {
+	const query = `
+		SELECT RunID, IFNULL(Description, ''), StartedAt, IFNULL(UndoneAt, '') FROM Runs WHERE RunID = @RunID
+		`
+
+	var run Run
+	err := rk.QueryRow(query, runID).Scan(&run.RunID, &run.Description, &run.StartedAt, &run.UndoneAt)
+	if err == sql.ErrNoRows {
+		return run, fmt.Errorf("no run %q in the journal", runID)
+	}
+
+	return run, err
+}
+
+// FetchJournal returns the entries for a run in the order they were applied.
+func (rk *RecordKeeper) FetchJournal(runID string) ([]JournalEntry, error) {
+	var result []JournalEntry
+
+	const query = `
+		SELECT RunID, Seq, Kind, Source, IFNULL(Destination, ''), Backup FROM Journal WHERE RunID = @RunID ORDER BY Seq
+		`
+
+	rows, err := rk.Query(query, runID)
+	if err != nil {
+		return result, fmt.Errorf("failed to get journal for run %q:  %s", runID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry JournalEntry
+		err = rows.Scan(&entry.RunID, &entry.Seq, &entry.Kind, &entry.Source, &entry.Destination, &entry.Backup)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+func (rk *RecordKeeper) MarkRunUndone(runID string) error {
+	const statement = `
+		UPDATE Runs SET UndoneAt = @UndoneAt WHERE RunID = @RunID
+		`
+
+	_, err := rk.Exec(statement, time.Now().Format(time.RFC3339), runID)
+	return err
+}
+
+// RenamePath points any Songs or Caches row for one path at another, so records follow files that get moved.
+func (rk *RecordKeeper) RenamePath(from string, to string) error {
+	for _, statement := range []string{
+		"UPDATE OR REPLACE Songs SET Path = @To WHERE Path = @From",
+		"UPDATE OR REPLACE Caches SET Path = @To WHERE Path = @From",
+	} {
+		_, err := rk.Exec(statement, rk.storedPath(to), rk.storedPath(from))
+		if err != nil {
+			return fmt.Errorf("error renaming records for %q:  %s", from, err)
+		}
+	}
+	return nil
+}
+
+// ForgetPath removes any Songs or Caches row for the path, returning the song that was removed, if there was one.
+func (rk *RecordKeeper) ForgetPath(path string) (*mp3util.Song, error) {
+	song, err := rk.FetchSong(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range []string{"DELETE FROM Songs WHERE Path = @Path", "DELETE FROM Caches WHERE Path = @Path"} {
+		_, err = rk.Exec(statement, rk.storedPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("error removing records for %q:  %s", path, err)
+		}
+	}
+	return song, nil
+}
+
+// ForgetDirectory removes every Songs and Caches row for a path under dir, returning how many songs were removed.
+func (rk *RecordKeeper) ForgetDirectory(dir string) (int64, error) {
+	var removed int64
+	for _, prefix := range rk.rootTable().storedPrefixes(dir, string(filepath.Separator)) {
+		// compared with substr rather than LIKE, which ignores case
+		res, err := rk.Exec("DELETE FROM Songs WHERE substr(Path, 1, length(@Prefix)) = @Prefix", prefix)
+		if err != nil {
+			return removed, fmt.Errorf("error removing records under %q:  %s", dir, err)
+		}
+		count, err := res.RowsAffected()
+		if err != nil {
+			return removed, err
+		}
+		removed += count
+
+		_, err = rk.Exec("DELETE FROM Caches WHERE substr(Path, 1, length(@Prefix)) = @Prefix", prefix)
+		if err != nil {
+			return removed, fmt.Errorf("error removing records under %q:  %s", dir, err)
+		}
+	}
+	return removed, nil
+}
+
+// FetchSong returns the Songs row for a path, or nil if it hasn't been recorded.
+func (rk *RecordKeeper) FetchSong(path string) (*mp3util.Song, error) {
+	const query = "SELECT " + songColumns + " FROM Songs WHERE Path = @Path"
+
+	song, err := scanSong(rk.QueryRow(query, rk.storedPath(path)))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	song.Path = path
+	return &song, nil
+}
@@ -0,0 +1,144 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	db, err := Open("file:journal.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_ = db.StartRun("run1", "quarantine")
+	entries := []JournalEntry{
+		{RunID: "run1", Seq: 1, Kind: "move", Source: "a.mp3", Destination: "b.mp3"},
+		{RunID: "run1", Seq: 2, Kind: "retag", Source: "c.mp3", Backup: []byte("ID3")},
+	}
+	for _, entry := range entries {
+		err = db.RecordJournalEntry(entry)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	result, err := db.FetchJournal("run1")
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(entries, result) {
+		t.Errorf("Entries returned don't match.  \r\nExpected:  %v  \r\nActual:  %v", entries, result)
+	}
+
+	_ = db.MarkRunUndone("run1")
+	run, err := db.FetchRun("run1")
+	if err != nil || run.UndoneAt == "" || run.Description != "quarantine" {
+		t.Errorf("Run wasn't marked undone:  %+v (%v)", run, err)
+	}
+	if _, err = db.FetchRun("run2"); err == nil {
+		t.Error("Expected an error fetching a run that doesn't exist")
+	}
+}
+
+func TestRenameAndForgetPath(t *testing.T) {
+	db, err := Open("file:rename.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_ = db.RecordSong(records[0])
+	_ = db.CacheHash(records[0].Path, records[0].Hash, "")
+	_ = db.RenamePath(records[0].Path, "moved.mp3")
+
+	song, err := db.FetchSong("moved.mp3")
+	if err != nil || song == nil || song.Title != records[0].Title {
+		t.Errorf("Song wasn't renamed:  %+v (%v)", song, err)
+	}
+	hashes, _ := db.GetHashes()
+	if hashes["moved.mp3"].Hash != records[0].Hash {
+		t.Errorf("Cache wasn't renamed:  %v", hashes)
+	}
+
+	forgotten, err := db.ForgetPath("moved.mp3")
+	if err != nil || forgotten == nil {
+		t.Errorf("Expected to forget a song:  %+v (%v)", forgotten, err)
+	}
+	song, _ = db.FetchSong("moved.mp3")
+	hashes, _ = db.GetHashes()
+	if song != nil || len(hashes) != 0 {
+		t.Errorf("Records remained after forgetting:  %+v %v", song, hashes)
+	}
+}
+
+func TestForgetDirectory(t *testing.T) {
+	db, err := Open("file:forgetdir.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, path := range []string{"/music/a/1.mp3", "/music/a/sub/2.mp3", "/music/ab/3.mp3", "/music/A/4.mp3"} {
+		song := records[0]
+		song.Path = path
+		_ = db.RecordSong(song)
+		_ = db.CacheHash(path, song.Hash, "")
+	}
+
+	removed, err := db.ForgetDirectory("/music/a/")
+	if err != nil || removed != 2 {
+		t.Errorf("Wrong number of songs removed  \r\nExpected:  %v  \r\nActual:  %v (%v)", 2, removed, err)
+	}
+
+	songs, _ := db.FetchSongs()
+	var remaining []string
+	for _, song := range songs {
+		remaining = append(remaining, song.Path)
+	}
+	sort.Strings(remaining)
+	expected := []string{"/music/A/4.mp3", "/music/ab/3.mp3"}
+	if !reflect.DeepEqual(remaining, expected) {
+		t.Errorf("Wrong songs remain  \r\nExpected:  %v  \r\nActual:  %v", expected, remaining)
+	}
+	hashes, _ := db.GetHashes()
+	if len(hashes) != 2 {
+		t.Errorf("Cached hashes under the directory remain:  %v", hashes)
+	}
+}
+
+func TestJournalFollowsRelocatedRoot(t *testing.T) {
+	db, err := Open("file:journalroots.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_ = db.AddRoot("music", "/mnt/music")
+	_ = db.StartRun("run1", "quarantine")
+	_ = db.RecordJournalEntry(JournalEntry{RunID: "run1", Seq: 1, Kind: "move", Source: "/mnt/music/a.mp3",
+		Destination: "/mnt/music/.trash/a.mp3"})
+	_ = db.RecordJournalEntry(JournalEntry{RunID: "run1", Seq: 2, Kind: "retag", Source: "/elsewhere/b.mp3"})
+	err = db.RelocateRoot("music", `E:\Music`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.FetchJournal("run1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]string{{`E:\Music\a.mp3`, `E:\Music\.trash\a.mp3`}, {"/elsewhere/b.mp3", ""}}
+	var actual [][2]string
+	for _, entry := range result {
+		actual = append(actual, [2]string{entry.Source, entry.Destination})
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Unexpected journal paths  \r\nExpected:  %v  \r\nActual:  %v", expected, actual)
+	}
+}
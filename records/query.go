@@ -0,0 +1,41 @@
+package records
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/query"
+	"strings"
+)
+
+// QuerySongs returns the songs matching q, at most limit if it's positive, filtered and sorted by resolved paths.
+func (rk *RecordKeeper) QuerySongs(q query.Compiled, limit int) ([]mp3util.Song, error) {
+	var result []mp3util.Song
+
+	resolved, args := rk.rootTable().resolveSQL("Path")
+	statement := "SELECT " + songColumns + " FROM (SELECT " + resolved + " AS Path, " +
+		strings.TrimPrefix(songColumns, "Path, ") + " FROM Songs) AS Songs WHERE " + q.Where
+	if q.OrderBy != "" {
+		statement += " ORDER BY " + q.OrderBy
+	}
+	args = append(args, q.Args...)
+	if limit > 0 {
+		statement += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := rk.Query(statement, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to query songs:  %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, song)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,77 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/query"
+	"reflect"
+	"testing"
+)
+
+func TestQuerySongs(t *testing.T) {
+	db, err := Open("file:query.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, record := range records {
+		_ = db.RecordSong(record)
+	}
+
+	q, err := query.Compile("genre:pop OR artist=starpoint", "-title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.QuerySongs(q, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0].Path != records[1].Path {
+		t.Errorf("Unexpected results:  %+v", result)
+	}
+
+	result, err = db.QuerySongs(q, 1)
+	if err != nil || len(result) != 1 {
+		t.Errorf("Expected a single result with a limit, got %+v (%v)", result, err)
+	}
+}
+
+func TestQuerySongsUnderRoots(t *testing.T) {
+	db, err := Open("file:queryroots.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, path := range []string{`D:\Music\b\2.mp3`, `D:\Music\a\1.mp3`, "/other/3.mp3"} {
+		_ = db.RecordSong(mp3util.Song{Path: path, Hash: path})
+	}
+	_ = db.AddRoot("music", `D:\Music`)
+
+	for _, tc := range []struct {
+		q        string
+		expected []string
+	}{
+		{`path:"D:\Music\a"`, []string{`D:\Music\a\1.mp3`}},
+		{`path="D:\Music\b\2.mp3"`, []string{`D:\Music\b\2.mp3`}},
+		{"path:@music", nil},
+		{"", []string{"/other/3.mp3", `D:\Music\a\1.mp3`, `D:\Music\b\2.mp3`}},
+	} {
+		q, err := query.Compile(tc.q, "path")
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := db.QuerySongs(q, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, song := range result {
+			paths = append(paths, song.Path)
+		}
+		if !reflect.DeepEqual(paths, tc.expected) {
+			t.Errorf("Unexpected results for %q  \r\nExpected:  %v  \r\nActual:  %v", tc.q, tc.expected, paths)
+		}
+	}
+}
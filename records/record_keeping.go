@@ -4,20 +4,50 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/caseyjmorris/smartmp3mgr/mp3util"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type RecordKeeper struct {
 	*sql.DB
 	preparedStatementCache map[string]*sql.Stmt
+	// guards preparedStatementCache, so that a RecordKeeper can be shared between goroutines like the DB it wraps
+	cacheLock sync.Mutex
+	// the library roots that paths are stored relative to, guarded by rootsLock
+	roots     rootTable
+	rootsLock sync.RWMutex
+}
+
+// sqliteDriver is the sqlite3 driver with the functions the search index needs (see registerFunctions).
+const sqliteDriver = "sqlite3_smartmp3mgr"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{ConnectHook: registerFunctions})
 }
 
 func Open(connectionString string) (*RecordKeeper, error) {
-	db, err := sql.Open("sqlite3", connectionString)
+	if strings.HasSuffix(strings.ToLower(connectionString), ".jsonl") {
+		return nil, fmt.Errorf("%q is a file store, and this command needs an SQLite database",
+			connectionString)
+	}
+	if !sqliteBuilt {
+		return nil, fmt.Errorf("can't open %q:  this needs an SQLite database, and this build was made without cgo, "+
+			"which SQLite needs", connectionString)
+	}
+
+	// rows replaced by UPDATE OR REPLACE only fire the search index's delete trigger with recursive triggers on
+	separator := "?"
+	if strings.Contains(connectionString, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open(sqliteDriver, connectionString+separator+"_recursive_triggers=1")
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to sqlite db:  %s", err)
 	}
-	rk := &RecordKeeper{db, map[string]*sql.Stmt{}}
+	rk := &RecordKeeper{DB: db, preparedStatementCache: map[string]*sql.Stmt{}}
 
 	err = rk.prepareSongsTable()
 	if err != nil {
@@ -27,10 +57,31 @@ func Open(connectionString string) (*RecordKeeper, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error initialized Caches table:  %s", err)
 	}
+	err = rk.prepareJournalTables()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Journal tables:  %s", err)
+	}
+	err = rk.prepareAlbumsTables()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Albums tables:  %s", err)
+	}
+	err = rk.prepareSearchIndex()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing search index:  %s", err)
+	}
+	err = rk.prepareRootsTable()
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Roots table:  %s", err)
+	}
 
 	return rk, nil
 }
 
+// Sync does nothing, since SQLite has already made every committed change durable.
+func (rk *RecordKeeper) Sync() error {
+	return nil
+}
+
 func (rk *RecordKeeper) Close() error {
 	var err error
 	for _, stmt := range rk.preparedStatementCache {
@@ -47,6 +98,8 @@ func (rk *RecordKeeper) Close() error {
 }
 
 func (rk *RecordKeeper) Prepare(statement string) (*sql.Stmt, error) {
+	rk.cacheLock.Lock()
+	defer rk.cacheLock.Unlock()
 	if stmt, ok := rk.preparedStatementCache[statement]; ok {
 		return stmt, nil
 	}
@@ -60,7 +113,11 @@ func (rk *RecordKeeper) prepareSongsTable() error {
 	const statement = `
 		CREATE TABLE IF NOT EXISTS 
 		  Songs (Path TEXT NOT NULL PRIMARY KEY, Artist TEXT, Album TEXT, Title TEXT, Hash TEXT, Genre TEXT,
-		  AlbumArtist TEXT, TrackNumber INTEGER, TotalTracks INTEGER, DiscNumber INTEGER, TotalDiscs INTEGER);
+		  AlbumArtist TEXT, TrackNumber INTEGER, TotalTracks INTEGER, DiscNumber INTEGER, TotalDiscs INTEGER,
+		  FileHash TEXT NOT NULL DEFAULT '', TagFormat TEXT NOT NULL DEFAULT '',
+		  Bitrate INTEGER NOT NULL DEFAULT 0, SampleRate INTEGER NOT NULL DEFAULT 0, Duration INTEGER NOT NULL DEFAULT 0,
+		  Size INTEGER NOT NULL DEFAULT 0, Collection TEXT NOT NULL DEFAULT '', ModTime INTEGER NOT NULL DEFAULT 0,
+		  RawGenre TEXT NOT NULL DEFAULT '');
 		CREATE INDEX IF NOT EXISTS
 		  SongsHashIndex ON Songs(Hash)
     `
@@ -71,13 +128,30 @@ func (rk *RecordKeeper) prepareSongsTable() error {
 		return err
 	}
 
+	for _, column := range []struct{ name, declaration string }{
+		{"FileHash", "TEXT NOT NULL DEFAULT ''"},
+		{"TagFormat", "TEXT NOT NULL DEFAULT ''"},
+		{"Bitrate", "INTEGER NOT NULL DEFAULT 0"},
+		{"SampleRate", "INTEGER NOT NULL DEFAULT 0"},
+		{"Duration", "INTEGER NOT NULL DEFAULT 0"},
+		{"Size", "INTEGER NOT NULL DEFAULT 0"},
+		{"Collection", "TEXT NOT NULL DEFAULT ''"},
+		{"ModTime", "INTEGER NOT NULL DEFAULT 0"},
+		{"RawGenre", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		err = rk.ensureColumn("Songs", column.name, column.declaration)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func (rk *RecordKeeper) prepareCachesTable() error {
 	const statement = `
 		CREATE TABLE IF NOT EXISTS 
-		  Caches (Path TEXT NOT NULL PRIMARY KEY, Hash TEXT NOT NULL);
+		  Caches (Path TEXT NOT NULL PRIMARY KEY, Hash TEXT NOT NULL, FileHash TEXT NOT NULL DEFAULT '');
 		CREATE INDEX IF NOT EXISTS
 		  CachesHashIndex ON Caches(Hash)
     `
@@ -88,14 +162,31 @@ func (rk *RecordKeeper) prepareCachesTable() error {
 		return err
 	}
 
-	return nil
+	return rk.ensureColumn("Caches", "FileHash", "TEXT NOT NULL DEFAULT ''")
 }
 
-func (rk *RecordKeeper) CacheHash(path string, hash string) error {
+// ensureColumn adds a column that was introduced after the table was first created in an existing database.
+func (rk *RecordKeeper) ensureColumn(table string, column string, declaration string) error {
+	var count int
+	err := rk.QueryRow("SELECT COUNT(*) FROM pragma_table_info(@Table) WHERE name = @Column", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	_, err = rk.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, declaration))
+	return err
+}
+
+// CachedHash is what find-new remembers about a hashed file:  its audio hash and the hash of the whole file.
+type CachedHash struct {
+	Hash, FileHash string
+}
+
+func (rk *RecordKeeper) CacheHash(path string, hash string, fileHash string) error {
 	const statement = `
-      INSERT INTO Caches(Path, Hash)
-      VALUES (@Path, @Hash)
-      ON CONFLICT(Path) DO UPDATE SET Hash=@Hash;
+      INSERT INTO Caches(Path, Hash, FileHash)
+      VALUES (@Path, @Hash, @FileHash)
+      ON CONFLICT(Path) DO UPDATE SET Hash=@Hash, FileHash=@FileHash;
      `
 
 	exc, err := rk.Prepare(statement)
@@ -103,66 +194,113 @@ func (rk *RecordKeeper) CacheHash(path string, hash string) error {
 		return err
 	}
 
-	_, err = exc.Exec(path, hash)
+	_, err = exc.Exec(rk.storedPath(path), hash, fileHash)
 	if err != nil {
 		return fmt.Errorf("error saving hash %q for file %q:  %s", hash, path, err)
 	}
 	return nil
 }
 
-func (rk *RecordKeeper) GetHashes() (map[string]string, error) {
+func (rk *RecordKeeper) GetHashes() (map[string]CachedHash, error) {
 	const statement = `
-      SELECT Path, Hash FROM Caches
+      SELECT Path, Hash, FileHash FROM Caches
     `
 
 	rows, err := rk.Query(statement)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get hashes:  %s", err)
 	}
+	defer rows.Close()
 
-	result := make(map[string]string)
+	result := make(map[string]CachedHash)
 
 	for rows.Next() {
 		var path string
-		var hash string
-		err = rows.Scan(&path, &hash)
+		var cached CachedHash
+		err = rows.Scan(&path, &cached.Hash, &cached.FileHash)
 		if err != nil {
 			return nil, fmt.Errorf("error reading cache row:  %s", err)
 		}
-		result[path] = hash
+		result[rk.resolvedPath(path)] = cached
 	}
 
 	return result, nil
 }
 
+// songColumns are the Songs columns in the order scanSong reads them.
+const songColumns = `Path, Artist, Album, Title, Hash, Genre, AlbumArtist, TrackNumber, TotalTracks, DiscNumber,
+  TotalDiscs, FileHash, TagFormat, Bitrate, SampleRate, Duration, Size, Collection, ModTime,
+  RawGenre`
+
+// qualifiedSongColumns is songColumns with each column prefixed by a table alias.
+func qualifiedSongColumns(alias string) string {
+	columns := strings.Split(strings.Join(strings.Fields(songColumns), " "), ", ")
+	return alias + "." + strings.Join(columns, ", "+alias+".")
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSong reads songColumns, followed by any extra columns into extra.
+func scanSong(row scanner, extra ...interface{}) (mp3util.Song, error) {
+	var song mp3util.Song
+	err := row.Scan(append([]interface{}{&song.Path, &song.Artist, &song.Album, &song.Title, &song.Hash, &song.Genre,
+		&song.AlbumArtist, &song.TrackNumber, &song.TotalTracks, &song.DiscNumber, &song.TotalDiscs, &song.FileHash,
+		&song.TagFormat, &song.Bitrate, &song.SampleRate, &song.Duration, &song.Size, &song.Collection, &song.ModTime,
+		&song.RawGenre},
+		extra...)...)
+	return song, err
+}
+
+// RecordSong saves song, replacing whatever was recorded at its path, and regroups the albums it was and is part of.
 func (rk *RecordKeeper) RecordSong(song mp3util.Song) error {
 	const insertStatement = `
 		INSERT INTO Songs(Path, Artist, Album, Title, Hash, Genre, AlbumArtist, TrackNumber, TotalTracks, 
-		  DiscNumber, TotalDiscs)
+		  DiscNumber, TotalDiscs, FileHash, TagFormat, Bitrate, SampleRate, Duration, Size, Collection, ModTime,
+		  RawGenre)
 		VALUES (@Path, @Artist, @Album, @Title, @Hash, @Genre, @AlbumArtist, @TrackNumber, @TotalTracks, 
-		@DiscNumber, @TotalDiscs)
+		@DiscNumber, @TotalDiscs, @FileHash, @TagFormat, @Bitrate, @SampleRate, @Duration, @Size, @Collection,
+		@ModTime, @RawGenre)
 		ON CONFLICT(Path) DO UPDATE SET Path = @Path, Artist = @Artist, Album = @Album, Title = @Title, Hash = @Hash,
 		Genre = @Genre, AlbumArtist = @AlbumArtist, TrackNumber = @TrackNumber, TotalTracks = @TotalTracks,
-		DiscNumber = @DiscNumber, TotalDiscs = @TotalDiscs
+		DiscNumber = @DiscNumber, TotalDiscs = @TotalDiscs, FileHash = @FileHash,
+		TagFormat = @TagFormat, Bitrate = @Bitrate, SampleRate = @SampleRate, Duration = @Duration, Size = @Size,
+		Collection = @Collection, ModTime = @ModTime, RawGenre = @RawGenre
 		`
 
-	insertPrepared, err := rk.Prepare(insertStatement)
+	tx, err := rk.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = insertPrepared.Exec(song.Path, song.Artist, song.Album, song.Title, song.Hash, song.Genre,
-		song.AlbumArtist, song.TrackNumber, song.TotalTracks, song.DiscNumber, song.TotalDiscs)
-	return err
+	stored := rk.storedPath(song.Path)
+	var oldAlbum string
+	err = tx.QueryRow("SELECT Album FROM Songs WHERE Path = @Path", stored).Scan(&oldAlbum)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = tx.Exec(insertStatement, stored, song.Artist, song.Album, song.Title, song.Hash, song.Genre,
+		song.AlbumArtist, song.TrackNumber, song.TotalTracks, song.DiscNumber, song.TotalDiscs, song.FileHash,
+		song.TagFormat, song.Bitrate, song.SampleRate, song.Duration, song.Size, song.Collection, song.ModTime,
+		song.RawGenre)
+	if err != nil {
+		return err
+	}
+
+	err = rk.refreshAlbums(tx, filepath.Dir(rk.resolvedPath(stored)), oldAlbum, song.Album)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (rk *RecordKeeper) FetchSongs() ([]mp3util.Song, error) {
 	var result []mp3util.Song
 
-	const query = `
-		SELECT Path, Artist, Album, Title, Hash, Genre, AlbumArtist, TrackNumber, TotalTracks, DiscNumber, TotalDiscs 
-        FROM Songs
-		`
+	const query = "SELECT " + songColumns + " FROM Songs"
 
 	var rows *sql.Rows
 	var err error
@@ -181,14 +319,74 @@ func (rk *RecordKeeper) FetchSongs() ([]mp3util.Song, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var song mp3util.Song
-		err = rows.Scan(&song.Path, &song.Artist, &song.Album, &song.Title, &song.Hash, &song.Genre, &song.AlbumArtist,
-			&song.TrackNumber, &song.TotalTracks, &song.DiscNumber, &song.TotalDiscs)
+		song, err := scanSong(rows)
 		if err != nil {
 			return result, err
 		}
-		result = append(result, song)
+		result = append(result, rk.resolvedSong(song))
 	}
 
 	return result, nil
 }
+
+// FetchDuplicates returns every group of songs that share a Hash, ordered by hash and then path.
+func (rk *RecordKeeper) FetchDuplicates() ([][]mp3util.Song, error) {
+	var result [][]mp3util.Song
+
+	const query = "SELECT " + songColumns + `
+		FROM Songs
+		WHERE Hash IN (SELECT Hash FROM Songs GROUP BY Hash HAVING COUNT(*) > 1)
+		ORDER BY Hash, Path
+		`
+
+	rows, err := rk.Query(query)
+	if err != nil {
+		return result, fmt.Errorf("failed to get duplicates:  %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return result, err
+		}
+		if len(result) == 0 || result[len(result)-1][0].Hash != song.Hash {
+			result = append(result, nil)
+		}
+		result[len(result)-1] = append(result[len(result)-1], rk.resolvedSong(song))
+	}
+
+	// songs under roots are stored with relative paths, so the order has to be by the resolved ones
+	for _, group := range result {
+		sortSongsByPath(group)
+	}
+	return result, nil
+}
+
+// FetchSongsByHash returns every song with the given audio Hash, ordered by path.
+func (rk *RecordKeeper) FetchSongsByHash(hash string) ([]mp3util.Song, error) {
+	var result []mp3util.Song
+
+	const query = "SELECT " + songColumns + " FROM Songs WHERE Hash = @Hash ORDER BY Path"
+
+	rows, err := rk.Query(query, hash)
+	if err != nil {
+		return result, fmt.Errorf("failed to get songs with hash %q:  %s", hash, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, rk.resolvedSong(song))
+	}
+
+	sortSongsByPath(result)
+	return result, rows.Err()
+}
+
+func sortSongsByPath(songs []mp3util.Song) {
+	sort.SliceStable(songs, func(i, j int) bool { return songs[i].Path < songs[j].Path })
+}
@@ -1,7 +1,14 @@
+//go:build cgo
+// +build cgo
+
 package records
 
 import (
+	"database/sql"
+	"fmt"
 	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -94,15 +101,116 @@ func TestInsertIdempotent(t *testing.T) {
 
 func TestCacheFunctionality(t *testing.T) {
 	db, _ := Open(connectionString)
-	_ = db.CacheHash("ABC", "123")
-	_ = db.CacheHash("DEF", "456")
-	_ = db.CacheHash("ABC", "789")
+	_ = db.CacheHash("ABC", "123", "321")
+	_ = db.CacheHash("DEF", "456", "654")
+	_ = db.CacheHash("ABC", "789", "987")
 	result, _ := db.GetHashes()
-	expected := make(map[string]string)
-	expected["DEF"] = "456"
-	expected["ABC"] = "789"
+	expected := make(map[string]CachedHash)
+	expected["DEF"] = CachedHash{"456", "654"}
+	expected["ABC"] = CachedHash{"789", "987"}
 
 	if !reflect.DeepEqual(result, expected) {
 		t.Errorf("Records returned don't match.  \r\nExpected:  %v  \r\nActual:  %v", records, result)
 	}
 }
+
+func TestFetchDuplicates(t *testing.T) {
+	db, err := Open("file:duplicates.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	copied := records[0]
+	copied.Path = "c:\\Users\\Casey\\Copy.mp3"
+	for _, record := range append(records, copied) {
+		_ = db.RecordSong(record)
+	}
+
+	result, err := db.FetchDuplicates()
+	if err != nil {
+		t.Error(err)
+	}
+	expected := [][]mp3util.Song{{copied, records[0]}}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Records returned don't match.  \r\nExpected:  %v  \r\nActual:  %v", expected, result)
+	}
+}
+
+func TestFetchSongsByHash(t *testing.T) {
+	db, err := Open("file:byhash.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	copied := records[0]
+	copied.Path = "c:\\Users\\Casey\\Copy.mp3"
+	for _, record := range append(records, copied) {
+		_ = db.RecordSong(record)
+	}
+
+	result, err := db.FetchSongsByHash(records[0].Hash)
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []mp3util.Song{copied, records[0]}
+	if !reflect.DeepEqual(expected, result) {
+		t.Errorf("Records returned don't match.  \r\nExpected:  %v  \r\nActual:  %v", expected, result)
+	}
+
+	result, _ = db.FetchSongsByHash("nothing")
+	if len(result) != 0 {
+		t.Errorf("Expected no songs for an unknown hash:  %v", result)
+	}
+}
+
+func TestOpenAddsNewColumns(t *testing.T) {
+	dbf, err := ioutil.TempFile(os.TempDir(), "smartmp3mgr*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := dbf.Name()
+	_ = dbf.Close()
+	defer os.Remove(dbPath)
+
+	old, _ := sql.Open("sqlite3", dbPath)
+	_, err = old.Exec(`
+		CREATE TABLE Songs (Path TEXT NOT NULL PRIMARY KEY, Artist TEXT, Album TEXT, Title TEXT, Hash TEXT, Genre TEXT,
+		  AlbumArtist TEXT, TrackNumber INTEGER, TotalTracks INTEGER, DiscNumber INTEGER, TotalDiscs INTEGER);
+		CREATE TABLE Caches (Path TEXT NOT NULL PRIMARY KEY, Hash TEXT NOT NULL);
+		INSERT INTO Songs VALUES ('a.mp3', 'Artist', 'Album', 'Title', 'abc', 'Genre', 'Artist', 1, 2, 1, 1);
+		INSERT INTO Caches VALUES ('b.mp3', 'def');
+	`)
+	_ = old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	songs, err := db.FetchSongs()
+	if err != nil || len(songs) != 1 || songs[0].FileHash != "" {
+		t.Errorf("Old rows weren't readable:  %+v (%v)", songs, err)
+	}
+	hashes, err := db.GetHashes()
+	if err != nil || hashes["b.mp3"] != (CachedHash{Hash: "def"}) {
+		t.Errorf("Old cache rows weren't readable:  %+v (%v)", hashes, err)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	n := 0
+	testStore(t, func(t *testing.T) Store {
+		n++
+		db, err := OpenStore(fmt.Sprintf("file:store%d.db?cache=shared&mode=memory", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	})
+}
@@ -0,0 +1,330 @@
+package records
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Root is a named library folder; paths under it are stored relative to it, so it can be relocated.
+type Root struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+var rootNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// rootedPrefix starts paths stored relative to a root, as "@NAME/" and the rest with forward slashes.
+const rootedPrefix = "@"
+
+// rootTable stores and resolves paths, longest root first so that the innermost of nested roots wins.
+type rootTable []Root
+
+func newRootTable(roots []Root) rootTable {
+	t := append(rootTable(nil), roots...)
+	sort.SliceStable(t, func(i, j int) bool { return len(t[i].Path) > len(t[j].Path) })
+	return t
+}
+
+func (t rootTable) find(name string) (Root, bool) {
+	for _, root := range t {
+		if root.Name == name {
+			return root, true
+		}
+	}
+	return Root{}, false
+}
+
+// sorted returns the roots ordered by name.
+func (t rootTable) sorted() []Root {
+	result := append([]Root(nil), t...)
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// with returns a copy of the table with root added, or replacing the root of the same name.
+func (t rootTable) with(root Root) rootTable {
+	var roots []Root
+	for _, r := range t {
+		if r.Name != root.Name {
+			roots = append(roots, r)
+		}
+	}
+	return newRootTable(append(roots, root))
+}
+
+// store turns an absolute path into the form it's stored in.
+func (t rootTable) store(path string) string {
+	for _, root := range t {
+		if rest, ok := root.relative(path); ok {
+			return rootedPrefix + root.Name + rest
+		}
+	}
+	return path
+}
+
+// relative returns path after the root with forward slashes; Windows roots match either separator and any case.
+func (root Root) relative(path string) (string, bool) {
+	windows := usesBackslashes(root.Path)
+	base := strings.TrimRight(root.Path, "/")
+	if windows {
+		base = strings.TrimRight(strings.ReplaceAll(root.Path, `\`, "/"), "/")
+		path = strings.ReplaceAll(path, `\`, "/")
+	}
+	if len(path) < len(base) || (path[:len(base)] != base && !(windows && strings.EqualFold(path[:len(base)], base))) {
+		return "", false
+	}
+	rest := path[len(base):]
+	if rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+// resolve turns a stored path back into an absolute one, leaving paths under unknown roots as they are.
+func (t rootTable) resolve(stored string) string {
+	if !strings.HasPrefix(stored, rootedPrefix) {
+		return stored
+	}
+	name, rest := stored[len(rootedPrefix):], ""
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, rest = name[:i], name[i:]
+	}
+	root, ok := t.find(name)
+	if !ok {
+		return stored
+	}
+	base := strings.TrimRight(root.Path, `/\`)
+	if usesBackslashes(root.Path) {
+		rest = strings.ReplaceAll(rest, "/", `\`)
+	}
+	return base + rest
+}
+
+// storedPrefixes returns what the stored forms of paths under dir start with, using separator outside roots.
+func (t rootTable) storedPrefixes(dir string, separator string) []string {
+	stored := t.store(dir)
+	if strings.HasPrefix(stored, rootedPrefix) {
+		separator = "/"
+	}
+	result := []string{strings.TrimSuffix(stored, separator) + separator}
+
+	for _, root := range t {
+		if rest, ok := (Root{Path: dir}).relative(root.Path); ok && rest != "" {
+			result = append(result, rootedPrefix+root.Name+"/")
+		}
+	}
+	return result
+}
+
+// resolveSQL is an SQL expression resolving the stored path in column as resolve does, with its arguments.
+func (t rootTable) resolveSQL(column string) (string, []interface{}) {
+	if len(t) == 0 {
+		return column, nil
+	}
+	expr := "CASE"
+	var args []interface{}
+	for _, root := range t {
+		base := strings.TrimRight(root.Path, `/\`)
+		rest := "substr(" + column + ", ?)"
+		if usesBackslashes(root.Path) {
+			rest = "replace(" + rest + ", '/', '\\')"
+		}
+		prefix := rootedPrefix + root.Name + "/"
+		// substr counts characters, but root names are ASCII, so the prefix's are its bytes
+		expr += " WHEN " + column + " = ? THEN ? WHEN substr(" + column + ", 1, ?) = ? THEN ? || " + rest
+		args = append(args, rootedPrefix+root.Name, base, len(prefix), prefix, base, len(prefix))
+	}
+	return expr + " ELSE " + column + " END", args
+}
+
+// usesBackslashes says whether a path is a Windows one, like C:\Music or \\nas\music.
+func usesBackslashes(path string) bool {
+	if strings.Contains(path, "/") {
+		return false
+	}
+	return strings.Contains(path, `\`) || (len(path) >= 2 && path[1] == ':')
+}
+
+func validateRoot(name string, path string) error {
+	if !rootNamePattern.MatchString(name) {
+		return fmt.Errorf("%q can't be used as a root name; use letters, numbers, dots, dashes and underscores", name)
+	}
+	if path == "" {
+		return fmt.Errorf("root %q needs a path", name)
+	}
+	return nil
+}
+
+func (rk *RecordKeeper) prepareRootsTable() error {
+	const statement = `
+		CREATE TABLE IF NOT EXISTS
+		  Roots (Name TEXT NOT NULL PRIMARY KEY, Path TEXT NOT NULL)
+    `
+
+	_, err := rk.Exec(statement)
+	if err != nil {
+		return err
+	}
+	return rk.loadRoots()
+}
+
+func (rk *RecordKeeper) loadRoots() error {
+	rows, err := rk.Query("SELECT Name, Path FROM Roots")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var roots []Root
+	for rows.Next() {
+		var root Root
+		err = rows.Scan(&root.Name, &root.Path)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, root)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	rk.rootsLock.Lock()
+	rk.roots = newRootTable(roots)
+	rk.rootsLock.Unlock()
+	return nil
+}
+
+func (rk *RecordKeeper) rootTable() rootTable {
+	rk.rootsLock.RLock()
+	defer rk.rootsLock.RUnlock()
+	return rk.roots
+}
+
+func (rk *RecordKeeper) storedPath(path string) string {
+	return rk.rootTable().store(path)
+}
+
+func (rk *RecordKeeper) resolvedPath(stored string) string {
+	return rk.rootTable().resolve(stored)
+}
+
+func (rk *RecordKeeper) resolvedSong(song mp3util.Song) mp3util.Song {
+	song.Path = rk.resolvedPath(song.Path)
+	return song
+}
+
+// FetchRoots returns the library roots, ordered by name.
+func (rk *RecordKeeper) FetchRoots() ([]Root, error) {
+	return rk.rootTable().sorted(), nil
+}
+
+// AddRoot names a library folder, storing the paths already recorded under it relative to it.
+func (rk *RecordKeeper) AddRoot(name string, path string) error {
+	err := validateRoot(name, path)
+	if err != nil {
+		return err
+	}
+	old := rk.rootTable()
+	if _, ok := old.find(name); ok {
+		return fmt.Errorf("there's already a root called %q; use relocate-root to move it", name)
+	}
+	updated := old.with(Root{name, path})
+
+	return rk.changeRoots("INSERT INTO Roots(Name, Path) VALUES (@Name, @Path)", name, path, updated,
+		func(stored string) string { return updated.store(old.resolve(stored)) })
+}
+
+// RelocateRoot moves a root, and everything under it, to path, taking on its separators.
+func (rk *RecordKeeper) RelocateRoot(name string, path string) error {
+	err := validateRoot(name, path)
+	if err != nil {
+		return err
+	}
+	old := rk.rootTable()
+	if _, ok := old.find(name); !ok {
+		return fmt.Errorf("there's no root called %q", name)
+	}
+	updated := old.with(Root{name, path})
+
+	// paths under the root stay as they are; ones that weren't under any root may be under the new path
+	return rk.changeRoots("UPDATE Roots SET Path = @Path WHERE Name = @Name", name, path, updated,
+		func(stored string) string {
+			if strings.HasPrefix(stored, rootedPrefix) {
+				return stored
+			}
+			return updated.store(stored)
+		})
+}
+
+// changeRoots runs statement and moves every stored and journalled path in one transaction, then rebuilds albums.
+func (rk *RecordKeeper) changeRoots(statement string, name string, path string, updated rootTable,
+	restore func(stored string) string) error {
+	old := rk.rootTable()
+	tx, err := rk.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(statement, sql.Named("Name", name), sql.Named("Path", path))
+	if err != nil {
+		return fmt.Errorf("error saving root %q:  %s", name, err)
+	}
+
+	for _, table := range []string{"Songs", "Caches", "AlbumTracks"} {
+		err = restorePaths(tx, table, "Path", restore)
+		if err != nil {
+			return fmt.Errorf("error updating paths in %s:  %s", table, err)
+		}
+	}
+	for _, column := range []string{"Source", "Destination"} {
+		err = restorePaths(tx, "Journal", column, func(path string) string { return updated.resolve(old.store(path)) })
+		if err != nil {
+			return fmt.Errorf("error updating paths in Journal:  %s", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	rk.rootsLock.Lock()
+	rk.roots = updated
+	rk.rootsLock.Unlock()
+	return rk.RebuildAlbums()
+}
+
+func restorePaths(tx *sql.Tx, table string, column string, restore func(stored string) string) error {
+	rows, err := tx.Query("SELECT DISTINCT " + column + " FROM " + table + " WHERE " + column + " IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	changes := make(map[string]string)
+	for rows.Next() {
+		var stored string
+		err = rows.Scan(&stored)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if restored := restore(stored); restored != stored {
+			changes[stored] = restored
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for from, to := range changes {
+		_, err = tx.Exec("UPDATE OR REPLACE "+table+" SET "+column+" = @To WHERE "+column+" = @From", to, from)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
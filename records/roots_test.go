@@ -0,0 +1,64 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRootTable(t *testing.T) {
+	roots := newRootTable([]Root{{"music", "/mnt/music"}, {"live", "/mnt/music/live/"}, {"win", `D:\Music`}})
+	for _, tc := range []struct{ path, stored, resolved string }{
+		{"/mnt/music/a/1.mp3", "@music/a/1.mp3", "/mnt/music/a/1.mp3"},
+		{"/mnt/music/live/1.mp3", "@live/1.mp3", "/mnt/music/live/1.mp3"},
+		{"/mnt/musical/1.mp3", "/mnt/musical/1.mp3", "/mnt/musical/1.mp3"},
+		{`D:\Music\a\1.mp3`, "@win/a/1.mp3", `D:\Music\a\1.mp3`},
+		{"D:/Music/a/1.mp3", "@win/a/1.mp3", `D:\Music\a\1.mp3`},
+		{"/mnt/music", "@music", "/mnt/music"},
+		{`/mnt/music/AC\DC/1.mp3`, `@music/AC\DC/1.mp3`, `/mnt/music/AC\DC/1.mp3`},
+		{`/MNT/music/1.mp3`, `/MNT/music/1.mp3`, `/MNT/music/1.mp3`},
+		{`d:\music\a\1.mp3`, "@win/a/1.mp3", `D:\Music\a\1.mp3`},
+	} {
+		if stored := roots.store(tc.path); stored != tc.stored {
+			t.Errorf("Unexpected stored path for %q  \r\nExpected:  %v  \r\nActual:  %v", tc.path, tc.stored, stored)
+		}
+		if resolved := roots.resolve(tc.stored); resolved != tc.resolved {
+			t.Errorf("Unexpected resolved path  \r\nExpected:  %v  \r\nActual:  %v", tc.resolved, resolved)
+		}
+	}
+	if resolved := roots.resolve("@gone/1.mp3"); resolved != "@gone/1.mp3" {
+		t.Errorf("Unexpected resolved path  \r\nExpected:  %v  \r\nActual:  %v", "@gone/1.mp3", resolved)
+	}
+}
+
+func TestFileStoreRootsReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.jsonl")
+
+	db, _ := OpenFileStore(path)
+	_ = db.AddRoot("music", `D:\Music`)
+	_ = db.CacheHash(`D:\Music\a\1.mp3`, "h1", "f1")
+	_ = db.RelocateRoot("music", "/mnt/music")
+	_ = db.Close()
+
+	data, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(data), `"path":"@music/a/1.mp3"`) {
+		t.Errorf("Unexpected file  \r\nExpected:  %v  \r\nActual:  %v", "a path relative to the root", string(data))
+	}
+
+	db, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	hashes, _ := db.GetHashes()
+	if _, ok := hashes["/mnt/music/a/1.mp3"]; !ok || len(hashes) != 1 {
+		t.Errorf("Unexpected hashes  \r\nExpected:  %v  \r\nActual:  %v", "/mnt/music/a/1.mp3", hashes)
+	}
+}
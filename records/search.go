@@ -0,0 +1,111 @@
+package records
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"strings"
+	"unicode"
+)
+
+// SearchResult is a song found by Search; a higher Score is a better match.
+type SearchResult struct {
+	Song  mp3util.Song
+	Score float64
+}
+
+// The full-text index (SongsFTS5, or SongsFTS4 without the sqlite_fts5 tag) mirrors these columns without accents.
+// It's keyed by SearchKeys, since vacuuming can renumber the rowids of Songs.
+const ftsColumns = "Artist, AlbumArtist, Album, Title, Genre"
+
+func (rk *RecordKeeper) prepareSearchIndex() error {
+	// drop triggers from before SearchKeys, and any that a build with the other FTS module left behind
+	for _, trigger := range []string{"Insert", "Update", "Delete"} {
+		for _, name := range []string{ftsTable + trigger, otherFtsTable + trigger, otherFtsTable + "Path" + trigger} {
+			_, err := rk.Exec("DROP TRIGGER IF EXISTS " + name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	const unindex = `
+		  DELETE FROM %[1]s WHERE rowid = (SELECT ID FROM SearchKeys WHERE Path = %[3]s.Path);
+		  DELETE FROM SearchKeys WHERE Path = %[3]s.Path;`
+	const index = `
+		  DELETE FROM %[1]s WHERE rowid = (SELECT ID FROM SearchKeys WHERE Path = new.Path);
+		  INSERT OR IGNORE INTO SearchKeys(Path) VALUES (new.Path);
+		  INSERT INTO %[1]s(rowid, %[2]s)
+		  VALUES ((SELECT ID FROM SearchKeys WHERE Path = new.Path), new.Artist, new.AlbumArtist, new.Album, new.Title,
+		    new.Genre);`
+	statements := []string{
+		ftsCreate,
+		"CREATE TABLE IF NOT EXISTS SearchKeys (ID INTEGER PRIMARY KEY, Path TEXT NOT NULL UNIQUE)",
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]sPathInsert AFTER INSERT ON Songs BEGIN"+index+"\n\t\tEND",
+			ftsTable, ftsColumns),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]sPathUpdate AFTER UPDATE ON Songs BEGIN"+unindex+index+"\n\t\tEND",
+			ftsTable, ftsColumns, "old"),
+		fmt.Sprintf("CREATE TRIGGER IF NOT EXISTS %[1]sPathDelete AFTER DELETE ON Songs BEGIN"+unindex+"\n\t\tEND",
+			ftsTable, ftsColumns, "old"),
+	}
+	for _, statement := range statements {
+		_, err := rk.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+
+	// databases from before the index existed, or last written by a build using the other module, need filling in
+	var songs, keys, indexed int
+	const count = "SELECT (SELECT COUNT(*) FROM Songs), (SELECT COUNT(*) FROM SearchKeys), (SELECT COUNT(*) FROM " +
+		ftsTable + ")"
+	err := rk.QueryRow(count).Scan(&songs, &keys, &indexed)
+	if err != nil || (songs == keys && songs == indexed) {
+		return err
+	}
+	return rk.RebuildSearchIndex()
+}
+
+// RebuildSearchIndex refills the full-text index from Songs.
+func (rk *RecordKeeper) RebuildSearchIndex() error {
+	tx, err := rk.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range []string{
+		"DELETE FROM " + ftsTable,
+		"DELETE FROM SearchKeys",
+		"INSERT INTO SearchKeys(Path) SELECT Path FROM Songs",
+		"INSERT INTO " + ftsTable + "(rowid, " + ftsColumns + ") SELECT k.ID, " + ftsColumns +
+			" FROM Songs JOIN SearchKeys k ON k.Path = Songs.Path",
+	} {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			return fmt.Errorf("error rebuilding search index:  %s", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Search finds songs with words starting with each word of text, best first, at most limit if it's positive.
+func (rk *RecordKeeper) Search(text string, limit int) ([]SearchResult, error) {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		terms = append(terms, ftsPrefixTerm(word))
+	}
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	results, err := rk.search(strings.Join(terms, " "), limit)
+	if err != nil {
+		return results, fmt.Errorf("failed to search for %q:  %s", text, err)
+	}
+	for i := range results {
+		results[i].Song = rk.resolvedSong(results[i].Song)
+	}
+	return results, nil
+}
@@ -0,0 +1,104 @@
+//go:build !sqlite_fts5
+// +build !sqlite_fts5
+
+package records
+
+import (
+	"encoding/binary"
+	"github.com/mattn/go-sqlite3"
+	"math"
+	"unsafe"
+)
+
+const ftsTable = "SongsFTS4"
+const otherFtsTable = "SongsFTS5"
+
+const ftsCreate = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS
+	  SongsFTS4 USING fts4(Artist, AlbumArtist, Album, Title, Genre, tokenize=unicode61 "remove_diacritics=1")
+	`
+
+// column weights, as for bm25 in the FTS5 build
+var ftsWeights = []float64{2.0, 1.5, 1.0, 2.0, 0.5}
+
+func ftsPrefixTerm(word string) string {
+	return `"` + word + `*"`
+}
+
+// registerFunctions adds match_score, which ranks FTS4 matches, since FTS4 has no built-in ranking function.
+func registerFunctions(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("match_score", matchScore, true)
+}
+
+// search ranks by a weighted tf-idf computed from matchinfo by match_score, so that only the best matches are read.
+func (rk *RecordKeeper) search(match string, limit int) ([]SearchResult, error) {
+	var result []SearchResult
+
+	const query = "SELECT " + songColumns + `, m.Score
+		FROM (
+		  SELECT k.Path AS Key, match_score(matchinfo(SongsFTS4, 'pcnx')) AS Score
+		  FROM SongsFTS4 JOIN SearchKeys k ON k.ID = SongsFTS4.rowid WHERE SongsFTS4 MATCH @Match
+		) m JOIN Songs ON Songs.Path = m.Key
+		ORDER BY m.Score DESC, Songs.Path
+		LIMIT @Limit
+		`
+
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := rk.Query(query, match, limit)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		r.Song, err = scanSong(rows, &r.Score)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// nativeEndian is the machine's byte order, which matchinfo uses.
+var nativeEndian = func() binary.ByteOrder {
+	probe := uint16(1)
+	if *(*byte)(unsafe.Pointer(&probe)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// matchScore reads matchinfo's 'pcnx' output:  phrase count, column count, row count, then for each phrase and column,
+// hits in this row, hits in all rows and rows with a hit.
+func matchScore(info []byte) float64 {
+	values := make([]uint32, len(info)/4)
+	for i := range values {
+		values[i] = nativeEndian.Uint32(info[i*4:])
+	}
+	if len(values) < 3 {
+		return 0
+	}
+	phrases, columns, rows := int(values[0]), int(values[1]), float64(values[2])
+
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns; c++ {
+			i := 3 + 3*(p*columns+c)
+			if i+2 >= len(values) || values[i] == 0 {
+				continue
+			}
+			idf := math.Log(1 + rows/float64(values[i+2]))
+			weight := 1.0
+			if c < len(ftsWeights) {
+				weight = ftsWeights[c]
+			}
+			score += weight * float64(values[i]) * idf
+		}
+	}
+	return score
+}
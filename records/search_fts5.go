@@ -0,0 +1,57 @@
+//go:build sqlite_fts5
+// +build sqlite_fts5
+
+package records
+
+import "github.com/mattn/go-sqlite3"
+
+const ftsTable = "SongsFTS5"
+const otherFtsTable = "SongsFTS4"
+
+const ftsCreate = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS
+	  SongsFTS5 USING fts5(Artist, AlbumArtist, Album, Title, Genre, tokenize = 'unicode61 remove_diacritics 2')
+	`
+
+func ftsPrefixTerm(word string) string {
+	return `"` + word + `"*`
+}
+
+// registerFunctions adds nothing, since FTS5 ranks with bm25.
+func registerFunctions(*sqlite3.SQLiteConn) error {
+	return nil
+}
+
+func (rk *RecordKeeper) search(match string, limit int) ([]SearchResult, error) {
+	var result []SearchResult
+
+	// bm25 is lower for better matches; titles and artists count for more than albums and genres
+	const query = "SELECT " + songColumns + `, -m.Score
+		FROM (
+		  SELECT k.Path AS Key, bm25(SongsFTS5, 2.0, 1.5, 1.0, 2.0, 0.5) AS Score
+		  FROM SongsFTS5 JOIN SearchKeys k ON k.ID = SongsFTS5.rowid WHERE SongsFTS5 MATCH @Match
+		) m JOIN Songs ON Songs.Path = m.Key
+		ORDER BY m.Score, Songs.Path
+		LIMIT @Limit
+		`
+
+	if limit <= 0 {
+		limit = -1
+	}
+	rows, err := rk.Query(query, match, limit)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r SearchResult
+		r.Song, err = scanSong(rows, &r.Score)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
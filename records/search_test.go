@@ -0,0 +1,104 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	db, err := Open("file:search.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songs := []mp3util.Song{
+		{Path: "1.mp3", Artist: "Beyoncé", Title: "Halo", Album: "I Am... Sasha Fierce", Hash: "1"},
+		{Path: "2.mp3", Artist: "Halo Benders", Title: "Don't Touch My Bikini", Album: "God Don't Make No Junk",
+			Hash: "2"},
+		{Path: "3.mp3", Artist: "Someone", Title: "Something", Album: "Beyonce Covers", Hash: "3"},
+	}
+	for _, song := range songs {
+		_ = db.RecordSong(song)
+	}
+
+	results, err := db.Search("beyonce halo", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Song.Path != "1.mp3" {
+		t.Errorf("Expected only 1.mp3 for an unaccented search, got %+v", results)
+	}
+
+	results, err = db.Search("bey", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Song.Path != "1.mp3" {
+		t.Errorf("Expected a prefix match on both, artist first, got %+v", results)
+	}
+	results, _ = db.Search("bey", 1)
+	if len(results) != 1 || results[0].Song.Path != "1.mp3" {
+		t.Errorf("Expected only the best match with a limit, got %+v", results)
+	}
+
+	// the index follows renames, including ones that replace an existing row, and deletes
+	_ = db.RenamePath("1.mp3", "3.mp3")
+	_, _ = db.ForgetPath("2.mp3")
+	results, _ = db.Search("halo", 0)
+	if len(results) != 1 || results[0].Song.Path != "3.mp3" {
+		t.Errorf("Index wasn't kept in sync:  %+v", results)
+	}
+	var indexed int
+	_ = db.QueryRow("SELECT COUNT(*) FROM " + ftsTable).Scan(&indexed)
+	if indexed != 1 {
+		t.Errorf("Expected 1 row left in the index, found %d", indexed)
+	}
+}
+
+func TestSearchAfterRowidsChange(t *testing.T) {
+	db, err := Open("file:searchvacuum.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, song := range []mp3util.Song{
+		{Path: "1.mp3", Title: "First", Hash: "1"},
+		{Path: "2.mp3", Title: "Second", Hash: "2"},
+		{Path: "3.mp3", Title: "Third", Hash: "3"},
+	} {
+		_ = db.RecordSong(song)
+	}
+	_, _ = db.ForgetPath("1.mp3")
+
+	// vacuuming, or dumping and reloading, can renumber the rowids of Songs, which has a TEXT primary key; this
+	// reloads it in reverse, as a dump would if it didn't keep them
+	var schema string
+	err = db.QueryRow("SELECT sql FROM sqlite_master WHERE name = 'Songs'").Scan(&schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, statement := range []string{
+		"ALTER TABLE Songs RENAME TO SongsBefore",
+		schema,
+		"INSERT INTO Songs SELECT * FROM SongsBefore ORDER BY Path DESC",
+		"DROP TABLE SongsBefore",
+	} {
+		_, err = db.Exec(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := db.Search("third", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Song.Path != "3.mp3" {
+		t.Errorf("Unexpected results after renumbering  \r\nExpected:  %v  \r\nActual:  %+v", "3.mp3", results)
+	}
+}
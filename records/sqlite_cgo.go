@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+// sqliteBuilt says whether this build can open SQLite databases, which needs cgo.
+const sqliteBuilt = true
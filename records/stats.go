@@ -0,0 +1,200 @@
+package records
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"sort"
+	"strconv"
+)
+
+// Count is how many songs have a particular value (an artist, a bitrate, an ID3 version...).
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Stats summarises the songs, in bytes and seconds; ReclaimableBytes keeps the largest of each set of duplicates.
+type Stats struct {
+	Files            int     `json:"files"`
+	Albums           int     `json:"albums"`
+	Artists          int     `json:"artists"`
+	Genres           int     `json:"genres"`
+	TotalSize        int64   `json:"totalSize"`
+	TotalDuration    int64   `json:"totalDuration"`
+	Untagged         int     `json:"untagged"`
+	DuplicateFiles   int     `json:"duplicateFiles"`
+	ReclaimableBytes int64   `json:"reclaimableBytes"`
+	TopArtists       []Count `json:"topArtists"`
+	TagFormats       []Count `json:"tagFormats"`
+	Bitrates         []Count `json:"bitrates"`
+	SampleRates      []Count `json:"sampleRates"`
+}
+
+// Stats computes library statistics, listing the topArtists artists with the most tracks.
+func (rk *RecordKeeper) Stats(topArtists int) (Stats, error) {
+	var stats Stats
+
+	const totals = `
+		SELECT COUNT(*), IFNULL(SUM(Size), 0), IFNULL(SUM(Duration), 0),
+		  (SELECT COUNT(*) FROM (
+		    SELECT DISTINCT IFNULL(NULLIF(AlbumArtist, ''), Artist), Album FROM Songs WHERE IFNULL(Album, '') != '')),
+		  (SELECT COUNT(DISTINCT Artist) FROM Songs WHERE IFNULL(Artist, '') != ''),
+		  (SELECT COUNT(DISTINCT Genre) FROM Songs WHERE IFNULL(Genre, '') != ''),
+		  (SELECT COUNT(*) FROM Songs
+		    WHERE IFNULL(Title, '') = '' AND IFNULL(Artist, '') = '' AND IFNULL(Album, '') = '')
+		FROM Songs
+		`
+
+	err := rk.QueryRow(totals).Scan(&stats.Files, &stats.TotalSize, &stats.TotalDuration, &stats.Albums,
+		&stats.Artists, &stats.Genres, &stats.Untagged)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count songs:  %s", err)
+	}
+
+	const duplicates = `
+		SELECT IFNULL(SUM(Copies - 1), 0), IFNULL(SUM(TotalSize - LargestSize), 0)
+		FROM (SELECT COUNT(*) AS Copies, SUM(Size) AS TotalSize, MAX(Size) AS LargestSize FROM Songs GROUP BY Hash
+		  HAVING COUNT(*) > 1)
+		`
+
+	err = rk.QueryRow(duplicates).Scan(&stats.DuplicateFiles, &stats.ReclaimableBytes)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count duplicates:  %s", err)
+	}
+
+	distributions := []struct {
+		result *[]Count
+		query  string
+		args   []interface{}
+	}{
+		{&stats.TopArtists, `
+			SELECT Artist, COUNT(*) FROM Songs WHERE IFNULL(Artist, '') != ''
+			GROUP BY Artist ORDER BY COUNT(*) DESC, Artist LIMIT @Limit`, []interface{}{topArtists}},
+		{&stats.TagFormats, `
+			SELECT IFNULL(NULLIF(TagFormat, ''), 'none'), COUNT(*) FROM Songs GROUP BY 1 ORDER BY COUNT(*) DESC, 1`, nil},
+		{&stats.Bitrates, `
+			SELECT Bitrate, COUNT(*) FROM Songs GROUP BY Bitrate ORDER BY Bitrate`, nil},
+		{&stats.SampleRates, `
+			SELECT SampleRate, COUNT(*) FROM Songs GROUP BY SampleRate ORDER BY SampleRate`, nil},
+	}
+	for _, d := range distributions {
+		*d.result, err = rk.fetchCounts(d.query, d.args...)
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	return stats, nil
+}
+
+func (rk *RecordKeeper) fetchCounts(query string, args ...interface{}) ([]Count, error) {
+	result := []Count{}
+
+	rows, err := rk.Query(query, args...)
+	if err != nil {
+		return result, fmt.Errorf("failed to get statistics:  %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c Count
+		err = rows.Scan(&c.Value, &c.Count)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// songStats computes what Stats does from songs in memory, for stores without SQL.
+func songStats(songs []mp3util.Song, topArtists int) Stats {
+	stats := Stats{Files: len(songs)}
+	type album struct{ artist, album string }
+	albums := make(map[album]bool)
+	artists, genres, tagFormats := make(map[string]int), make(map[string]int), make(map[string]int)
+	bitrates, sampleRates := make(map[int]int), make(map[int]int)
+	byHash := make(map[string][]int64)
+	for _, song := range songs {
+		stats.TotalSize += song.Size
+		stats.TotalDuration += int64(song.Duration)
+		if song.Album != "" {
+			artist := song.AlbumArtist
+			if artist == "" {
+				artist = song.Artist
+			}
+			albums[album{artist, song.Album}] = true
+		}
+		if song.Artist != "" {
+			artists[song.Artist]++
+		}
+		if song.Genre != "" {
+			genres[song.Genre]++
+		}
+		if song.Title == "" && song.Artist == "" && song.Album == "" {
+			stats.Untagged++
+		}
+		tagFormat := song.TagFormat
+		if tagFormat == "" {
+			tagFormat = "none"
+		}
+		tagFormats[tagFormat]++
+		bitrates[song.Bitrate]++
+		sampleRates[song.SampleRate]++
+		byHash[song.Hash] = append(byHash[song.Hash], song.Size)
+	}
+	stats.Albums, stats.Artists, stats.Genres = len(albums), len(artists), len(genres)
+
+	for _, sizes := range byHash {
+		if len(sizes) < 2 {
+			continue
+		}
+		var total, largest int64
+		for _, size := range sizes {
+			total += size
+			if size > largest {
+				largest = size
+			}
+		}
+		stats.DuplicateFiles += len(sizes) - 1
+		stats.ReclaimableBytes += total - largest
+	}
+
+	stats.TopArtists = byCount(artists)
+	if topArtists >= 0 && len(stats.TopArtists) > topArtists {
+		stats.TopArtists = stats.TopArtists[:topArtists]
+	}
+	stats.TagFormats = byCount(tagFormats)
+	stats.Bitrates = byNumber(bitrates)
+	stats.SampleRates = byNumber(sampleRates)
+	return stats
+}
+
+// byCount orders counts from most to fewest songs, and then by value.
+func byCount(counts map[string]int) []Count {
+	result := []Count{}
+	for value, count := range counts {
+		result = append(result, Count{value, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+func byNumber(counts map[int]int) []Count {
+	var numbers []int
+	for n := range counts {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	result := []Count{}
+	for _, n := range numbers {
+		result = append(result, Count{strconv.Itoa(n), counts[n]})
+	}
+	return result
+}
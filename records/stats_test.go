@@ -0,0 +1,51 @@
+//go:build cgo
+// +build cgo
+
+package records
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	db, err := Open("file:stats.db?cache=shared&mode=memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	copied := records[0]
+	copied.Path = "c:\\Users\\Casey\\Copy.mp3"
+	copied.Size = 90
+	first := records[0]
+	first.Size, first.Duration, first.Bitrate, first.SampleRate, first.TagFormat = 100, 200, 320, 44100, "ID3v2.3"
+	second := records[1]
+	second.Size, second.Duration, second.Bitrate, second.SampleRate = 50, 60, 128, 44100
+	for _, song := range []mp3util.Song{first, second, copied} {
+		_ = db.RecordSong(song)
+	}
+
+	stats, err := db.Stats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Stats{
+		Files:            3,
+		Albums:           2,
+		Artists:          2,
+		Genres:           2,
+		TotalSize:        240,
+		TotalDuration:    260,
+		DuplicateFiles:   1,
+		ReclaimableBytes: 90,
+		TopArtists:       []Count{{"Starpoint", 2}},
+		TagFormats:       []Count{{"none", 2}, {"ID3v2.3", 1}},
+		Bitrates:         []Count{{"0", 1}, {"128", 1}, {"320", 1}},
+		SampleRates:      []Count{{"0", 1}, {"44100", 2}},
+	}
+	if !reflect.DeepEqual(expected, stats) {
+		t.Errorf("Values differed.  \r\nExpected:  %+v  \r\nActual:  %+v", expected, stats)
+	}
+}
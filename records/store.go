@@ -0,0 +1,67 @@
+package records
+
+import (
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"strings"
+)
+
+// Store is what recording and finding songs needs; queries, search and the plan journal are only on RecordKeeper.
+type Store interface {
+	// RecordSong adds a song, or replaces the one at the same path.
+	RecordSong(song mp3util.Song) error
+	FetchSongs() ([]mp3util.Song, error)
+	// FetchSong returns the song at a path, or nil if there isn't one.
+	FetchSong(path string) (*mp3util.Song, error)
+	// FetchSongsByHash returns every song with an audio hash, ordered by path.
+	FetchSongsByHash(hash string) ([]mp3util.Song, error)
+	// FetchDuplicates returns every group of songs that share a Hash, ordered by hash and then path.
+	FetchDuplicates() ([][]mp3util.Song, error)
+	// RenamePath points the song and cached hashes for one path at another.
+	RenamePath(from string, to string) error
+	// ForgetPath removes the song and cached hashes for a path, returning the song, if there was one.
+	ForgetPath(path string) (*mp3util.Song, error)
+	// ForgetDirectory removes every song and cached hash under a directory, returning how many songs were removed.
+	ForgetDirectory(dir string) (int64, error)
+
+	CacheHash(path string, hash string, fileHash string) error
+	GetHashes() (map[string]CachedHash, error)
+
+	// RebuildAlbums brings what FetchAlbums returns up to date with the songs.
+	RebuildAlbums() error
+	FetchAlbums() ([]library.Album, error)
+
+	// Stats computes library statistics, listing the topArtists artists with the most tracks.
+	Stats(topArtists int) (Stats, error)
+
+	// Import adds songs and cached hashes all at once, resolving paths that are already there with policy.
+	Import(songs []mp3util.Song, caches map[string]CachedHash, policy ConflictPolicy) (ImportResult, error)
+
+	// FetchRoots returns the library roots, ordered by name.
+	FetchRoots() ([]Root, error)
+	// AddRoot names a library folder, which the paths of songs and cached hashes under it are stored relative to.
+	AddRoot(name string, path string) error
+	// RelocateRoot moves a root, and everything recorded under it, to a new path.
+	RelocateRoot(name string, path string) error
+
+	// FetchCollections returns the collections songs are in, ordered by name.
+	FetchCollections() ([]Collection, error)
+	// RenameCollection moves every song in one collection into another, returning how many were moved.
+	RenameCollection(from string, to string) (int64, error)
+	// DropCollection forgets every song in a collection and its cached hashes, returning how many songs were forgotten.
+	DropCollection(name string) (int64, error)
+
+	// Sync makes sure everything written so far would survive a crash.
+	Sync() error
+	Close() error
+}
+
+var _ Store = (*RecordKeeper)(nil)
+
+// OpenStore opens a FileStore if path ends in .jsonl, and an SQLite database otherwise.
+func OpenStore(path string) (Store, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".jsonl") {
+		return OpenFileStore(path)
+	}
+	return Open(path)
+}
@@ -0,0 +1,358 @@
+package records
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testStore checks the behavior every Store has to share, against stores from open, each of which starts empty.
+func testStore(t *testing.T, open func(t *testing.T) Store) {
+	song := func(path string, hash string) mp3util.Song {
+		return mp3util.Song{Path: path, Artist: "Starpoint", AlbumArtist: "Starpoint", Album: "Restless",
+			Title: filepath.Base(path), Hash: hash, TrackNumber: len(path), Size: 100}
+	}
+	a, b, c := song("/music/a/1.mp3", "h1"), song("/music/a/2.mp3", "h2"), song("/music/b/3.mp3", "h1")
+
+	t.Run("RecordAndFetch", func(t *testing.T) {
+		db := open(t)
+		for _, s := range []mp3util.Song{c, a, b, a} {
+			if err := db.RecordSong(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		songs, _ := db.FetchSongs()
+		if len(songs) != 3 {
+			t.Errorf("Unexpected songs  \r\nExpected:  %v  \r\nActual:  %v", 3, len(songs))
+		}
+		found, _ := db.FetchSong(b.Path)
+		if found == nil || *found != b {
+			t.Errorf("Unexpected song  \r\nExpected:  %v  \r\nActual:  %v", b, found)
+		}
+		if missing, _ := db.FetchSong("/nowhere.mp3"); missing != nil {
+			t.Errorf("Unexpected song  \r\nExpected:  %v  \r\nActual:  %v", nil, missing)
+		}
+		byHash, _ := db.FetchSongsByHash("h1")
+		if !reflect.DeepEqual(byHash, []mp3util.Song{a, c}) {
+			t.Errorf("Unexpected songs by hash  \r\nExpected:  %v  \r\nActual:  %v", []mp3util.Song{a, c}, byHash)
+		}
+		dupes, _ := db.FetchDuplicates()
+		if !reflect.DeepEqual(dupes, [][]mp3util.Song{{a, c}}) {
+			t.Errorf("Unexpected duplicates  \r\nExpected:  %v  \r\nActual:  %v", [][]mp3util.Song{{a, c}}, dupes)
+		}
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		db := open(t)
+		untagged := mp3util.Song{Path: "/music/c/4.mp3", Hash: "h4", Bitrate: 320, SampleRate: 44100,
+			TagFormat: "ID3v2.3"}
+		for _, s := range []mp3util.Song{a, b, c, untagged} {
+			_ = db.RecordSong(s)
+		}
+		stats, err := db.Stats(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := Stats{Files: 4, Albums: 1, Artists: 1, TotalSize: 300, Untagged: 1, DuplicateFiles: 1,
+			ReclaimableBytes: 100, TopArtists: []Count{{"Starpoint", 3}},
+			TagFormats: []Count{{"none", 3}, {"ID3v2.3", 1}}, Bitrates: []Count{{"0", 3}, {"320", 1}},
+			SampleRates: []Count{{"0", 3}, {"44100", 1}}}
+		if !reflect.DeepEqual(expected, stats) {
+			t.Errorf("Unexpected stats  \r\nExpected:  %+v  \r\nActual:  %+v", expected, stats)
+		}
+	})
+
+	t.Run("Caches", func(t *testing.T) {
+		db := open(t)
+		_ = db.CacheHash("/music/a/1.mp3", "h1", "f1")
+		_ = db.CacheHash("/music/a/1.mp3", "h1", "f2")
+		_ = db.CacheHash("/music/b/3.mp3", "h3", "f3")
+		hashes, _ := db.GetHashes()
+		expected := map[string]CachedHash{"/music/a/1.mp3": {"h1", "f2"}, "/music/b/3.mp3": {"h3", "f3"}}
+		if !reflect.DeepEqual(hashes, expected) {
+			t.Errorf("Unexpected hashes  \r\nExpected:  %v  \r\nActual:  %v", expected, hashes)
+		}
+	})
+
+	t.Run("RenameAndForget", func(t *testing.T) {
+		db := open(t)
+		for _, s := range []mp3util.Song{a, b, c} {
+			_ = db.RecordSong(s)
+			_ = db.CacheHash(s.Path, s.Hash, "")
+		}
+
+		if err := db.RenamePath(a.Path, "/music/c/1.mp3"); err != nil {
+			t.Fatal(err)
+		}
+		moved, _ := db.FetchSong("/music/c/1.mp3")
+		if moved == nil || moved.Title != a.Title {
+			t.Errorf("Unexpected renamed song  \r\nExpected:  %v  \r\nActual:  %v", a.Title, moved)
+		}
+
+		forgotten, _ := db.ForgetPath(b.Path)
+		if forgotten == nil || *forgotten != b {
+			t.Errorf("Unexpected forgotten song  \r\nExpected:  %v  \r\nActual:  %v", b, forgotten)
+		}
+		if again, _ := db.ForgetPath(b.Path); again != nil {
+			t.Errorf("Unexpected forgotten song  \r\nExpected:  %v  \r\nActual:  %v", nil, again)
+		}
+
+		removed, _ := db.ForgetDirectory("/music/c/")
+		if removed != 1 {
+			t.Errorf("Unexpected removed count  \r\nExpected:  %v  \r\nActual:  %v", 1, removed)
+		}
+		songs, _ := db.FetchSongs()
+		hashes, _ := db.GetHashes()
+		if len(songs) != 1 || songs[0] != c || len(hashes) != 1 {
+			t.Errorf("Unexpected remains  \r\nExpected:  %v  \r\nActual:  %v %v", c, songs, hashes)
+		}
+	})
+
+	t.Run("Albums", func(t *testing.T) {
+		db := open(t)
+		for _, s := range []mp3util.Song{a, b, c} {
+			_ = db.RecordSong(s)
+		}
+		if err := db.RebuildAlbums(); err != nil {
+			t.Fatal(err)
+		}
+		albums, _ := db.FetchAlbums()
+		if len(albums) != 2 || albums[0].Folder != "/music/a" || len(albums[0].Tracks) != 2 {
+			t.Errorf("Unexpected albums  \r\nExpected:  %v  \r\nActual:  %v", "/music/a with 2 tracks, /music/b", albums)
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		db := open(t)
+		_ = db.RecordSong(a)
+		_ = db.CacheHash(a.Path, "h1", "")
+
+		changed, incomplete := a, b
+		changed.Title = "Changed"
+		incomplete.Artist = ""
+		_ = db.RecordSong(incomplete)
+
+		result, err := db.Import([]mp3util.Song{changed, b, c}, map[string]CachedHash{a.Path: {"h1", "f1"}}, Merge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ImportResult{Added: 1, Updated: 1, Unchanged: 1}
+		if result != expected {
+			t.Errorf("Unexpected result  \r\nExpected:  %v  \r\nActual:  %v", expected, result)
+		}
+		songs, _ := db.FetchSongs()
+		hashes, _ := db.GetHashes()
+		if len(songs) != 3 || hashes[a.Path].FileHash != "f1" {
+			t.Errorf("Unexpected import  \r\nExpected:  %v  \r\nActual:  %v %v", "3 songs, f1", songs, hashes)
+		}
+		if found, _ := db.FetchSong(b.Path); found == nil || found.Artist != b.Artist {
+			t.Errorf("Unexpected merged song  \r\nExpected:  %v  \r\nActual:  %v", b, found)
+		}
+	})
+
+	t.Run("Roots", func(t *testing.T) {
+		db := open(t)
+		windows := []mp3util.Song{song(`D:\Music\a\1.mp3`, "h1"), song(`D:\Music\b\3.mp3`, "h1")}
+		for _, s := range windows {
+			_ = db.RecordSong(s)
+		}
+		_ = db.CacheHash(windows[0].Path, "h1", "f1")
+
+		if err := db.AddRoot("music", `D:\Music`); err != nil {
+			t.Fatal(err)
+		}
+		for _, err := range []error{
+			db.AddRoot("music", "/elsewhere"),
+			db.AddRoot("not a name", "/elsewhere"),
+			db.RelocateRoot("nothing", "/elsewhere"),
+		} {
+			if err == nil {
+				t.Errorf("Unexpected error  \r\nExpected:  %v  \r\nActual:  %v", "an error", err)
+			}
+		}
+
+		if err := db.RelocateRoot("music", "/mnt/music"); err != nil {
+			t.Fatal(err)
+		}
+		roots, _ := db.FetchRoots()
+		if !reflect.DeepEqual(roots, []Root{{"music", "/mnt/music"}}) {
+			t.Errorf("Unexpected roots  \r\nExpected:  %v  \r\nActual:  %v", "music at /mnt/music", roots)
+		}
+		songs, _ := db.FetchSongs()
+		hashes, _ := db.GetHashes()
+		if len(songs) != 2 || songs[0].Path != "/mnt/music/a/1.mp3" || songs[1].Path != "/mnt/music/b/3.mp3" ||
+			hashes["/mnt/music/a/1.mp3"] != (CachedHash{"h1", "f1"}) {
+			t.Errorf("Unexpected relocated paths  \r\nExpected:  %v  \r\nActual:  %v %v", "/mnt/music/...", songs,
+				hashes)
+		}
+		if found, _ := db.FetchSong("/mnt/music/a/1.mp3"); found == nil || found.Path != "/mnt/music/a/1.mp3" {
+			t.Errorf("Unexpected song  \r\nExpected:  %v  \r\nActual:  %v", "/mnt/music/a/1.mp3", found)
+		}
+
+		_ = db.RecordSong(song("/mnt/music/b/4.mp3", "h4"))
+		_ = db.RebuildAlbums()
+		albums, _ := db.FetchAlbums()
+		if len(albums) != 2 || albums[1].Folder != "/mnt/music/b" || len(albums[1].Tracks) != 2 {
+			t.Errorf("Unexpected albums  \r\nExpected:  %v  \r\nActual:  %v", "/mnt/music/b with 2 tracks", albums)
+		}
+		if removed, _ := db.ForgetDirectory("/mnt/music/b"); removed != 2 {
+			t.Errorf("Unexpected removed count  \r\nExpected:  %v  \r\nActual:  %v", 2, removed)
+		}
+		if removed, _ := db.ForgetDirectory("/mnt"); removed != 1 {
+			t.Errorf("Unexpected removed count  \r\nExpected:  %v  \r\nActual:  %v", 1, removed)
+		}
+	})
+
+	t.Run("Collections", func(t *testing.T) {
+		db := open(t)
+		canonical, dj := a, b
+		canonical.Collection, dj.Collection = "canonical", "dj"
+		for _, s := range []mp3util.Song{canonical, dj, c} {
+			_ = db.RecordSong(s)
+			_ = db.CacheHash(s.Path, s.Hash, "")
+		}
+		if found, _ := db.FetchSong(dj.Path); found == nil || *found != dj {
+			t.Errorf("Unexpected song  \r\nExpected:  %v  \r\nActual:  %v", dj, found)
+		}
+
+		renamed, err := db.RenameCollection("dj", "crates")
+		if err != nil || renamed != 1 {
+			t.Errorf("Unexpected renamed count  \r\nExpected:  %v  \r\nActual:  %v %v", 1, renamed, err)
+		}
+		collections, _ := db.FetchCollections()
+		expected := []Collection{{"", 1}, {"canonical", 1}, {"crates", 1}}
+		if !reflect.DeepEqual(collections, expected) {
+			t.Errorf("Unexpected collections  \r\nExpected:  %v  \r\nActual:  %v", expected, collections)
+		}
+
+		dropped, err := db.DropCollection("canonical")
+		if err != nil || dropped != 1 {
+			t.Errorf("Unexpected dropped count  \r\nExpected:  %v  \r\nActual:  %v %v", 1, dropped, err)
+		}
+		songs, _ := db.FetchSongs()
+		hashes, _ := db.GetHashes()
+		if _, ok := hashes[a.Path]; len(songs) != 2 || ok {
+			t.Errorf("Unexpected remains  \r\nExpected:  %v  \r\nActual:  %v %v", "b and c", songs, hashes)
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		dir, err := ioutil.TempDir("", "filestore")
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := OpenStore(filepath.Join(dir, "library.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := db.(*FileStore); !ok {
+			t.Errorf("Unexpected store  \r\nExpected:  %v  \r\nActual:  %T", "*FileStore", db)
+		}
+		t.Cleanup(func() {
+			_ = db.Close()
+			_ = os.RemoveAll(dir)
+		})
+		return db
+	})
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.jsonl")
+
+	db, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		_ = db.RecordSong(mp3util.Song{Path: "/music/1.mp3", Title: fmt.Sprint(i)})
+	}
+	_ = db.CacheHash("/music/2.mp3", "h2", "f2")
+	_ = db.Close()
+
+	// a write cut off part way through
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString(`{"op":"song","song":{"Pa`)
+	_ = f.Close()
+
+	db, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	songs, _ := db.FetchSongs()
+	hashes, _ := db.GetHashes()
+	if len(songs) != 1 || songs[0].Title != "199" || hashes["/music/2.mp3"] != (CachedHash{"h2", "f2"}) {
+		t.Errorf("Unexpected contents  \r\nExpected:  %v  \r\nActual:  %v %v", "song 199 and h2", songs, hashes)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Unexpected compacted lines  \r\nExpected:  %v  \r\nActual:  %v", 3, lines)
+	}
+}
+
+func TestFileStoreTruncatesTornLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "library.jsonl")
+
+	// too small a store to be compacted when it's reopened
+	db, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.RecordSong(mp3util.Song{Path: "/music/1.mp3", Title: "One"})
+	_ = db.Close()
+
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString(`{"op":"song","song":{"Pa`)
+	_ = f.Close()
+
+	db, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.RecordSong(mp3util.Song{Path: "/music/2.mp3", Title: "Two"})
+	_ = db.Close()
+
+	db, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("The store couldn't be reopened after appending past a torn line:  %s", err)
+	}
+	defer db.Close()
+	songs, _ := db.FetchSongs()
+	if len(songs) != 2 {
+		t.Errorf("Unexpected songs  \r\nExpected:  %v  \r\nActual:  %v", 2, songs)
+	}
+}
+
+func TestFileStoreRejectsOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "other.jsonl")
+	_ = ioutil.WriteFile(path, []byte(`{"type":"meta"}`+"\n"), 0644)
+
+	_, err = OpenFileStore(path)
+	if err == nil {
+		t.Errorf("Unexpected error  \r\nExpected:  %v  \r\nActual:  %v", "not a smartmp3mgr store", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/lint"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type reportArtist struct {
+	Name   string
+	Albums []reportAlbum
+}
+
+type reportAlbum struct {
+	Name   string
+	Tracks []mp3util.Song
+}
+
+type reportData struct {
+	Generated   string
+	Stats       records.Stats
+	Artists     []reportArtist
+	Duplicates  [][]mp3util.Song
+	Violations  []lint.Violation
+	Incomplete  []library.CompletenessReport
+	TotalSize   string
+	TotalLength string
+	Reclaimable string
+}
+
+// report writes a self-contained HTML page describing the library, for people who'd rather not use the CLI.
+func report(stdout io.Writer, stderr io.Writer, args reportArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database:  %s\n", err)
+	}
+	duplicates, err := db.FetchDuplicates()
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	stats, err := db.Stats(args.top)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	data := reportData{
+		Generated:   time.Now().Format("2006-01-02 15:04"),
+		Stats:       stats,
+		Artists:     artistIndex(songs),
+		Duplicates:  duplicates,
+		Violations:  lint.Run(songs, lint.Config{}),
+		TotalSize:   formatBytes(stats.TotalSize),
+		TotalLength: (time.Duration(stats.TotalDuration) * time.Second).String(),
+		Reclaimable: formatBytes(stats.ReclaimableBytes),
+	}
+	for _, album := range library.MergeDiscFolders(library.GroupAlbums(songs)) {
+		if r, incomplete := library.CheckCompleteness(album); incomplete {
+			data.Incomplete = append(data.Incomplete, r)
+		}
+	}
+
+	out, err := os.Create(args.outPath)
+	if err != nil {
+		diePrintf(stderr, "failed to create %q:  %s\n", args.outPath, err)
+	}
+	err = reportTemplate.Execute(out, data)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		diePrintf(stderr, "failed to write %q:  %s\n", args.outPath, err)
+	}
+
+	_, _ = fmt.Fprintf(stdout, "Wrote a report on %d songs to %q\n", len(songs), args.outPath)
+}
+
+// artistIndex groups songs by album artist (or artist) and then album, both sorted ignoring case, with tracks in disc
+// and track order.
+func artistIndex(songs []mp3util.Song) []reportArtist {
+	byArtist := make(map[string]map[string][]mp3util.Song)
+	for _, song := range songs {
+		artist := song.AlbumArtist
+		if artist == "" {
+			artist = song.Artist
+		}
+		if artist == "" {
+			artist = "(no artist)"
+		}
+		album := song.Album
+		if album == "" {
+			album = "(no album)"
+		}
+		if byArtist[artist] == nil {
+			byArtist[artist] = make(map[string][]mp3util.Song)
+		}
+		byArtist[artist][album] = append(byArtist[artist][album], song)
+	}
+
+	var result []reportArtist
+	for artist, albums := range byArtist {
+		a := reportArtist{Name: artist}
+		for album, tracks := range albums {
+			sort.Slice(tracks, func(i, j int) bool {
+				if tracks[i].DiscNumber != tracks[j].DiscNumber {
+					return tracks[i].DiscNumber < tracks[j].DiscNumber
+				}
+				if tracks[i].TrackNumber != tracks[j].TrackNumber {
+					return tracks[i].TrackNumber < tracks[j].TrackNumber
+				}
+				return tracks[i].Path < tracks[j].Path
+			})
+			a.Albums = append(a.Albums, reportAlbum{album, tracks})
+		}
+		sort.Slice(a.Albums, func(i, j int) bool { return lessFold(a.Albums[i].Name, a.Albums[j].Name) })
+		result = append(result, a)
+	}
+	sort.Slice(result, func(i, j int) bool { return lessFold(result[i].Name, result[j].Name) })
+	return result
+}
+
+func lessFold(a, b string) bool {
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"track":    trackLabel,
+	"join":     joinInts,
+	"duration": func(seconds int) string { return fmt.Sprintf("%d:%02d", seconds/60, seconds%60) },
+	"matchKind": func(group []mp3util.Song, song mp3util.Song) string {
+		return song.MatchKind(group[0])
+	},
+}).Parse(reportHTML))
+
+const reportHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Music library</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+nav a { margin-right: 1em; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em; }
+td, th { padding: 0.2em 0.8em; text-align: left; vertical-align: top; }
+tr:nth-child(even) { background: #f4f4f4; }
+.artist { margin-top: 1.5em; }
+.album { margin-left: 1.5em; }
+.path, .muted { color: #888; font-size: 0.85em; }
+.error { color: #b00; }
+.warning { color: #a60; }
+#filter { font-size: 1.1em; padding: 0.3em; width: 30em; max-width: 100%; }
+</style>
+</head>
+<body>
+<h1>Music library</h1>
+<p class="muted">Generated {{.Generated}}</p>
+<nav><a href="#index">Index</a><a href="#duplicates">Duplicates</a><a href="#problems">Problems</a><a href="#stats">Statistics</a></nav>
+
+<h2 id="index">Index</h2>
+<input id="filter" type="search" placeholder="Filter by artist, album or title" autofocus>
+<div id="artists">
+{{range $artist := .Artists}}<div class="artist">
+<h3>{{.Name}}</h3>
+{{range $album := .Albums}}<div class="album">
+<h4>{{.Name}}</h4>
+<table>
+{{range .Tracks}}<tr class="track" data-text="{{$artist.Name}} {{$album.Name}} {{.Title}} {{.Artist}}"><td>{{track .}}</td><td>{{.Title}}</td><td>{{.Artist}}</td><td>{{duration .Duration}}</td><td class="path">{{.Path}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}</div>
+{{end}}</div>
+
+<h2 id="duplicates">Duplicates</h2>
+{{if .Duplicates}}{{range $group := .Duplicates}}<table>
+{{range .}}<tr><td>{{.Path}}</td><td class="muted">{{matchKind $group .}}</td></tr>
+{{end}}</table>
+{{end}}{{else}}<p>No duplicates.</p>{{end}}
+
+<h2 id="problems">Problems</h2>
+<h3>Incomplete albums</h3>
+{{if .Incomplete}}<table>
+<tr><th>Album</th><th>Problems</th><th>Folder</th></tr>
+{{range .Incomplete}}<tr><td>{{.AlbumArtist}} &ndash; {{.Album}}</td><td>
+{{if .MissingDiscs}}missing disc {{join .MissingDiscs}}<br>{{end}}
+{{range .Discs}}disc {{.Disc}}:
+{{if .MissingTracks}}missing track {{join .MissingTracks}};{{end}}
+{{if .DuplicateTracks}}more than one track {{join .DuplicateTracks}};{{end}}
+{{if .SuspectTracks}}suspect track number {{join .SuspectTracks}};{{end}}
+{{.Files}} files, {{.TotalTracks}} tracks<br>
+{{end}}</td><td class="path">{{.Folder}}</td></tr>
+{{end}}</table>
+{{else}}<p>Every album is complete.</p>{{end}}
+<h3>Tag problems</h3>
+{{if .Violations}}<table>
+<tr><th>Severity</th><th>Problem</th><th>Rule</th><th>Path</th></tr>
+{{range .Violations}}<tr><td class="{{.Severity}}">{{.Severity}}</td><td>{{.Message}}</td><td class="muted">{{.Rule}}</td><td class="path">{{.Path}}</td></tr>
+{{end}}</table>
+{{else}}<p>No tag problems.</p>{{end}}
+
+<h2 id="stats">Statistics</h2>
+<table>
+<tr><td>Files</td><td>{{.Stats.Files}}</td></tr>
+<tr><td>Albums</td><td>{{.Stats.Albums}}</td></tr>
+<tr><td>Artists</td><td>{{.Stats.Artists}}</td></tr>
+<tr><td>Genres</td><td>{{.Stats.Genres}}</td></tr>
+<tr><td>Total size</td><td>{{.TotalSize}}</td></tr>
+<tr><td>Total duration</td><td>{{.TotalLength}}</td></tr>
+<tr><td>Untagged files</td><td>{{.Stats.Untagged}}</td></tr>
+<tr><td>Duplicate files</td><td>{{.Stats.DuplicateFiles}}</td></tr>
+<tr><td>Reclaimable</td><td>{{.Reclaimable}}</td></tr>
+</table>
+<h3>Top artists</h3>
+<table>{{range .Stats.TopArtists}}<tr><td>{{.Value}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<h3>ID3 versions</h3>
+<table>{{range .Stats.TagFormats}}<tr><td>{{.Value}}</td><td>{{.Count}}</td></tr>{{end}}</table>
+<h3>Bitrates</h3>
+<table>{{range .Stats.Bitrates}}<tr><td>{{.Value}} kbps</td><td>{{.Count}}</td></tr>{{end}}</table>
+<h3>Sample rates</h3>
+<table>{{range .Stats.SampleRates}}<tr><td>{{.Value}} Hz</td><td>{{.Count}}</td></tr>{{end}}</table>
+
+<script>
+(function () {
+  var filter = document.getElementById("filter");
+  function matches(el, words) {
+    var text = el.getAttribute("data-text").toLowerCase();
+    return words.every(function (w) { return text.indexOf(w) >= 0; });
+  }
+  filter.addEventListener("input", function () {
+    var words = filter.value.toLowerCase().split(/\s+/).filter(Boolean);
+    document.querySelectorAll(".artist").forEach(function (artist) {
+      var anyAlbum = false;
+      artist.querySelectorAll(".album").forEach(function (album) {
+        var anyTrack = false;
+        album.querySelectorAll(".track").forEach(function (track) {
+          var hit = matches(track, words);
+          track.style.display = hit ? "" : "none";
+          anyTrack = anyTrack || hit;
+        });
+        album.style.display = anyTrack ? "" : "none";
+        anyAlbum = anyAlbum || anyTrack;
+      });
+      artist.style.display = anyAlbum ? "" : "none";
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`
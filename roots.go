@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rootPath makes a root's path absolute, unless it's already absolute or is a Windows path, which might be where the
+// database is headed rather than where it is.
+func rootPath(path string) (string, error) {
+	if filepath.IsAbs(path) || strings.Contains(path, `\`) || (len(path) >= 2 && path[1] == ':') {
+		return path, nil
+	}
+	return filepath.Abs(path)
+}
+
+// listRoots prints the library roots, a name and path per line.
+func listRoots(stdout io.Writer, stderr io.Writer, args rootsArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	roots, err := db.FetchRoots()
+	if err != nil {
+		diePrintf(stderr, "error reading roots:  %s\n", err)
+	}
+	for _, root := range roots {
+		_, _ = fmt.Fprintf(stdout, "%s\t%s\n", root.Name, root.Path)
+	}
+}
+
+// addRoot names a library folder, so that what's recorded under it is stored relative to it.
+func addRoot(stdout io.Writer, stderr io.Writer, args rootArgs) {
+	changeRoot(stdout, stderr, args, "Added", records.Store.AddRoot)
+}
+
+// relocateRoot moves a root, so that everything recorded under it is under the new path.
+func relocateRoot(stdout io.Writer, stderr io.Writer, args rootArgs) {
+	changeRoot(stdout, stderr, args, "Relocated", records.Store.RelocateRoot)
+}
+
+func changeRoot(stdout io.Writer, stderr io.Writer, args rootArgs, done string,
+	change func(db records.Store, name string, path string) error) {
+	path, err := rootPath(args.path)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		_, _ = fmt.Fprintf(stderr, "warning:  %q doesn't exist here\n", path)
+	}
+
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	err = change(db, args.name, path)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database %q:  %s\n", args.dbPath, err)
+	}
+	under := 0
+	for _, song := range songs {
+		if isUnder(song.Path, path) {
+			under++
+		}
+	}
+	_, _ = fmt.Fprintf(stdout, "%s root %s at %s (%d songs under it)\n", done, args.name, path, under)
+}
+
+// recordRoot adds the root record was asked to for the directory it's recording, or warns that paths outside every
+// root are stored whole, where relocate-root can't move them.
+func recordRoot(stdout io.Writer, stderr io.Writer, db records.Store, args recordArgs) {
+	directory, err := filepath.Abs(args.directory)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	roots, err := db.FetchRoots()
+	if err != nil {
+		diePrintf(stderr, "error reading roots:  %s\n", err)
+	}
+	for _, root := range roots {
+		if directory == strings.TrimRight(root.Path, `/\`) || isUnder(directory, root.Path) {
+			return
+		}
+	}
+
+	if args.root == "" {
+		_, _ = fmt.Fprintf(stderr, "warning:  %q isn't under any root, so if the library moves its songs will have "+
+			"to be recorded again; give record -root NAME to add one\n", directory)
+		return
+	}
+	err = db.AddRoot(args.root, directory)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	_, _ = fmt.Fprintf(stdout, "Added root %s at %s\n", args.root, directory)
+}
+
+// isUnder says whether path is inside the folder dir, whichever separators they use.
+func isUnder(path string, dir string) bool {
+	prefix := strings.TrimRight(dir, `/\`)
+	return strings.HasPrefix(path, prefix) && len(path) > len(prefix) &&
+		(path[len(prefix)] == '/' || path[len(prefix)] == '\\')
+}
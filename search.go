@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+)
+
+// search prints the best full-text matches for the given words, ignoring case and accents.
+func search(stdout io.Writer, stderr io.Writer, args searchArgs) {
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	results, err := db.Search(args.text, args.limit)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	var songs []mp3util.Song
+	for _, r := range results {
+		songs = append(songs, r.Song)
+	}
+	err = writeSongs(stdout, args.format, songs)
+	if err != nil {
+		diePrintf(stderr, "failed to write results:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stderr, "(%d songs)\n", len(songs))
+}
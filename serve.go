@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/api"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"github.com/caseyjmorris/smartmp3mgr/subsonic"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// serve answers API requests (see package api), and Subsonic requests on their own address if a Subsonic user is set
+// up, until stop receives a signal, then lets requests and scans in progress finish.  The API has no authentication,
+// so it's meant to stay on a loopback address; Subsonic, which checks its user's password, is what to expose.
+func serve(stdout io.Writer, stderr io.Writer, args serveArgs, stop <-chan os.Signal) {
+	db, err := records.Open(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	if !isLoopback(args.addr) {
+		_, _ = fmt.Fprintf(stderr, "warning:  the API on %s has no authentication; anyone who can reach it can read "+
+			"the library and start scans\n", args.addr)
+	}
+
+	server := api.NewServer(db, args.directories)
+	server.MaxUpload = args.maxUpload << 20
+	servers := []*http.Server{{Addr: args.addr, Handler: server}}
+	if args.subsonicUser != "" {
+		// Subsonic browses the albums as recorded, which imports and older databases may have left out of date
+		err = db.RebuildAlbums()
+		if err != nil {
+			diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+		}
+		servers = append(servers, &http.Server{Addr: args.subsonicAddr,
+			Handler: subsonic.NewServer(db, args.subsonicUser, args.subsonicPassword)})
+	}
+
+	failed := make(chan error, len(servers))
+	for _, httpServer := range servers {
+		go func(httpServer *http.Server) {
+			failed <- httpServer.ListenAndServe()
+		}(httpServer)
+	}
+	_, _ = fmt.Fprintf(stdout, "Serving %q on http://%s\n", args.dbPath, args.addr)
+	if args.subsonicUser != "" {
+		_, _ = fmt.Fprintf(stdout, "Serving Subsonic on http://%s/rest/\n", args.subsonicAddr)
+	}
+
+	select {
+	case err = <-failed:
+		diePrintln(stderr, err)
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, httpServer := range servers {
+		err = httpServer.Shutdown(ctx)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "error shutting down:  %s\n", err)
+		}
+	}
+	server.Wait()
+	_, _ = fmt.Fprintln(stdout, "Stopped serving")
+}
+
+// isLoopback says whether addr only listens on this machine.  An empty host (":8080") listens everywhere.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// stats summarises the library, as a table or as JSON for tracking it over time.
+func stats(stdout io.Writer, stderr io.Writer, args statsArgs) {
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	s, err := db.Stats(args.top)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	if args.format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(s)
+		if err != nil {
+			diePrintf(stderr, "failed to write results:  %s\n", err)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	for _, row := range []struct {
+		label string
+		value interface{}
+	}{
+		{"Files", s.Files},
+		{"Albums", s.Albums},
+		{"Artists", s.Artists},
+		{"Genres", s.Genres},
+		{"Total size", formatBytes(s.TotalSize)},
+		{"Total duration", time.Duration(s.TotalDuration) * time.Second},
+		{"Untagged files", s.Untagged},
+		{"Duplicate files", s.DuplicateFiles},
+		{"Reclaimable", formatBytes(s.ReclaimableBytes)},
+	} {
+		_, _ = fmt.Fprintf(tw, "%s\t%v\n", row.label, row.value)
+	}
+
+	for _, section := range []struct {
+		title  string
+		counts []records.Count
+		suffix string
+	}{
+		{"Top artists", s.TopArtists, ""},
+		{"ID3 versions", s.TagFormats, ""},
+		{"Bitrates", s.Bitrates, " kbps"},
+		{"Sample rates", s.SampleRates, " Hz"},
+	} {
+		_, _ = fmt.Fprintf(tw, "\n%s\t\n", section.title)
+		for _, c := range section.counts {
+			_, _ = fmt.Fprintf(tw, "  %s%s\t%d\n", c.Value, section.suffix, c.Count)
+		}
+	}
+	_ = tw.Flush()
+}
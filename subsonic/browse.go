@@ -0,0 +1,354 @@
+package subsonic
+
+import (
+	"encoding/base64"
+	"github.com/caseyjmorris/smartmp3mgr/library"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const ignoredArticles = "The El La Los Las Le Les"
+
+// IDs are a prefix saying what they identify and the name it's identified by, so nothing needs storing to look them
+// up again.
+const (
+	artistPrefix = "ar-"
+	albumPrefix  = "al-"
+	songPrefix   = "so-"
+)
+
+func encodeID(prefix string, name string) string {
+	return prefix + base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+func decodeID(prefix string, id string) (string, bool) {
+	if !strings.HasPrefix(id, prefix) {
+		return "", false
+	}
+	name, err := base64.RawURLEncoding.DecodeString(id[len(prefix):])
+	return string(name), err == nil
+}
+
+func artistID(name string) string {
+	return encodeID(artistPrefix, name)
+}
+
+func albumID(album library.Album) string {
+	return encodeID(albumPrefix, album.Folder+"\x00"+album.Album)
+}
+
+// fetchAlbum looks up the album an album ID identifies, returning nil if there isn't one.
+func (s *Server) fetchAlbum(id string) (*library.Album, error) {
+	name, _ := decodeID(albumPrefix, id)
+	i := strings.Index(name, "\x00")
+	if i < 0 {
+		return nil, nil
+	}
+	return s.db.FetchAlbum(name[:i], name[i+1:])
+}
+
+func songID(path string) string {
+	return encodeID(songPrefix, path)
+}
+
+// catalogue is the library as Subsonic sees it:  artists (by album artist) with their albums.
+type catalogue struct {
+	albums  []library.Album
+	artists map[string][]library.Album
+	// albumOf maps a path to its album
+	albumOf map[string]library.Album
+}
+
+func (s *Server) load() (catalogue, error) {
+	var c catalogue
+	albums, err := s.db.FetchAlbums()
+	if err != nil {
+		return c, err
+	}
+	c.albums = albums
+	c.artists = make(map[string][]library.Album)
+	c.albumOf = make(map[string]library.Album)
+	for _, album := range c.albums {
+		c.artists[album.AlbumArtist] = append(c.artists[album.AlbumArtist], album)
+		for _, track := range album.Tracks {
+			c.albumOf[track.Path] = album
+		}
+	}
+	for _, albums := range c.artists {
+		sort.Slice(albums, func(i, j int) bool { return lessFold(albums[i].Album, albums[j].Album) })
+	}
+	return c, nil
+}
+
+func (c catalogue) artist(name string) Artist {
+	return Artist{ID: artistID(name), Name: name, AlbumCount: len(c.artists[name])}
+}
+
+func (c catalogue) artistNames() []string {
+	var names []string
+	for name := range c.artists {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return lessFold(sortName(names[i]), sortName(names[j])) })
+	return names
+}
+
+func (c catalogue) album(album library.Album) Album {
+	a := Album{
+		ID:        albumID(album),
+		Name:      album.Album,
+		Artist:    album.AlbumArtist,
+		ArtistID:  artistID(album.AlbumArtist),
+		CoverArt:  albumID(album),
+		SongCount: len(album.Tracks),
+		Genre:     album.Tracks[0].Genre,
+	}
+	for _, track := range album.Tracks {
+		a.Duration += track.Duration
+	}
+	if info, err := os.Stat(album.Folder); err == nil {
+		a.Created = info.ModTime().UTC().Format(time.RFC3339)
+	}
+	return a
+}
+
+func (c catalogue) child(song mp3util.Song) Child {
+	child := Child{
+		ID:          songID(song.Path),
+		Title:       song.Title,
+		Album:       song.Album,
+		Artist:      song.Artist,
+		Track:       song.TrackNumber,
+		DiscNumber:  song.DiscNumber,
+		Genre:       song.Genre,
+		CoverArt:    songID(song.Path),
+		Size:        song.Size,
+		ContentType: "audio/mpeg",
+		Suffix:      strings.TrimPrefix(strings.ToLower(filepath.Ext(song.Path)), "."),
+		Duration:    song.Duration,
+		BitRate:     song.Bitrate,
+		Path:        song.Path,
+		Type:        "music",
+	}
+	if child.Title == "" {
+		child.Title = strings.TrimSuffix(filepath.Base(song.Path), filepath.Ext(song.Path))
+	}
+	if album, ok := c.albumOf[song.Path]; ok {
+		child.Parent = albumID(album)
+		child.AlbumID = albumID(album)
+		child.ArtistID = artistID(album.AlbumArtist)
+		child.CoverArt = albumID(album)
+	}
+	return child
+}
+
+// indexes groups the artists by first letter, ignoring articles, with anything that doesn't start with a letter under
+// "#".
+func (c catalogue) indexes() []Index {
+	var result []Index
+	for _, name := range c.artistNames() {
+		key := "#"
+		if r := []rune(sortName(name)); len(r) > 0 && unicode.IsLetter(r[0]) {
+			key = strings.ToUpper(string(r[0]))
+		}
+		if len(result) == 0 || result[len(result)-1].Name != key {
+			result = append(result, Index{Name: key})
+		}
+		last := &result[len(result)-1]
+		last.Artists = append(last.Artists, c.artist(name))
+	}
+	return result
+}
+
+func sortName(name string) string {
+	for _, article := range strings.Fields(ignoredArticles) {
+		if len(name) > len(article)+1 && strings.EqualFold(name[:len(article)+1], article+" ") {
+			return name[len(article)+1:]
+		}
+	}
+	return name
+}
+
+func lessFold(a, b string) bool {
+	if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+func (s *Server) getMusicFolders(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	response := newResponse()
+	response.MusicFolders = &MusicFolders{[]MusicFolder{{1, "Library"}}}
+	writeResponse(w, params, response)
+}
+
+func (s *Server) getIndexes(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	c, err := s.load()
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	response := newResponse()
+	response.Indexes = &Indexes{
+		LastModified:    time.Now().UnixNano() / int64(time.Millisecond),
+		IgnoredArticles: ignoredArticles,
+		Indexes:         c.indexes(),
+	}
+	writeResponse(w, params, response)
+}
+
+func (s *Server) getArtists(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	c, err := s.load()
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	response := newResponse()
+	response.Artists = &Indexes{IgnoredArticles: ignoredArticles, Indexes: c.indexes()}
+	writeResponse(w, params, response)
+}
+
+func (s *Server) getArtist(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	name, ok := decodeID(artistPrefix, params.Get("id"))
+	if !ok {
+		writeError(w, params, ErrMissingParameter, "An artist id is required")
+		return
+	}
+	c, err := s.load()
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	albums, ok := c.artists[name]
+	if !ok {
+		writeError(w, params, ErrNotFound, "Artist not found")
+		return
+	}
+
+	result := &ArtistAlbums{Artist: c.artist(name)}
+	for _, album := range albums {
+		result.Albums = append(result.Albums, c.album(album))
+	}
+	response := newResponse()
+	response.Artist = result
+	writeResponse(w, params, response)
+}
+
+func (s *Server) getAlbum(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	id := params.Get("id")
+	if _, ok := decodeID(albumPrefix, id); !ok {
+		writeError(w, params, ErrMissingParameter, "An album id is required")
+		return
+	}
+	album, err := s.fetchAlbum(id)
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	if album == nil {
+		writeError(w, params, ErrNotFound, "Album not found")
+		return
+	}
+
+	c := catalogue{albumOf: make(map[string]library.Album)}
+	result := &AlbumSongs{Album: c.album(*album)}
+	for _, track := range album.Tracks {
+		c.albumOf[track.Path] = *album
+		result.Songs = append(result.Songs, c.child(track))
+	}
+	response := newResponse()
+	response.Album = result
+	writeResponse(w, params, response)
+}
+
+// search3 matches artists and albums whose names contain every word of the query, and songs through the full-text
+// index.  An empty query (or "") matches everything, which some players use to copy the whole library.
+func (s *Server) search3(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	query := strings.Trim(strings.TrimSpace(params.Get("query")), `"`)
+	artistCount, artistOffset := intParam(params, "artistCount", 20), intParam(params, "artistOffset", 0)
+	albumCount, albumOffset := intParam(params, "albumCount", 20), intParam(params, "albumOffset", 0)
+	songCount, songOffset := intParam(params, "songCount", 20), intParam(params, "songOffset", 0)
+
+	c, err := s.load()
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	words := strings.Fields(strings.ToLower(query))
+	result := &SearchResult3{}
+
+	var artists []Artist
+	for _, name := range c.artistNames() {
+		if containsAll(name, words) {
+			artists = append(artists, c.artist(name))
+		}
+	}
+	lo, hi := page(len(artists), artistOffset, artistCount)
+	result.Artists = artists[lo:hi]
+
+	var albums []library.Album
+	for _, album := range c.albums {
+		if containsAll(album.Album, words) {
+			albums = append(albums, album)
+		}
+	}
+	lo, hi = page(len(albums), albumOffset, albumCount)
+	for _, album := range albums[lo:hi] {
+		result.Albums = append(result.Albums, c.album(album))
+	}
+
+	var songs []mp3util.Song
+	if len(words) == 0 {
+		songs, err = s.db.FetchSongs()
+		if err != nil {
+			writeError(w, params, ErrGeneric, err.Error())
+			return
+		}
+		sort.Slice(songs, func(i, j int) bool { return songs[i].Path < songs[j].Path })
+	} else {
+		found, err := s.db.Search(query, songOffset+songCount)
+		if err != nil {
+			writeError(w, params, ErrGeneric, err.Error())
+			return
+		}
+		for _, f := range found {
+			songs = append(songs, f.Song)
+		}
+	}
+	lo, hi = page(len(songs), songOffset, songCount)
+	for _, song := range songs[lo:hi] {
+		result.Songs = append(result.Songs, c.child(song))
+	}
+
+	response := newResponse()
+	response.SearchResult3 = result
+	writeResponse(w, params, response)
+}
+
+func containsAll(s string, words []string) bool {
+	s = strings.ToLower(s)
+	for _, word := range words {
+		if !strings.Contains(s, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// page returns the bounds of the slice from offset of at most count items, out of n.
+func page(n int, offset int, count int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	if offset+count > n {
+		return offset, n
+	}
+	return offset, offset + count
+}
@@ -0,0 +1,114 @@
+package subsonic
+
+import (
+	"bytes"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cover images looked for in an album's folder when none of its tracks has one embedded, in order of preference
+var coverFiles = []string{"cover.jpg", "folder.jpg", "front.jpg", "cover.png", "folder.png", "front.png"}
+
+// stream sends the file as it is; there's no transcoding, so maxBitRate and format are ignored.  Only recorded songs
+// can be streamed.
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, params url.Values) {
+	path, ok := decodeID(songPrefix, params.Get("id"))
+	if !ok {
+		writeError(w, params, ErrMissingParameter, "A song id is required")
+		return
+	}
+	song, err := s.db.FetchSong(path)
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+	if song == nil {
+		writeError(w, params, ErrNotFound, "Song not found")
+		return
+	}
+
+	file, err := os.Open(song.Path)
+	if err != nil {
+		writeError(w, params, ErrNotFound, "The file is missing")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, params, ErrGeneric, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	http.ServeContent(w, r, filepath.Base(song.Path), info.ModTime(), file)
+}
+
+// getCoverArt sends the picture for an album or song:  the first one embedded in its tracks' tags, or else an image
+// file in its folder.  Pictures aren't resized, so size is ignored.
+func (s *Server) getCoverArt(w http.ResponseWriter, r *http.Request, params url.Values) {
+	id := params.Get("id")
+	var tracks []mp3util.Song
+	var folder string
+
+	if path, ok := decodeID(songPrefix, id); ok {
+		song, err := s.db.FetchSong(path)
+		if err != nil {
+			writeError(w, params, ErrGeneric, err.Error())
+			return
+		}
+		if song != nil {
+			tracks = []mp3util.Song{*song}
+			folder = filepath.Dir(song.Path)
+		}
+	} else if _, ok := decodeID(albumPrefix, id); ok {
+		album, err := s.fetchAlbum(id)
+		if err != nil {
+			writeError(w, params, ErrGeneric, err.Error())
+			return
+		}
+		if album != nil {
+			tracks = album.Tracks
+			folder = album.Folder
+		}
+	} else {
+		writeError(w, params, ErrMissingParameter, "An album or song id is required")
+		return
+	}
+	if folder == "" {
+		writeError(w, params, ErrNotFound, "Not found")
+		return
+	}
+
+	data, mimeType := findCover(tracks, folder)
+	if data == nil {
+		writeError(w, params, ErrNotFound, "No cover art")
+		return
+	}
+	w.Header().Set("Content-Type", mimeType)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func findCover(tracks []mp3util.Song, folder string) ([]byte, string) {
+	for _, track := range tracks {
+		data, mimeType, err := mp3util.ReadPicture(track.Path)
+		if err == nil && data != nil {
+			if mimeType == "" {
+				mimeType = http.DetectContentType(data)
+			}
+			return data, mimeType
+		}
+	}
+	for _, name := range coverFiles {
+		data, err := ioutil.ReadFile(filepath.Join(folder, name))
+		if err == nil {
+			return data, mime.TypeByExtension(filepath.Ext(name))
+		}
+	}
+	return nil, ""
+}
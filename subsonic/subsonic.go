@@ -0,0 +1,234 @@
+// Package subsonic implements the core of the Subsonic REST API over the records database, so that Subsonic players
+// can browse and stream the library:  ping, getMusicFolders, getIndexes, getArtists, getArtist, getAlbum, search3,
+// stream and getCoverArt.
+//
+// Artists and albums are worked out from Songs on each request, the same way as album-dupes and friends (see
+// library.GroupAlbums), and their IDs encode their names, so IDs stay the same as long as the tags and folders do.
+// Songs without an Album tag can only be found through search3.
+package subsonic
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the Subsonic API implemented.
+const Version = "1.16.1"
+
+// Subsonic error codes
+const (
+	ErrGeneric          = 0
+	ErrMissingParameter = 10
+	ErrWrongCredentials = 40
+	ErrNotFound         = 70
+)
+
+// Server answers Subsonic requests under /rest/ for a single user.
+type Server struct {
+	Username, Password string
+
+	db      *records.RecordKeeper
+	methods map[string]func(w http.ResponseWriter, r *http.Request, params url.Values)
+}
+
+// NewServer makes a server over db, which it doesn't close.  It needs SQLite, so it's only any use in builds
+// with cgo.
+func NewServer(db *records.RecordKeeper, username string, password string) *Server {
+	s := &Server{Username: username, Password: password, db: db}
+	s.methods = map[string]func(http.ResponseWriter, *http.Request, url.Values){
+		"ping":            s.ping,
+		"getMusicFolders": s.getMusicFolders,
+		"getIndexes":      s.getIndexes,
+		"getArtists":      s.getArtists,
+		"getArtist":       s.getArtist,
+		"getAlbum":        s.getAlbum,
+		"search3":         s.search3,
+		"stream":          s.stream,
+		"download":        s.stream,
+		"getCoverArt":     s.getCoverArt,
+	}
+	return s
+}
+
+// ServeHTTP answers /rest/METHOD and /rest/METHOD.view, with parameters in the query string or a form body.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	params := r.Form
+
+	if !s.authenticate(params) {
+		writeError(w, params, ErrWrongCredentials, "Wrong username or password")
+		return
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/rest/"), ".view")
+	method, ok := s.methods[name]
+	if !ok {
+		writeError(w, params, ErrNotFound, "Unknown method "+name)
+		return
+	}
+	method(w, r, params)
+}
+
+// authenticate accepts a password (p, plain or "enc:" and hex) or a token (t, the MD5 of the password and the salt s).
+func (s *Server) authenticate(params url.Values) bool {
+	if params.Get("u") != s.Username {
+		return false
+	}
+	if token := params.Get("t"); token != "" {
+		sum := md5.Sum([]byte(s.Password + params.Get("s")))
+		return subtle.ConstantTimeCompare([]byte(strings.ToLower(token)), []byte(hex.EncodeToString(sum[:]))) == 1
+	}
+	password := params.Get("p")
+	if strings.HasPrefix(password, "enc:") {
+		decoded, err := hex.DecodeString(password[len("enc:"):])
+		if err != nil {
+			return false
+		}
+		password = string(decoded)
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(s.Password)) == 1
+}
+
+func (s *Server) ping(w http.ResponseWriter, _ *http.Request, params url.Values) {
+	writeResponse(w, params, newResponse())
+}
+
+// Response is the subsonic-response element; only the field for the method called is set.
+type Response struct {
+	XMLName xml.Name `xml:"subsonic-response" json:"-"`
+	Xmlns   string   `xml:"xmlns,attr" json:"-"`
+	Status  string   `xml:"status,attr" json:"status"`
+	Version string   `xml:"version,attr" json:"version"`
+
+	Error         *Error         `xml:"error,omitempty" json:"error,omitempty"`
+	MusicFolders  *MusicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *Indexes       `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists       *Indexes       `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *ArtistAlbums  `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *AlbumSongs    `xml:"album,omitempty" json:"album,omitempty"`
+	SearchResult3 *SearchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+}
+
+type Error struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+type MusicFolders struct {
+	MusicFolders []MusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Indexes is used for both getIndexes and getArtists, which differ only in the element name.
+type Indexes struct {
+	LastModified    int64   `xml:"lastModified,attr,omitempty" json:"lastModified,omitempty"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []Index `xml:"index" json:"index"`
+}
+
+type Index struct {
+	Name    string   `xml:"name,attr" json:"name"`
+	Artists []Artist `xml:"artist" json:"artist"`
+}
+
+type Artist struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+}
+
+type ArtistAlbums struct {
+	Artist
+	Albums []Album `xml:"album" json:"album"`
+}
+
+type Album struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr" json:"artist"`
+	ArtistID  string `xml:"artistId,attr" json:"artistId"`
+	CoverArt  string `xml:"coverArt,attr" json:"coverArt"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Genre     string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	Created   string `xml:"created,attr" json:"created"`
+}
+
+type AlbumSongs struct {
+	Album
+	Songs []Child `xml:"song" json:"song"`
+}
+
+// Child is a song.
+type Child struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr" json:"size"`
+	ContentType string `xml:"contentType,attr" json:"contentType"`
+	Suffix      string `xml:"suffix,attr" json:"suffix"`
+	Duration    int    `xml:"duration,attr" json:"duration"`
+	BitRate     int    `xml:"bitRate,attr" json:"bitRate"`
+	Path        string `xml:"path,attr" json:"path"`
+	Type        string `xml:"type,attr" json:"type"`
+	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+}
+
+type SearchResult3 struct {
+	Artists []Artist `xml:"artist" json:"artist"`
+	Albums  []Album  `xml:"album" json:"album"`
+	Songs   []Child  `xml:"song" json:"song"`
+}
+
+func newResponse() Response {
+	return Response{Xmlns: "http://subsonic.org/restapi", Status: "ok", Version: Version}
+}
+
+// writeResponse writes XML, or JSON if the client asked for it with f=json.  Failures are still HTTP 200s, as the
+// protocol expects.
+func writeResponse(w http.ResponseWriter, params url.Values, response Response) {
+	if params.Get("f") == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(map[string]Response{"subsonic-response": response})
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, params url.Values, code int, message string) {
+	response := newResponse()
+	response.Status = "failed"
+	response.Error = &Error{code, message}
+	writeResponse(w, params, response)
+}
+
+// intParam reads an optional number, using fallback if it's missing or nonsense.
+func intParam(params url.Values, name string, fallback int) int {
+	n, err := strconv.Atoi(params.Get(name))
+	if err != nil || n < 0 {
+		return fallback
+	}
+	return n
+}
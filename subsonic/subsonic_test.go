@@ -0,0 +1,216 @@
+//go:build cgo
+// +build cgo
+
+package subsonic
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"github.com/caseyjmorris/smartmp3mgr/testHelpers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestServer records the MP3s in directory into an in-memory database and serves it as user "u" with password
+// "secret".
+func newTestServer(t *testing.T, name string, directory string) *httptest.Server {
+	db, err := records.Open(fmt.Sprintf("file:%s.db?cache=shared&mode=memory", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, _ := mp3fileutil.FindMP3Files(directory)
+	for _, file := range files {
+		song, err := mp3util.ParseMP3(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = db.RecordSong(song)
+	}
+
+	ts := httptest.NewServer(NewServer(db, "u", "secret"))
+	t.Cleanup(func() {
+		ts.Close()
+		_ = db.Close()
+	})
+	return ts
+}
+
+func methodURL(ts *httptest.Server, method string, params url.Values) string {
+	sum := md5.Sum([]byte("secret" + "salt"))
+	params.Set("u", "u")
+	params.Set("t", hex.EncodeToString(sum[:]))
+	params.Set("s", "salt")
+	params.Set("v", Version)
+	params.Set("c", "test")
+	return ts.URL + "/rest/" + method + ".view?" + params.Encode()
+}
+
+// call makes a request with f=json and returns the response, failing unless its status is ok.
+func call(t *testing.T, ts *httptest.Server, method string, params url.Values) Response {
+	params.Set("f", "json")
+	res, err := http.Get(methodURL(ts, method, params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var wrapper map[string]Response
+	err = json.NewDecoder(res.Body).Decode(&wrapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := wrapper["subsonic-response"]
+	if response.Status != "ok" {
+		t.Fatalf("%s failed:  %+v", method, response.Error)
+	}
+	return response
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t, "subsonicauth", testHelpers.GetFixturePath(""))
+
+	for _, c := range []struct {
+		query    string
+		expected string
+	}{
+		{"u=u&p=secret", "ok"},
+		{"u=u&p=enc:" + hex.EncodeToString([]byte("secret")), "ok"},
+		{"u=u&p=wrong", "failed"},
+		{"u=someone&p=secret", "failed"},
+		{"u=u&t=0123&s=salt", "failed"},
+	} {
+		res, err := http.Get(ts.URL + "/rest/ping.view?" + c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response Response
+		err = xml.NewDecoder(res.Body).Decode(&response)
+		_ = res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Status != c.expected {
+			t.Errorf("Wrong status for %s  \r\nExpected:  %v  \r\nActual:  %v", c.query, c.expected, response.Status)
+		}
+		if c.expected == "failed" && (response.Error == nil || response.Error.Code != ErrWrongCredentials) {
+			t.Errorf("Expected a wrong credentials error for %s:  %+v", c.query, response.Error)
+		}
+	}
+
+	call(t, ts, "ping", url.Values{})
+}
+
+func TestBrowse(t *testing.T) {
+	ts := newTestServer(t, "subsonicbrowse", testHelpers.GetFixturePath(""))
+
+	folders := call(t, ts, "getMusicFolders", url.Values{}).MusicFolders
+	if folders == nil || len(folders.MusicFolders) != 1 {
+		t.Errorf("Expected one music folder:  %+v", folders)
+	}
+
+	artists := call(t, ts, "getArtists", url.Values{}).Artists
+	if artists == nil || len(artists.Indexes) != 2 || artists.Indexes[0].Name != "B" ||
+		artists.Indexes[0].Artists[0].Name != "Bryan Teoh" || artists.Indexes[0].Artists[0].AlbumCount != 1 {
+		t.Fatalf("Unexpected artists:  %+v", artists)
+	}
+	indexes := call(t, ts, "getIndexes", url.Values{}).Indexes
+	if indexes == nil || len(indexes.Indexes) != 2 {
+		t.Errorf("Unexpected indexes:  %+v", indexes)
+	}
+
+	artist := call(t, ts, "getArtist", url.Values{"id": {artists.Indexes[0].Artists[0].ID}}).Artist
+	if artist == nil || len(artist.Albums) != 1 || artist.Albums[0].Name != "FreePD Music" {
+		t.Fatalf("Unexpected artist:  %+v", artist)
+	}
+
+	album := call(t, ts, "getAlbum", url.Values{"id": {artist.Albums[0].ID}}).Album
+	if album == nil || album.SongCount != 3 || len(album.Songs) != 3 {
+		t.Fatalf("Unexpected album:  %+v", album)
+	}
+	song := album.Songs[0]
+	if song.AlbumID != album.ID || song.ContentType != "audio/mpeg" || song.Suffix != "mp3" || song.Duration == 0 {
+		t.Errorf("Unexpected song:  %+v", song)
+	}
+}
+
+func TestSearch3(t *testing.T) {
+	ts := newTestServer(t, "subsonicsearch", testHelpers.GetFixturePath(""))
+
+	result := call(t, ts, "search3", url.Values{"query": {"spring"}}).SearchResult3
+	if result == nil || len(result.Songs) != 1 || result.Songs[0].Title != "Spring Chicken" {
+		t.Errorf("Unexpected results searching for spring:  %+v", result)
+	}
+
+	result = call(t, ts, "search3", url.Values{"query": {"freepd"}}).SearchResult3
+	if result == nil || len(result.Albums) != 2 || len(result.Artists) != 0 {
+		t.Errorf("Unexpected results searching for freepd:  %+v", result)
+	}
+
+	result = call(t, ts, "search3", url.Values{"query": {`""`}, "songCount": {"3"}, "songOffset": {"3"}}).SearchResult3
+	if result == nil || len(result.Songs) != 2 || len(result.Artists) != 2 {
+		t.Errorf("Expected the last page of every song:  %+v", result)
+	}
+}
+
+func TestStreamAndCoverArt(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "smartmp3mgr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	songPath := filepath.Join(dir, "1.mp3")
+	_ = mp3fileutil.CopyFile(testHelpers.GetFixturePath("spring-chicken.mp3"), songPath)
+	cover := []byte("\xff\xd8\xff not really a jpeg")
+	_ = ioutil.WriteFile(filepath.Join(dir, "cover.jpg"), cover, 0644)
+	ts := newTestServer(t, "subsonicstream", dir)
+
+	req, _ := http.NewRequest(http.MethodGet, methodURL(ts, "stream", url.Values{"id": {songID(songPath)}}), nil)
+	req.Header.Set("Range", "bytes=0-9")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	original, _ := ioutil.ReadFile(songPath)
+	if res.StatusCode != http.StatusPartialContent || string(b) != string(original[:10]) {
+		t.Errorf("Unexpected ranged stream:  %v %q", res.StatusCode, b)
+	}
+	if res.Header.Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("Wrong content type  \r\nExpected:  %v  \r\nActual:  %v", "audio/mpeg", res.Header.Get("Content-Type"))
+	}
+
+	// only recorded songs can be streamed
+	res, err = http.Get(methodURL(ts, "stream", url.Values{"id": {songID("/etc/passwd")}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	var response Response
+	if xml.Unmarshal(b, &response) != nil || response.Error == nil || response.Error.Code != ErrNotFound {
+		t.Errorf("Expected a not found error streaming an unrecorded file:  %s", b)
+	}
+
+	artist := call(t, ts, "getArtists", url.Values{}).Artists.Indexes[0].Artists[0].ID
+	coverArt := call(t, ts, "getArtist", url.Values{"id": {artist}}).Artist.Albums[0].CoverArt
+	res, err = http.Get(methodURL(ts, "getCoverArt", url.Values{"id": {coverArt}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if string(b) != string(cover) || res.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("Unexpected cover art:  %s %q", res.Header.Get("Content-Type"), b)
+	}
+}
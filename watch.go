@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"github.com/caseyjmorris/smartmp3mgr/mp3fileutil"
+	"github.com/caseyjmorris/smartmp3mgr/mp3util"
+	"github.com/caseyjmorris/smartmp3mgr/records"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchLibrary keeps the database in step with the directories as MP3s are added, changed, moved and deleted, until
+// stop receives a signal.  Changes are gathered until none have arrived for the debounce interval and then applied
+// together, so that copying in an album is one update rather than dozens.
+func watchLibrary(stdout io.Writer, stderr io.Writer, args watchArgs, stop <-chan os.Signal) {
+	for _, directory := range args.directories {
+		dieUnlessDirectoryExists(stderr, directory)
+	}
+
+	db, err := records.OpenStore(args.dbPath)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+	defer db.Close()
+
+	// watch before catching up, so that nothing changed during the scan is missed
+	watcher, err := mp3fileutil.NewWatcher(args.directories)
+	if err != nil {
+		diePrintln(stderr, err)
+	}
+
+	recorded, removed := catchUp(stderr, db, args.directories)
+	finishBatch(stdout, stderr, db, recorded, removed)
+	_, _ = fmt.Fprintf(stdout, "Watching %s\n", strings.Join(args.directories, ", "))
+
+	pending := make(map[string]bool)
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		recorded, removed := applyChanges(stderr, db, pending)
+		finishBatch(stdout, stderr, db, recorded, removed)
+		pending = make(map[string]bool)
+	}
+
+	timer := time.NewTimer(args.debounce)
+	timer.Stop()
+	errs, overflows := watcher.Errors, watcher.Overflows
+	for {
+		select {
+		case path, ok := <-watcher.Changes:
+			if !ok {
+				flush()
+				return
+			}
+			pending[path] = true
+			timer.Reset(args.debounce)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			_, _ = fmt.Fprintln(stderr, err)
+		case _, ok := <-overflows:
+			if !ok {
+				overflows = nil
+				continue
+			}
+			// the kernel dropped events, so there's no telling what changed; look at everything again
+			_, _ = fmt.Fprintln(stderr, "too many changes at once to follow; rescanning")
+			timer.Stop()
+			pending = make(map[string]bool)
+			recorded, removed := catchUp(stderr, db, args.directories)
+			finishBatch(stdout, stderr, db, recorded, removed)
+		case <-timer.C:
+			flush()
+		case <-stop:
+			_ = watcher.Close()
+			for path := range watcher.Changes {
+				pending[path] = true
+			}
+			flush()
+			_, _ = fmt.Fprintln(stdout, "Stopped watching")
+			return
+		}
+	}
+}
+
+// catchUp records MP3s under the directories that are new or have been modified since they were recorded, and forgets
+// songs under them whose files are gone.
+func catchUp(stderr io.Writer, db records.Store, directories []string) (recorded int, removed int) {
+	songs, err := db.FetchSongs()
+	if err != nil {
+		diePrintf(stderr, "Error reading database:  %s\n", err)
+	}
+	existing := make(map[string]mp3util.Song)
+	for _, song := range songs {
+		existing[song.Path] = song
+	}
+
+	seen := make(map[string]bool)
+	for _, directory := range directories {
+		files, err := mp3fileutil.FindMP3Files(directory)
+		if err != nil {
+			diePrintln(stderr, err)
+		}
+		for _, file := range files {
+			seen[file] = true
+			info, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			// retagging often leaves the size alone, so the modification time has to match too
+			if song, ok := existing[file]; ok && song.FileHash != "" && song.Size == info.Size() &&
+				song.ModTime == info.ModTime().UnixNano() {
+				continue
+			}
+			if recordFile(stderr, db, file) {
+				recorded++
+			}
+		}
+	}
+
+	for path := range existing {
+		if !seen[path] && underAny(path, directories) {
+			if _, err := db.ForgetPath(path); err != nil {
+				diePrintln(stderr, err)
+			}
+			removed++
+		}
+	}
+
+	return recorded, removed
+}
+
+func underAny(path string, directories []string) bool {
+	for _, directory := range directories {
+		prefix := strings.TrimSuffix(directory, string(filepath.Separator)) + string(filepath.Separator)
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyChanges brings the database up to date with whatever is now at each changed path:  nothing (so the song, or
+// everything under the directory, is forgotten), a directory (scanned), or an MP3 (recorded).
+func applyChanges(stderr io.Writer, db records.Store, changes map[string]bool) (recorded int, removed int) {
+	var paths []string
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			song, err := db.ForgetPath(path)
+			if err != nil {
+				diePrintln(stderr, err)
+			}
+			if song != nil {
+				removed++
+			}
+			n, err := db.ForgetDirectory(path)
+			if err != nil {
+				diePrintln(stderr, err)
+			}
+			removed += int(n)
+		case err != nil:
+			_, _ = fmt.Fprintf(stderr, "failed to read %q:  %s\n", path, err)
+		case info.IsDir():
+			files, err := mp3fileutil.FindMP3Files(path)
+			if err != nil {
+				_, _ = fmt.Fprintf(stderr, "failed to scan %q:  %s\n", path, err)
+			}
+			for _, file := range files {
+				if recordFile(stderr, db, file) {
+					recorded++
+				}
+			}
+		case strings.EqualFold(filepath.Ext(path), ".mp3"):
+			if recordFile(stderr, db, path) {
+				recorded++
+			}
+		}
+	}
+
+	return recorded, removed
+}
+
+// recordFile parses and records one MP3, reporting rather than dying on files that can't be read, since they may still
+// be being written.  A file that was already recorded stays in its collection.
+func recordFile(stderr io.Writer, db records.Store, path string) bool {
+	song, err := mp3util.ParseMP3(path)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "failed to read %q:  %s\n", path, err)
+		return false
+	}
+	existing, err := db.FetchSong(path)
+	if err == nil && existing != nil {
+		song.Collection = existing.Collection
+	}
+	err = db.RecordSong(song)
+	if err != nil {
+		diePrintf(stderr, "error saving %q:  %s\n", path, err)
+	}
+	return true
+}
+
+func finishBatch(stdout io.Writer, stderr io.Writer, db records.Store, recorded int, removed int) {
+	if recorded == 0 && removed == 0 {
+		return
+	}
+	// recording keeps albums up to date, but forgetting leaves them behind
+	if removed > 0 {
+		err := db.RebuildAlbums()
+		if err != nil {
+			diePrintf(stderr, "error rebuilding albums:  %s\n", err)
+		}
+	}
+	// a file store only syncs when it's closed, and watch can run for weeks
+	err := db.Sync()
+	if err != nil {
+		diePrintf(stderr, "error saving changes:  %s\n", err)
+	}
+	_, _ = fmt.Fprintf(stdout, "%s  recorded %d, removed %d\n", time.Now().Format("15:04:05"), recorded, removed)
+}